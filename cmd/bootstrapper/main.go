@@ -0,0 +1,42 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log/slog"
+    "net/netip"
+    "strconv"
+
+    "github.com/SLP25/ESR/internal/admin"
+    "github.com/SLP25/ESR/internal/bootstrapper"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+func main() {
+    utils.SetupLogging()
+
+    adminAddr := flag.String("admin", "", "serve the admin API (JSON over HTTP) on this address, e.g. :8080")
+    flag.Parse()
+
+    if flag.NArg() != 2 {
+        fmt.Println("Usage: bootstrapper [-admin <addr>] <port> <config>")
+        return
+    }
+
+    aux, err := strconv.ParseUint(flag.Arg(0), 10, 16)
+    if err != nil {
+        fmt.Println("Invalid port: the port must be an integer between 0 and 65535")
+        return
+    }
+    tcpPort := uint16(aux)
+
+    b := bootstrapper.New(bootstrapper.MustReadConfig(flag.Arg(1)))
+    if *adminAddr != "" {
+        admin.Start(*adminAddr, b)
+    }
+
+    err = b.Run(netip.Addr{}, tcpPort)
+    if err != nil {
+        slog.Error("Error running service", "err", err)
+    }
+}
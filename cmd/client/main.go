@@ -0,0 +1,170 @@
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "log/slog"
+    "net/netip"
+    "os"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "github.com/SLP25/ESR/internal/admin"
+    "github.com/SLP25/ESR/internal/client"
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/service"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+func main() {
+    utils.SetupLogging()
+
+    adminAddr := flag.String("admin", "", "serve the admin API (JSON over HTTP) on this address, e.g. :8080")
+    list := flag.Bool("list", false, "print the streams available in the network instead of playing one")
+    tracks := flag.String("tracks", "", "comma separated kinds of tracks to play (video, audio). All by default")
+    vod := flag.Bool("vod", false, "play an on-demand session of the stream from its start, instead of joining the shared stream")
+    start := flag.Duration("start", 0, "offset the on-demand session starts at (implies -vod)")
+    delay := flag.Duration("delay", client.DefaultPlayoutDelay, "playout delay of the jitter buffer, which reorders the packets and drops the late ones")
+    flag.Parse()
+
+    if (!*list && flag.NArg() != 2) || (*list && flag.NArg() != 1) {
+        fmt.Println("Usage: client [-admin <addr>] [-tracks video,audio] [-vod] [-start <offset>] [-delay <time>] <bootAddr> <streamID>")
+        fmt.Println("       client -list <bootAddr>")
+        return
+    }
+
+    bootAddr, err := netip.ParseAddrPort(flag.Arg(0))
+    if err != nil {
+        fmt.Println("Invalid boot address:", err)
+        return
+    }
+
+    if *list {
+        printCatalog(bootAddr)
+        return
+    }
+
+    c := client.New(bootAddr, flag.Arg(1))
+    if *tracks != "" {
+        c.SelectTracks(strings.Split(*tracks, ",")...)
+    }
+    c.SetPlayoutDelay(*delay)
+    if *vod || *start != 0 {
+        c.OnDemand(*start)
+    }
+
+    if *adminAddr != "" {
+        admin.Start(*adminAddr, c)
+    }
+
+    go readCommands(c)
+    err = c.Run(netip.Addr{})
+    if err != nil {
+        slog.Error("Error running service", "err", err)
+    }
+}
+
+func printCatalog(bootAddr netip.AddrPort) {
+    streams, err := client.List(service.NetTransport{}, bootAddr)
+    if err != nil {
+        fmt.Println("Unable to get the catalog:", err)
+        return
+    }
+
+    if len(streams) == 0 {
+        fmt.Println("No streams available")
+        return
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "STREAM\tBITRATE\tDURATION\tTRACKS")
+    for _, e := range streams {
+        duration := "live"
+        if e.Stream.Duration != 0 {
+            duration = e.Stream.Duration.Round(time.Second).String()
+        }
+
+        tracks := make([]string, 0, len(e.Stream.Tracks))
+        for _, t := range e.Stream.Tracks {
+            tracks = append(tracks, t.String())
+        }
+        fmt.Fprintf(w, "%s\t%.0f kbps\t%s\t%s\n", e.StreamID, float64(e.Stream.Bitrate) / 1000, duration, strings.Join(tracks, ", "))
+    }
+    w.Flush()
+}
+
+const commandsHelp = `Playback commands (only for the sole viewer of a stream):
+  pause, p             pause the stream
+  resume, r            resume the stream
+  seek <offset>        move to the offset (e.g. 90, 1m30s)
+  +<offset>, -<offset> skip forwards or backwards (e.g. +10s)
+  status, s            print the position of the stream`
+
+//Reads playback commands from the standard input until it ends
+func readCommands(c *client.Client) {
+    scanner := bufio.NewScanner(os.Stdin)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 0 {
+            continue
+        }
+
+        action, offset, err := parseCommand(fields)
+        if err != nil {
+            fmt.Println(err)
+            fmt.Println(commandsHelp)
+            continue
+        }
+
+        resp, err := c.Control(action, offset)
+        if err != nil {
+            fmt.Printf("Unable to %s: %s\n", action, err)
+            continue
+        }
+
+        state := "playing"
+        if resp.Paused {
+            state = "paused"
+        }
+        if resp.Duration == 0 {
+            fmt.Printf("Live stream, %s\n", state)
+        } else {
+            fmt.Printf("Position %s / %s, %s\n", resp.Position.Round(time.Second), resp.Duration.Round(time.Second), state)
+        }
+    }
+}
+
+func parseCommand(fields []string) (packet.PlaybackAction, time.Duration, error) {
+    switch cmd := fields[0]; {
+    case cmd == "pause" || cmd == "p":
+        return packet.Pause, 0, nil
+    case cmd == "resume" || cmd == "r":
+        return packet.Resume, 0, nil
+    case cmd == "status" || cmd == "s":
+        return packet.Status, 0, nil
+    case cmd == "seek" && len(fields) == 2:
+        offset, err := parseOffset(fields[1])
+        return packet.Seek, offset, err
+    case strings.HasPrefix(cmd, "+") || strings.HasPrefix(cmd, "-"):
+        offset, err := parseOffset(cmd)
+        return packet.Skip, offset, err
+    default:
+        return 0, 0, fmt.Errorf("Unknown command '%s'", strings.Join(fields, " "))
+    }
+}
+
+//Parses an offset in seconds (90) or as a duration (1m30s)
+func parseOffset(s string) (time.Duration, error) {
+    if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+        return time.Duration(seconds * float64(time.Second)), nil
+    }
+
+    d, err := time.ParseDuration(s)
+    if err != nil {
+        return 0, fmt.Errorf("Invalid offset '%s'", s)
+    }
+    return d, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log/slog"
+    "net/netip"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/SLP25/ESR/internal/emulator"
+    "github.com/SLP25/ESR/internal/node"
+    "github.com/SLP25/ESR/internal/service"
+)
+
+//Runs a test topology in-process and reports the stream tree and the packets each client received
+func main() {
+    slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+    var defaults service.LinkConditions
+    mem := flag.Bool("mem", false, "use an in-memory network, which enforces the metrics of the bootConfig edges, instead of loopback sockets")
+    flag.DurationVar(&defaults.Latency, "latency", 0, "default latency of the links of the in-memory network")
+    flag.DurationVar(&defaults.Jitter, "jitter", 0, "jitter of the links of the in-memory network")
+    flag.Float64Var(&defaults.Loss, "loss", 0, "default UDP packet loss (0 to 1) of the links of the in-memory network")
+    flag.Float64Var(&defaults.BurstLength, "burst", 0, "mean length of the UDP loss bursts of the in-memory network")
+    flag.IntVar(&defaults.Bandwidth, "bandwidth", 0, "default bandwidth (bits/s) of the links of the in-memory network")
+    fecName := flag.String("fec", "auto", "links between nodes protected by FEC: auto (the lossy ones), always or off")
+    duration := flag.Duration("duration", 5*time.Second, "time the clients play the stream for")
+    flag.Parse()
+
+    if flag.NArg() < 3 {
+        fmt.Println("Usage: emulator [-mem [link options]] [-fec <policy>] [-duration <time>] <testDir> <streamID> <clientAddr>...")
+        flag.PrintDefaults()
+        return
+    }
+
+    topo, err := emulator.LoadTopology(flag.Arg(0))
+    if err != nil {
+        fmt.Println("Error loading topology:", err)
+        return
+    }
+    streamID := flag.Arg(1)
+
+    fec, ok := node.FECPolicyByName(*fecName)
+    if !ok {
+        fmt.Println("Invalid FEC policy:", *fecName)
+        return
+    }
+
+    network := emulator.LoopbackNetwork
+    if *mem {
+        memNetwork := service.NewMemNetwork()
+        memNetwork.Default = defaults
+        topo.Condition(memNetwork)
+        network = emulator.MemoryNetwork(memNetwork)
+    }
+
+    emu := emulator.StartWith(topo, network, func(name string, n *node.Node) { n.SetFECPolicy(fec) })
+    defer emu.Close()
+
+    var clients []*emulator.Client
+    for i, arg := range flag.Args()[2:] {
+        addr, err := netip.ParseAddr(arg)
+        if err != nil {
+            fmt.Println("Invalid client address:", err)
+            return
+        }
+        clients = append(clients, emu.AddClient("c" + strconv.Itoa(i), addr, streamID))
+    }
+
+    time.Sleep(*duration)
+
+    fmt.Println("Tree of stream", streamID)
+    for _, e := range emu.Tree(streamID) {
+        fmt.Printf("  %s -> %s\n", e.From, e.To)
+    }
+    for _, c := range clients {
+        fmt.Printf("%s (%s) received %d packets (%.1f%% delivered)\n", c.Name, c.Addr, c.Received(), 100 * c.Delivery())
+    }
+}
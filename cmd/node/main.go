@@ -0,0 +1,75 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log/slog"
+    "net/netip"
+    "runtime"
+    "strconv"
+
+    "github.com/SLP25/ESR/internal/admin"
+    "github.com/SLP25/ESR/internal/node"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+func main() {
+    runtime.GOMAXPROCS(1)
+    utils.SetupLogging()
+
+    costName := flag.String("cost", "default", "cost function comparing path metrics: default, latency, loss or bandwidth")
+    policyName := flag.String("policy", "least-loaded", "choice between servers with similar paths (RP only): least-loaded, lowest-latency or sticky")
+    fecName := flag.String("fec", "auto", "links to the neighbours protected by FEC: auto (the ones with at least 1% of loss), always or off")
+    adminAddr := flag.String("admin", "", "serve the admin API (JSON over HTTP) on this address, e.g. :8080")
+    flag.Parse()
+
+    if flag.NArg() != 2 {
+        fmt.Println("Usage: node [-cost <function>] [-policy <policy>] [-fec <policy>] [-admin <addr>] <port> <bootAddr>")
+        return
+    }
+
+    aux, err := strconv.ParseUint(flag.Arg(0), 10, 16)
+    if err != nil {
+        fmt.Println("Invalid port: the port must be an integer between 0 and 65535")
+        return
+    }
+    tcpPort := uint16(aux)
+
+    bootAddr, err := netip.ParseAddrPort(flag.Arg(1))
+    if err != nil {
+        fmt.Println("Invalid boot address:", err)
+        return
+    }
+
+    cost, ok := utils.CostFunctionByName(*costName)
+    if !ok {
+        fmt.Println("Invalid cost function:", *costName)
+        return
+    }
+    utils.SetCostFunction(cost)
+
+    policy, ok := node.PolicyByName(*policyName)
+    if !ok {
+        fmt.Println("Invalid selection policy:", *policyName)
+        return
+    }
+
+    fec, ok := node.FECPolicyByName(*fecName)
+    if !ok {
+        fmt.Println("Invalid FEC policy:", *fecName)
+        return
+    }
+
+    n := node.New(bootAddr)
+    n.SetSelectionPolicy(policy)
+    n.SetFECPolicy(fec)
+
+    if *adminAddr != "" {
+        admin.Start(*adminAddr, n)
+    }
+
+    err = n.Run(netip.Addr{}, tcpPort)
+    if err != nil {
+        slog.Error("Error running service", "err", err)
+    }
+}
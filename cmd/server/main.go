@@ -0,0 +1,55 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log/slog"
+    "net/netip"
+    "strconv"
+
+    "github.com/SLP25/ESR/internal/admin"
+    "github.com/SLP25/ESR/internal/server"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+func main() {
+    utils.SetupLogging()
+
+    adminAddr := flag.String("admin", "", "serve the admin API (JSON over HTTP) on this address, e.g. :8080")
+    flag.Parse()
+
+    if flag.NArg() != 2 {
+        fmt.Println("Usage: server [-admin <addr>] <port> <config>")
+        return
+    }
+
+    aux, err := strconv.ParseUint(flag.Arg(0), 10, 16)
+    if err != nil {
+        fmt.Println("Invalid port: the port must be an integer between 0 and 65535")
+        return
+    }
+    port := uint16(aux) //both tcp (for control msgs) and udp (for pings)
+
+    s := server.New()
+    for streamID, spec := range server.MustReadConfig(flag.Arg(1)) {
+        source, err := server.ParseSource(spec)
+        if err == nil {
+            err = s.AddStream(streamID, source)
+        }
+
+        if err != nil {
+            fmt.Printf("Error loading stream '%s': %s\n", streamID, err)
+        } else {
+            fmt.Printf("Hosting stream '%s'\n", streamID)
+        }
+    }
+
+    if *adminAddr != "" {
+        admin.Start(*adminAddr, s)
+    }
+
+    err = s.Run(netip.Addr{}, port)
+    if err != nil {
+        slog.Error("Error running service", "err", err)
+    }
+}
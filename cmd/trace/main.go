@@ -0,0 +1,62 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "net/netip"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/SLP25/ESR/internal/bootstrapper"
+    "github.com/SLP25/ESR/internal/service"
+    "github.com/SLP25/ESR/internal/trace"
+)
+
+func main() {
+    dot := flag.Bool("dot", false, "print the tree as a Graphviz digraph instead of text")
+    boot := flag.String("boot", "", "trace from every node of this boot config, and name the machines after it")
+    timeout := flag.Duration("timeout", 3 * time.Second, "time given to each trace to reach the server")
+    flag.Parse()
+
+    if flag.NArg() < 1 || (*boot == "" && flag.NArg() < 2) {
+        fmt.Println("Usage: trace [-dot] [-timeout <duration>] [-boot <bootConfig.json>] <streamID> [nodeAddr...]")
+        flag.PrintDefaults()
+        return
+    }
+    streamID := flag.Arg(0)
+
+    var addrs []netip.AddrPort
+    names := make(map[netip.Addr]string)
+
+    if *boot != "" {
+        config := bootstrapper.MustReadConfig(*boot)
+        for name, addr := range config.Nodes() {
+            addrs = append(addrs, addr)
+            names[addr.Addr()] = name
+        }
+        for i, addr := range config.Servers() {
+            names[addr.Addr()] = "s" + strconv.Itoa(i)
+        }
+    }
+
+    for _, arg := range flag.Args()[1:] {
+        addr, err := netip.ParseAddrPort(arg)
+        if err != nil {
+            fmt.Println("Invalid node address:", err)
+            return
+        }
+        addrs = append(addrs, addr)
+    }
+
+    tree := trace.Collect(service.NetTransport{}, streamID, addrs, *timeout)
+    for addr, name := range names {
+        tree.Names[addr] = name
+    }
+
+    if *dot {
+        tree.WriteDOT(os.Stdout)
+    } else {
+        tree.WriteText(os.Stdout)
+    }
+}
@@ -2,9 +2,9 @@ module github.com/SLP25/ESR
 
 go 1.21
 
-require github.com/pion/sdp/v2 v2.4.0
-
 require (
-	github.com/pion/randutil v0.1.0 // indirect
-	github.com/vansante/go-ffprobe v1.1.0 // indirect
+	github.com/pion/sdp/v2 v2.4.0
+	github.com/vansante/go-ffprobe v1.1.0
 )
+
+require github.com/pion/randutil v0.1.0 // indirect
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+//Returns the state to be served. Called on every request, so it must be safe to call concurrently
+type Endpoint func() any
+
+//Serves the state of a daemon as JSON over HTTP, for debugging.
+//Every endpoint answers GET requests, and / lists the endpoints
+type Server struct {
+	mux *http.ServeMux
+	server *http.Server
+	mutex sync.Mutex
+	paths []string
+}
+
+func New() *Server {
+	this := &Server{mux: http.NewServeMux()}
+	this.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		this.mutex.Lock()
+		paths := append([]string{}, this.paths...)
+		this.mutex.Unlock()
+
+		sort.Strings(paths)
+		reply(w, r, paths)
+	})
+	return this
+}
+
+//Registers an endpoint. The path must start with '/'
+func (this *Server) Handle(path string, endpoint Endpoint) {
+	this.mutex.Lock()
+	this.paths = append(this.paths, path)
+	this.mutex.Unlock()
+
+	this.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		reply(w, r, endpoint())
+	})
+}
+
+//Registers an endpoint that writes its own response, for the ones that aren't JSON
+func (this *Server) HandleHTTP(path string, handler http.Handler) {
+	this.mutex.Lock()
+	this.paths = append(this.paths, path)
+	this.mutex.Unlock()
+
+	this.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func reply(w http.ResponseWriter, r *http.Request, v any) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("Admin: error encoding response", "path", r.URL.Path, "err", err)
+	}
+}
+
+//Serves the endpoints on the given address (host:port) until the server is closed
+func (this *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil { return err }
+
+	return this.Serve(l)
+}
+
+//Serves the endpoints on the listener until the server is closed
+func (this *Server) Serve(l net.Listener) error {
+	this.mutex.Lock()
+	this.server = &http.Server{Handler: this.mux}
+	server := this.server
+	this.mutex.Unlock()
+
+	slog.Info("Admin API listening", "addr", l.Addr())
+	err := server.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (this *Server) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.server == nil {
+		return nil
+	}
+	return this.server.Close()
+}
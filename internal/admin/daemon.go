@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"log/slog"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/service"
+)
+
+//A daemon whose state can be served on the admin API
+type Daemon interface {
+	RegisterAdmin(a *Server)
+}
+
+//Serves the admin API of the daemon on the given address (host:port) in the background.
+//Errors are logged: the daemon keeps running without its admin API
+func Start(addr string, daemon Daemon) *Server {
+	a := New()
+	daemon.RegisterAdmin(a)
+	go func() {
+		err := a.ListenAndServe(addr)
+		if err != nil {
+			slog.Error("Error running admin API", "err", err)
+		}
+	}()
+	return a
+}
+
+//Serves the handlers and the UDP ports of the service the daemon runs on,
+//and the metrics of the daemon (along with the service's) in the Prometheus text format
+func (this *Server) HandleService(serv *service.Service, registry *exporter.Registry) {
+	this.Handle("/service/handlers", func() any { return serv.HandlerNames() })
+	this.Handle("/service/udp", func() any { return serv.UDPPorts() })
+
+	registry.GaugeFunc("esr_service_udp_ports", "Number of open UDP servers", func(emit func(float64, ...string)) {
+		emit(float64(len(serv.UDPPorts())))
+	})
+	registry.GaugeFunc("esr_service_handlers", "Number of registered handlers, interceptors included", func(emit func(float64, ...string)) {
+		emit(float64(len(serv.HandlerNames())))
+	})
+	registry.CounterFunc("esr_service_interceptor_dropped_signals_total", "Signals dropped because an interceptor's buffer was full", func(emit func(float64, ...string)) {
+		emit(float64(serv.DroppedSignals()))
+	})
+	this.HandleHTTP("/metrics", registry)
+}
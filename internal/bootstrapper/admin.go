@@ -0,0 +1,24 @@
+package bootstrapper
+
+import (
+    "net/netip"
+
+    "github.com/SLP25/ESR/internal/admin"
+)
+
+type configStatus struct {
+    Servers []netip.AddrPort
+    Nodes map[string]netip.AddrPort
+    RP string
+    Edges []Link
+}
+
+//Serves the boot config on the admin API
+func (this *Bootstrapper) RegisterAdmin(a *admin.Server) {
+    a.HandleService(&this.serv, this.registry)
+    a.Handle("/config", func() any {
+        this.mu.Lock()
+        defer this.mu.Unlock()
+        return configStatus{Servers: this.config.servers, Nodes: this.config.nodes, RP: this.config.rp, Edges: this.config.Links()}
+    })
+}
@@ -1,4 +1,4 @@
-package main
+package bootstrapper
 
 import (
 	"encoding/json"
@@ -36,8 +36,12 @@ func MustReadConfig(filename string) config {
 	bytes, err := os.ReadFile(filename)
 	if err != nil { panic(err.Error()) }
 
+	return MustParseConfig(bytes)
+}
+
+func MustParseConfig(bytes []byte) config {
 	var data map[string]any
-	err = json.Unmarshal(bytes, &data)
+	err := json.Unmarshal(bytes, &data)
 	if err != nil {
 		panic("Error parsing boot config: " + err.Error())
 	}
@@ -109,6 +113,30 @@ func MustReadConfig(filename string) config {
 	return config
 }
 
+//An edge of the overlay, with the metrics the config gives it
+type Link struct {
+	A netip.AddrPort
+	B netip.AddrPort
+	Metrics utils.Metrics
+}
+
+//Returns the address of every node, by name
+func (this *config) Nodes() map[string]netip.AddrPort {
+	return this.nodes
+}
+
+func (this *config) Servers() []netip.AddrPort {
+	return this.servers
+}
+
+func (this *config) Links() []Link {
+	links := make([]Link, 0, len(this.edges))
+	for edge, metrics := range this.edges {
+		links = append(links, Link{A: this.nodes[edge.first], B: this.nodes[edge.second], Metrics: metrics})
+	}
+	return links
+}
+
 func (this *config) getName(node netip.Addr) (string, error) {
 	for name, n := range this.nodes {
 		if n.Addr() == node {
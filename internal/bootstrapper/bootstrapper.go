@@ -1,28 +1,53 @@
-package main
+package bootstrapper
 
 import (
-	"fmt"
 	"log/slog"
 	"net/netip"
-	"os"
-	"strconv"
 	"sync"
 
+	"github.com/SLP25/ESR/internal/exporter"
 	"github.com/SLP25/ESR/internal/packet"
 	"github.com/SLP25/ESR/internal/service"
 	"github.com/SLP25/ESR/internal/utils"
 )
 
-var tcpPort uint16
-var serv service.Service
-
-type bootstrapper struct {
+type Bootstrapper struct {
+    serv service.Service
     config config
     accessNode netip.Addr
     mu sync.Mutex
+
+    registry *exporter.Registry     //served on the admin API
+    startups *exporter.CounterVec
+    startupErrors *exporter.Counter
+}
+
+func New(config config) *Bootstrapper {
+    r := exporter.NewRegistry()
+    this := &Bootstrapper{config: config, registry: r}
+    this.startups = r.Counter("esr_bootstrapper_startups_total", "Startup requests received, by service (client or node)", "service")
+    this.startupErrors = r.Counter("esr_bootstrapper_startup_errors_total", "Startup requests of nodes missing from the configuration").With()
+    return this
+}
+
+//Runs the bootstrapper on the given local address until it is closed.
+//If the address is invalid, all local addresses are used
+func (this *Bootstrapper) Run(addr netip.Addr, tcpPort uint16) error {
+    return this.RunWith(service.NetTransport{Addr: addr}, tcpPort)
+}
+
+//Runs the bootstrapper through the given transport until it is closed
+func (this *Bootstrapper) RunWith(transport service.Transport, tcpPort uint16) error {
+    this.serv.SetTransport(transport)
+    this.serv.AddHandler(this)
+    return this.serv.Run(&tcpPort)
+}
+
+func (this *Bootstrapper) Close() {
+    this.serv.Close()
 }
 
-func (this *bootstrapper) getConnectToIP(client netip.AddrPort) netip.AddrPort {
+func (this *Bootstrapper) getConnectToIP(client netip.AddrPort) netip.AddrPort {
     this.mu.Lock()
     defer this.mu.Unlock()
 
@@ -43,7 +68,7 @@ func (this *bootstrapper) getConnectToIP(client netip.AddrPort) netip.AddrPort {
     return closestIP
 }
 
-func (this *bootstrapper) Handle(sig service.Signal) bool {
+func (this *Bootstrapper) Handle(sig service.Signal) bool {
     switch sig.(type) {
     case service.Init:
         return true
@@ -55,13 +80,16 @@ func (this *bootstrapper) Handle(sig service.Signal) bool {
             req := msg.Packet().(packet.StartupRequest)
             switch req.Service {
             case utils.Client:
+                this.startups.With("client").Inc()
                 utils.Warn(msg.SendResponse(packet.StartupResponseClient{ConnectTo: this.getConnectToIP(msg.Addr())}))
                 utils.Warn(msg.CloseConn())
                 return true
             case utils.Node:
+                this.startups.With("node").Inc()
                 resp, err := this.config.BootNode(msg.Addr().Addr())
                 if err != nil {
                     slog.Error("Error starting node", "addr", msg.Addr(), "err", err)
+                    this.startupErrors.Inc()
                 } else {
                     utils.Warn(msg.SendResponse(resp))
                     utils.Warn(msg.CloseConn())
@@ -73,27 +101,3 @@ func (this *bootstrapper) Handle(sig service.Signal) bool {
     
     return false
 }
-
-func main() {
-    utils.SetupLogging()
-
-    if len(os.Args) != 3 {
-        fmt.Println("Usage: bootstrapper <port> <config>")
-        return
-    }
-
-    aux, err := strconv.ParseUint(os.Args[1], 10, 16)
-    if err != nil {
-        fmt.Println("Invalid port: the port must be an integer between 0 and 65535")
-        return
-    }
-    tcpPort = uint16(aux)
-
-    bootstrapper := bootstrapper{config: MustReadConfig(os.Args[2])}
-
-    serv.AddHandler(&bootstrapper)
-    err = serv.Run(&tcpPort)
-    if err != nil {
-        slog.Error("Error running service", "err", err)
-    }
-}
\ No newline at end of file
@@ -0,0 +1,40 @@
+package client
+
+import (
+    "net/netip"
+
+    "github.com/SLP25/ESR/internal/admin"
+)
+
+type clientStatus struct {
+    StreamID string
+    BootAddr netip.AddrPort
+    AccessNode netip.AddrPort
+    UDPPort uint16
+    Playing bool
+    Received int64
+    Playout *jitterStats    //of the jitter buffer, while playing
+}
+
+//Serves the state of the client on the admin API
+func (this *Client) RegisterAdmin(a *admin.Server) {
+    a.HandleService(&this.serv, this.registry)
+    a.Handle("/client", func() any {
+        this.mutex.Lock()
+        defer this.mutex.Unlock()
+
+        status := clientStatus{
+            StreamID: this.streamID,
+            BootAddr: this.bootAddr,
+            AccessNode: this.accessNode,
+            UDPPort: this.udpPort,
+            Playing: this.player != nil,
+            Received: this.received.Load(),
+        }
+        if this.player != nil {
+            stats := this.player.stats()
+            status.Playout = &stats
+        }
+        return status
+    })
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//Time given to the overlay to gather the catalog
+const catalogTimeout = 3 * time.Second
+
+//Asks the bootstrapper for the access node, then asks the access node for the streams hosted by every server it reaches
+func List(transport service.Transport, bootAddr netip.AddrPort) ([]packet.CatalogEntry, error) {
+	p, err := service.Exchange(transport, packet.StartupRequest{Service: utils.Client}, bootAddr, bootTimeout)
+	if err != nil { return nil, fmt.Errorf("couldn't reach the bootstrapper: %w", err) }
+
+	startup, ok := p.(packet.StartupResponseClient)
+	if !ok {
+		return nil, errors.New("expected StartupResponseClient, received " + reflect.TypeOf(p).Name())
+	} else if !startup.ConnectTo.IsValid() {
+		return nil, errors.New("the network has no active nodes")
+	}
+
+	req := packet.CatalogRequest{RequestID: utils.RandID(), Timeout: catalogTimeout}
+	p, err = service.Exchange(transport, req, startup.ConnectTo, catalogTimeout)
+	if err != nil { return nil, fmt.Errorf("couldn't reach the access node: %w", err) }
+
+	resp, ok := p.(packet.CatalogResponse)
+	if !ok {
+		return nil, errors.New("expected CatalogResponse, received " + reflect.TypeOf(p).Name())
+	}
+	return resp.Streams, nil
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/recovery"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+
+const bootTimeout = 5 * time.Second
+const responseTimeout = 10 * time.Second
+const DefaultPlayoutDelay = 100 * time.Millisecond
+
+type Client struct {
+    serv service.Service
+    bootAddr netip.AddrPort
+    streamID string
+    udpPort uint16
+    newPlayer func(packet.StreamResponse) (Player, error)
+    delay time.Duration             //playout delay of the jitter buffer
+
+    mutex sync.Mutex                //guards the access node and the player, which the admin API reads
+    accessNode netip.AddrPort
+    player *jitterBuffer
+    tracks utils.Set[string]        //kinds of tracks played, nil for all
+    received atomic.Int64           //stream packets received
+    tracker *recovery.Tracker       //detects the packets lost from the access node
+    registry *exporter.Registry     //served on the admin API
+    receivedPackets *exporter.Counter
+    receivedBytes *exporter.Counter
+    lostPackets *exporter.Counter
+    recoveredPackets *exporter.Counter
+    playout jitterCounters
+}
+
+//Consumes the packets of a stream
+type Player interface {
+    PushPacket(packet.StreamPacket)
+    Close()
+    Done() <-chan struct{} //closed when the player terminates on its own
+}
+
+//Creates a client that plays the stream with ffplay
+func New(bootAddr netip.AddrPort, streamID string) *Client {
+    this := &Client{bootAddr: bootAddr, streamID: streamID, newPlayer: play, delay: DefaultPlayoutDelay, tracker: recovery.NewTracker(), registry: exporter.NewRegistry()}
+    r := this.registry
+    this.receivedPackets = r.Counter("esr_client_received_packets_total", "Stream packets received from the access node", "stream").With(streamID)
+    this.receivedBytes = r.Counter("esr_client_received_bytes_total", "Bytes of stream content received from the access node", "stream").With(streamID)
+    this.lostPackets = r.Counter("esr_client_lost_packets_total", "Stream packets lost from the access node and given up on", "stream").With(streamID)
+    this.recoveredPackets = r.Counter("esr_client_recovered_packets_total", "Stream packets lost from the access node and received once asked for again", "stream").With(streamID)
+
+    dropped := r.Counter("esr_client_dropped_packets_total", "Stream packets dropped by the jitter buffer, by reason (late, after their turn to play, or overflow, when the buffer is full)", "stream", "reason")
+    this.playout = jitterCounters{
+        late: dropped.With(streamID, "late"),
+        overflow: dropped.With(streamID, "overflow"),
+        missing: r.Counter("esr_client_missing_packets_total", "RTP packets not received before their turn to play", "stream").With(streamID),
+    }
+    r.GaugeFunc("esr_client_jitter_seconds", "Interarrival jitter of each track of the stream (RFC 3550)", func(emit func(float64, ...string)) {
+        this.mutex.Lock()
+        player := this.player
+        this.mutex.Unlock()
+
+        if player != nil {
+            for kind, jitter := range player.stats().Jitter {
+                emit(jitter.Seconds(), streamID, kind)
+            }
+        }
+    }, "stream", "track")
+    return this
+}
+
+//Replaces the player the stream is handed to (by default, an ffplay window).
+//Must be called before Run
+func (this *Client) SetPlayer(newPlayer func(packet.StreamResponse) (Player, error)) {
+    this.newPlayer = newPlayer
+}
+
+//Sets how long packets are held to be reordered before they're played (by default, DefaultPlayoutDelay).
+//Must be called before Run
+func (this *Client) SetPlayoutDelay(delay time.Duration) {
+    this.delay = delay
+}
+
+//Plays an on-demand session of the stream, starting at the offset, instead of joining the shared stream mid-way.
+//Must be called before Run
+func (this *Client) OnDemand(offset time.Duration) {
+    this.streamID = packet.NewVODSession(this.streamID, offset).ID()
+}
+
+//Runs the client on the given local address until the stream ends or the player terminates.
+//If the address is invalid, all local addresses are used
+func (this *Client) Run(addr netip.Addr) error {
+    return this.RunWith(service.NetTransport{Addr: addr})
+}
+
+//Runs the client through the given transport until the stream ends or the player terminates
+//Plays only the tracks of the given kinds (utils.VideoTrack, utils.AudioTrack). By default, every track is played.
+//Must be called before Run
+func (this *Client) SelectTracks(kinds ...string) {
+    this.tracks = utils.SetFrom(kinds...)
+}
+
+func (this *Client) selected(kind string) bool {
+    return this.tracks == nil || this.tracks.Contains(kind)
+}
+
+//Removes the media of the tracks that aren't played from the session description
+func (this *Client) filterSession(resp packet.StreamResponse) packet.StreamResponse {
+    media := resp.SDP.MediaDescriptions
+    resp.SDP.MediaDescriptions = nil
+    for _, m := range media {
+        if this.selected(m.MediaName.Media) {
+            resp.SDP.MediaDescriptions = append(resp.SDP.MediaDescriptions, m)
+        }
+    }
+    return resp
+}
+
+func (this *Client) RunWith(transport service.Transport) error {
+    this.serv.SetTransport(transport)
+    this.serv.AddHandler(this)
+    return this.serv.Run(nil, &this.udpPort)
+}
+
+func (this *Client) Close() {
+    this.serv.Close()
+}
+
+func (this *Client) Handle(sig service.Signal) bool {
+    switch sig.(type) {
+    case service.Init:
+        request := packet.StartupRequest{Service: utils.Client}
+        fmt.Println("Searching for access node...")
+        ctx, cancel := context.WithTimeout(context.Background(), bootTimeout)
+        response, err := service.InterceptTCPResponseContext[packet.StartupResponseClient](ctx, &this.serv, request, this.bootAddr)
+        cancel()
+        utils.Warn(this.serv.TCPServer().CloseConn(this.bootAddr.Addr()))
+        
+        if err != nil {
+            slog.Error("Error on Init", "err", err)
+            fmt.Println("Couldn't connect to bootstrapper. Terminating")
+            this.serv.Close()
+            return true
+        }
+        
+        if !response.ConnectTo.IsValid() {
+            fmt.Println("Invalid response. This could indicate the network has no active nodes")
+            this.serv.Close()
+            return true
+        }
+
+        var streamEnd chan packet.StreamEnd
+        var streamResponse <-chan packet.StreamResponse
+        var servClosing <-chan service.Closing
+        ctx, cancel = context.WithTimeout(context.Background(), responseTimeout)
+        defer cancel()
+        this.serv.PauseHandleWhile(func() {
+            this.mutex.Lock()
+            this.accessNode = response.ConnectTo
+            this.mutex.Unlock()
+            fmt.Println("Access node address received:", this.accessNode)
+            fmt.Println("Connecting to access node...")
+    
+            randInt := utils.RandID()
+            req := packet.StreamRequest{StreamID: this.streamID, RequestID: randInt, Port: this.udpPort}
+            this.serv.TCPServer().SendConnect(req, this.accessNode)
+            
+            fmt.Println("Waiting for node response...")
+            
+            servClosing = service.InterceptSignal[service.Closing](&this.serv, 1)
+            streamEnd = utils.MapChan[service.Signal, packet.StreamEnd](service.Intercept(&this.serv, func(sig service.Signal) bool {
+                msg, ok := sig.(service.TCPMessage)
+                if !ok { return false }
+
+                p, ok := msg.Packet().(packet.StreamEnd)
+                return ok && p.StreamID == this.streamID
+            }, 1), func(sig service.Signal) packet.StreamEnd {
+                return sig.(service.TCPMessage).Packet().(packet.StreamEnd)
+            })
+            streamResponse = service.InterceptTCPPacketsContext[packet.StreamResponse](ctx, &this.serv, this.accessNode, 1)
+        })
+
+        select {
+            case <- streamEnd:
+                fmt.Println("Stream '" + this.streamID + "' doesn't exist")
+                this.serv.Close()
+                return true
+
+            case msg, ok := <-streamResponse:
+                if !ok {
+                    fmt.Println("Access node didn't respond in time. Terminating")
+                    this.serv.Close()
+                    return true
+                }
+
+                fmt.Println("Response received! Loading video player...")
+                player, err := this.newPlayer(this.filterSession(msg))
+                if err != nil {
+                    slog.Error("Failed to start player", "err", err)
+                    this.serv.Close()
+                    return true
+                }
+
+                this.mutex.Lock()
+                this.player = newJitterBuffer(player, msg.SDP, this.delay, this.playout)
+                this.mutex.Unlock()
+
+                go func() {
+                    <-player.Done()
+                    fmt.Println("Video player terminated")
+                    this.serv.Close()
+                }()
+
+            case <- servClosing:
+                return true
+        }
+
+        select {
+            case <- streamEnd:
+                fmt.Println("Stream ended")
+                time.Sleep(time.Millisecond * 200)
+                this.serv.Close()
+            case <- servClosing:
+        }
+
+        this.player.Close()
+        return true
+
+    case service.TCPDisconnected:
+        disc := sig.(service.TCPDisconnected)
+
+        if !this.accessNode.IsValid() || disc.Addr().Addr() != this.accessNode.Addr() { return false }
+
+        fmt.Println("Access node disconnected. Terminating")
+        this.serv.Close()
+        return true
+
+    case service.UDPMessage:
+        msg := sig.(service.UDPMessage)
+
+        if msg.Addr().Addr() != this.accessNode.Addr() { return false }
+
+        p, ok := msg.Packet().(packet.StreamPacket)
+        if !ok { return false }
+
+        r := this.tracker.Receive(p.Seq)
+        this.lostPackets.Add(float64(r.Lost))
+        if len(r.Nack) != 0 {
+            utils.Warn(this.serv.TCPServer().Send(packet.Nack{StreamID: this.streamID, Port: this.udpPort, Missing: r.Nack}, this.accessNode.Addr()))
+        }
+        if !r.Fresh { return true }
+        if r.Recovered {
+            this.recoveredPackets.Inc()
+        }
+        if !this.selected(p.Type.Kind()) { return true }
+
+        this.received.Add(1)
+        this.receivedPackets.Inc()
+        this.receivedBytes.Add(float64(len(p.Content)))
+        this.mutex.Lock()
+        player := this.player
+        this.mutex.Unlock()
+
+        if player != nil {
+            player.PushPacket(p)
+        }
+        
+        return true
+    }
+
+    return false
+}
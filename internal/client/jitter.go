@@ -0,0 +1,282 @@
+package client
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/rtp"
+	"github.com/pion/sdp/v2"
+)
+
+const (
+	maxBuffered = 4096						//packets held by the jitter buffer before new ones are dropped
+	resyncGap = 1000						//a bigger jump of the RTP sequence numbers is a new numbering (the source restarted)
+	playoutTick = 5 * time.Millisecond		//how often the due packets are handed to the player
+)
+
+//A packet held by the jitter buffer until its playout time
+type heldPacket struct {
+	packet.StreamPacket
+	seq uint64			//extended RTP sequence number
+	due time.Time
+}
+
+//The RTP packets of a track, ordered by sequence number
+type jitterTrack struct {
+	queue []heldPacket
+	ssrc uint32
+	highest uint64		//extended sequence number of the latest packet received
+	played uint64		//and of the latest one handed to the player
+	started bool		//whether a packet was played since the last resync
+	transit float64		//of the last packet, in seconds, for the interarrival jitter
+	jitter float64		//in seconds (RFC 3550)
+}
+
+//The counters of the jitter buffer, shared with the client's registry
+type jitterCounters struct {
+	late *exporter.Counter
+	overflow *exporter.Counter
+	missing *exporter.Counter
+}
+
+//Holds the packets of the stream for a playout delay, reorders the RTP packets of each track by sequence number
+//and drops the ones that arrive after their turn. Packets are handed to the player by a goroutine of its own,
+//so a slow player never blocks the service: the buffer fills up and drops packets instead.
+//RTCP and non-RTP packets aren't reordered
+type jitterBuffer struct {
+	player Player
+	delay time.Duration
+	clockRates map[uint8]int	//of the payload types of the session
+	counters jitterCounters
+	epoch time.Time
+	stop chan struct{}
+	closing sync.Once
+
+	mutex sync.Mutex
+	tracks map[packet.StreamType]*jitterTrack
+	ready []packet.StreamPacket	//not reordered, handed to the player on the next tick
+	buffered int
+	late int64
+	overflow int64
+	missing int64
+}
+
+//Statistics of the jitter buffer, served on the admin API
+type jitterStats struct {
+	Delay time.Duration
+	Buffered int
+	Late int64				//packets dropped as they arrived after their turn
+	Overflow int64			//packets dropped as the buffer was full
+	Missing int64			//packets never received before their turn
+	Jitter map[string]time.Duration	//interarrival jitter of each track
+}
+
+func newJitterBuffer(player Player, session sdp.SessionDescription, delay time.Duration, counters jitterCounters) *jitterBuffer {
+	this := &jitterBuffer{
+		player: player,
+		delay: delay,
+		clockRates: clockRates(session),
+		counters: counters,
+		epoch: time.Now(),
+		stop: make(chan struct{}),
+		tracks: make(map[packet.StreamType]*jitterTrack),
+	}
+	go this.playout()
+	return this
+}
+
+//Returns the clock rate of every payload type of the session: the static ones and the ones of its rtpmaps
+func clockRates(session sdp.SessionDescription) map[uint8]int {
+	ans := make(map[uint8]int)
+	for pt, format := range rtp.StaticPayloadTypes {
+		ans[pt] = format.ClockRate()
+	}
+
+	for _, m := range session.MediaDescriptions {
+		for _, a := range m.Attributes {
+			if a.Key != "rtpmap" {
+				continue
+			}
+
+			pt, encoding, ok := strings.Cut(a.Value, " ")
+			n, err := strconv.ParseUint(pt, 10, 7)
+			if ok && err == nil {
+				ans[uint8(n)] = rtp.PayloadFormat{Media: m.MediaName.Media, Encoding: encoding}.ClockRate()
+			}
+		}
+	}
+	return ans
+}
+
+//Holds a packet of the stream. Never blocks
+func (this *jitterBuffer) PushPacket(p packet.StreamPacket) {
+	now := time.Now()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.buffered >= maxBuffered {
+		this.overflow++
+		this.counters.overflow.Inc()
+		return
+	}
+
+	h, _, err := rtp.Parse(p.Content)
+	if err != nil || (p.Type != packet.Video && p.Type != packet.Audio) || rtp.IsRTCP(p.Content) {
+		this.ready = append(this.ready, p)
+		this.buffered++
+		return
+	}
+
+	t, ok := this.tracks[p.Type]
+	if !ok {
+		t = &jitterTrack{}
+		this.tracks[p.Type] = t
+	}
+
+	seq := t.extend(h.Sequence)
+	if t.queue != nil || t.started {
+		if h.SSRC != t.ssrc || seq > t.highest + resyncGap || seq + resyncGap < t.highest {
+			this.resync(t) //the source restarted, with a new numbering
+			seq = uint64(h.Sequence)
+		} else if t.started && seq <= t.played {
+			this.late++
+			this.counters.late.Inc()
+			return
+		}
+	}
+	t.ssrc = h.SSRC
+	t.highest = max(t.highest, seq)
+	this.measureJitter(t, h, now)
+
+	i := len(t.queue)
+	for i > 0 && t.queue[i - 1].seq >= seq {
+		i--
+	}
+	if i < len(t.queue) && t.queue[i].seq == seq { //duplicate
+		return
+	}
+	t.queue = append(t.queue, heldPacket{})
+	copy(t.queue[i + 1:], t.queue[i:])
+	t.queue[i] = heldPacket{StreamPacket: p, seq: seq, due: now.Add(this.delay)}
+	this.buffered++
+}
+
+//Returns the extended sequence number of the packet, the closest one to the highest received
+func (this *jitterTrack) extend(seq uint16) uint64 {
+	diff := int64(int16(seq - uint16(this.highest)))
+	if int64(this.highest) + diff < 0 {
+		return uint64(seq)
+	}
+	return uint64(int64(this.highest) + diff)
+}
+
+//Updates the interarrival jitter of the track with a packet (RFC 3550, section 6.4.1). Must hold the mutex
+func (this *jitterBuffer) measureJitter(t *jitterTrack, h rtp.Header, arrival time.Time) {
+	rate := this.clockRates[h.PayloadType]
+	if rate == 0 {
+		return
+	}
+
+	transit := arrival.Sub(this.epoch).Seconds() - float64(h.Timestamp) / float64(rate)
+	if t.transit != 0 {
+		d := math.Abs(transit - t.transit)
+		if d < 1 { //a bigger difference is a jump of the timestamps, not jitter
+			t.jitter += (d - t.jitter) / 16
+		}
+	}
+	t.transit = transit
+}
+
+//Plays the packets held of the track right away and forgets its numbering. Must hold the mutex
+func (this *jitterBuffer) resync(t *jitterTrack) {
+	for _, p := range t.queue {
+		this.ready = append(this.ready, p.StreamPacket)
+	}
+	t.queue = nil
+	t.started = false
+	t.highest = 0
+	t.transit = 0
+}
+
+//Returns the packets due, in the order they are played. Must hold the mutex
+func (this *jitterBuffer) due(now time.Time) []packet.StreamPacket {
+	ans := this.ready
+	this.ready = nil
+
+	for _, t := range this.tracks {
+		//every packet before one that is due is played, so no packet is held longer than the delay
+		last := -1
+		for i, p := range t.queue {
+			if !p.due.After(now) {
+				last = i
+			}
+		}
+
+		for _, p := range t.queue[:last + 1] {
+			if t.started && p.seq > t.played + 1 {
+				this.missing += int64(p.seq - t.played - 1)
+				this.counters.missing.Add(float64(p.seq - t.played - 1))
+			}
+			t.played = p.seq
+			t.started = true
+			ans = append(ans, p.StreamPacket)
+		}
+		t.queue = t.queue[last + 1:]
+	}
+
+	this.buffered -= len(ans)
+	return ans
+}
+
+//Hands the due packets to the player until the buffer is closed
+func (this *jitterBuffer) playout() {
+	ticker := time.NewTicker(playoutTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case now := <-ticker.C:
+				this.mutex.Lock()
+				ps := this.due(now)
+				this.mutex.Unlock()
+
+				for _, p := range ps {
+					this.player.PushPacket(p)
+				}
+			case <-this.stop:
+				return
+		}
+	}
+}
+
+func (this *jitterBuffer) Close() {
+	this.closing.Do(func() { close(this.stop) })
+	this.player.Close()
+}
+
+func (this *jitterBuffer) Done() <-chan struct{} {
+	return this.player.Done()
+}
+
+func (this *jitterBuffer) stats() jitterStats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	ans := jitterStats{
+		Delay: this.delay,
+		Buffered: this.buffered,
+		Late: this.late,
+		Overflow: this.overflow,
+		Missing: this.missing,
+		Jitter: make(map[string]time.Duration, len(this.tracks)),
+	}
+	for kind, t := range this.tracks {
+		ans.Jitter[kind.Kind()] = time.Duration(t.jitter * float64(time.Second))
+	}
+	return ans
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/rtp"
+	"github.com/pion/sdp/v2"
+)
+
+const testSSRC = 1234
+
+//Records the packets played
+type fakePlayer struct {
+	mutex sync.Mutex
+	played []packet.StreamPacket
+	done chan struct{}
+}
+
+func newFakePlayer() *fakePlayer {
+	return &fakePlayer{done: make(chan struct{})}
+}
+
+func (this *fakePlayer) PushPacket(p packet.StreamPacket) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.played = append(this.played, p)
+}
+
+func (this *fakePlayer) Close() {}
+
+func (this *fakePlayer) Done() <-chan struct{} {
+	return this.done
+}
+
+func (this *fakePlayer) sequences() []uint16 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return playedSequences(this.played)
+}
+
+func playedSequences(ps []packet.StreamPacket) []uint16 {
+	ans := make([]uint16, 0, len(ps))
+	for _, p := range ps {
+		h, _, _ := rtp.Parse(p.Content)
+		ans = append(ans, h.Sequence)
+	}
+	return ans
+}
+
+func rtpPacket(ssrc uint32, seq uint16) packet.StreamPacket {
+	h := rtp.Header{PayloadType: rtp.JPEGPayloadType, Sequence: seq, Timestamp: uint32(seq) * 3600, SSRC: ssrc}
+	return packet.StreamPacket{Type: packet.Video, Content: h.Append(nil)}
+}
+
+//A jitter buffer without its playout goroutine: the tests hand the due packets over with flush
+func newTestBuffer(delay time.Duration) *jitterBuffer {
+	r := exporter.NewRegistry()
+	return &jitterBuffer{
+		delay: delay,
+		clockRates: clockRates(sdp.SessionDescription{}),
+		counters: jitterCounters{
+			late: r.Counter("late", "").With(),
+			overflow: r.Counter("overflow", "").With(),
+			missing: r.Counter("missing", "").With(),
+		},
+		epoch: time.Now(),
+		stop: make(chan struct{}),
+		tracks: make(map[packet.StreamType]*jitterTrack),
+	}
+}
+
+func (this *jitterBuffer) push(ssrc uint32, seqs ...uint16) {
+	for _, seq := range seqs {
+		this.PushPacket(rtpPacket(ssrc, seq))
+	}
+}
+
+//Returns the sequence numbers of the packets held, all played as if their delay passed
+func (this *jitterBuffer) flush() []uint16 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return playedSequences(this.due(time.Now().Add(this.delay)))
+}
+
+func checkPlayed(t *testing.T, got []uint16, want ...uint16) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("played %v, want %v", got, want)
+	}
+}
+
+func TestJitterReorders(t *testing.T) {
+	b := newTestBuffer(time.Second)
+	b.push(testSSRC, 10, 13, 11, 12, 11)
+
+	b.mutex.Lock()
+	early := b.due(time.Now())
+	b.mutex.Unlock()
+	if len(early) != 0 {
+		t.Errorf("played %v before the delay", playedSequences(early))
+	}
+
+	checkPlayed(t, b.flush(), 10, 11, 12, 13)
+	if s := b.stats(); s.Late != 0 || s.Missing != 0 || s.Buffered != 0 {
+		t.Errorf("stats %+v after playing every packet in order", s)
+	}
+}
+
+//Packets arriving once a later one was played are dropped, and the ones never received are counted
+func TestJitterLate(t *testing.T) {
+	b := newTestBuffer(time.Second)
+	b.push(testSSRC, 20, 21, 23)
+	checkPlayed(t, b.flush(), 20, 21, 23)
+
+	b.push(testSSRC, 22, 23, 19, 24)
+	checkPlayed(t, b.flush(), 24)
+
+	if s := b.stats(); s.Late != 3 || s.Missing != 1 {
+		t.Errorf("%d late and %d missing, want 3 late (22, 23 and 19) and 1 missing (22)", s.Late, s.Missing)
+	}
+}
+
+func TestJitterWraparound(t *testing.T) {
+	track := &jitterTrack{highest: 65535}
+	if got := track.extend(0); got != 65536 {
+		t.Errorf("extend(0) after 65535 = %d, want 65536", got)
+	}
+	if got := track.extend(65534); got != 65534 {
+		t.Errorf("extend(65534) after 65535 = %d, want 65534", got)
+	}
+	track.highest = 65536 + 3
+	if got := track.extend(65535); got != 65535 {
+		t.Errorf("extend(65535) after 65536+3 = %d, want 65535", got)
+	}
+
+	//the numbering wraps around while packets are reordered
+	b := newTestBuffer(time.Second)
+	b.push(testSSRC, 65534, 0, 65535, 2, 1)
+	checkPlayed(t, b.flush(), 65534, 65535, 0, 1, 2)
+
+	b.push(testSSRC, 65535, 3)
+	checkPlayed(t, b.flush(), 3)
+	if s := b.stats(); s.Late != 1 || s.Missing != 0 {
+		t.Errorf("%d late and %d missing, want only 65535 late", s.Late, s.Missing)
+	}
+}
+
+//A new SSRC (the source restarted) starts a new numbering instead of being dropped as late
+func TestJitterResync(t *testing.T) {
+	b := newTestBuffer(time.Second)
+	b.push(testSSRC, 500, 501)
+	checkPlayed(t, b.flush(), 500, 501)
+
+	//the packets held of the old source are played right away, before the new one's
+	b.push(testSSRC, 502)
+	b.push(testSSRC + 1, 7, 6)
+	checkPlayed(t, b.flush(), 502, 6, 7)
+
+	//so does a jump of the numbering, even with the same SSRC
+	b.push(testSSRC + 1, 7 + resyncGap + 1)
+	checkPlayed(t, b.flush(), 7 + resyncGap + 1)
+
+	if s := b.stats(); s.Late != 0 || s.Missing != 0 {
+		t.Errorf("%d late and %d missing across the resyncs, want none", s.Late, s.Missing)
+	}
+}
+
+//RTCP and non-RTP packets aren't held
+func TestJitterPassThrough(t *testing.T) {
+	b := newTestBuffer(time.Hour)
+	b.PushPacket(packet.StreamPacket{Type: packet.Video, Content: []byte{1, 2, 3}})
+	b.PushPacket(packet.StreamPacket{Type: packet.Video, Content: append([]byte{2 << 6, 200}, make([]byte, 10)...)})
+	b.push(testSSRC, 1)
+
+	b.mutex.Lock()
+	got := b.due(time.Now())
+	b.mutex.Unlock()
+	if len(got) != 2 {
+		t.Errorf("played %d packets right away, want the 2 that aren't RTP", len(got))
+	}
+}
+
+//The playout goroutine hands the packets to the player, in order, once their delay passes
+func TestJitterPlayout(t *testing.T) {
+	player := newFakePlayer()
+	r := exporter.NewRegistry()
+	counters := jitterCounters{late: r.Counter("late", "").With(), overflow: r.Counter("overflow", "").With(), missing: r.Counter("missing", "").With()}
+	b := newJitterBuffer(player, sdp.SessionDescription{}, 50 * time.Millisecond, counters)
+	defer b.Close()
+
+	start := time.Now()
+	b.push(testSSRC, 3, 1, 2)
+	for len(player.sequences()) < 3 {
+		if time.Since(start) > time.Second {
+			t.Fatalf("played %v after a second", player.sequences())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50 * time.Millisecond {
+		t.Errorf("played after %v, before the 50ms delay", elapsed)
+	}
+	checkPlayed(t, player.sequences(), 1, 2, 3)
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//Time given to the overlay to apply a playback request
+const playbackTimeout = 3 * time.Second
+
+//Asks the stream's server to seek, skip, pause or resume the stream (or only for its position, with Status).
+//Only the sole viewer of a stream can control it: the request is refused if the stream is shared
+func (this *Client) Control(action packet.PlaybackAction, offset time.Duration) (packet.PlaybackResponse, error) {
+	this.mutex.Lock()
+	accessNode := this.accessNode
+	playing := this.player != nil
+	this.mutex.Unlock()
+
+	if !playing {
+		return packet.PlaybackResponse{}, errors.New("the stream isn't playing")
+	}
+
+	req := packet.PlaybackRequest{StreamID: this.streamID, RequestID: utils.RandID(), Action: action, Offset: offset, Timeout: playbackTimeout}
+	ctx, cancel := context.WithTimeout(context.Background(), playbackTimeout)
+	defer cancel()
+
+	//the interceptor is registered before sending, so the response can't be missed
+	answer := service.InterceptContext(ctx, &this.serv, func(sig service.Signal) bool {
+		msg, ok := sig.(service.TCPMessage)
+		if !ok { return false }
+
+		resp, ok := msg.Packet().(packet.PlaybackResponse)
+		return ok && msg.Addr().Addr() == accessNode.Addr() && resp.RequestID == req.RequestID
+	}, 1)
+
+	err := this.serv.TCPServer().Send(req, accessNode.Addr())
+	if err != nil { return packet.PlaybackResponse{}, err }
+
+	sig, ok := <-answer
+	if !ok {
+		return packet.PlaybackResponse{}, errors.New("the access node didn't answer in time")
+	}
+
+	resp := sig.(service.TCPMessage).Packet().(packet.PlaybackResponse)
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
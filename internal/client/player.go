@@ -1,4 +1,4 @@
-package main
+package client
 
 import (
 	"fmt"
@@ -14,22 +14,28 @@ import (
 type player struct {
 	ffplay *exec.Cmd
 	input chan<- packet.StreamPacket
-	done <-chan struct{} //Used directly in client.go
+	done <-chan struct{}
 }
 
+//Blocks while ffplay is slow to read the packets, unless it terminated
 func (this *player) PushPacket(p packet.StreamPacket) {
-	if this.input != nil {
-		this.input <- p
+	select {
+		case this.input <- p:
+		case <-this.done:
 	}
 }
 
+func (this *player) Done() <-chan struct{} {
+	return this.done
+}
+
 func (this *player) Close() {
 	if this.ffplay.Process != nil {
 		this.ffplay.Process.Kill()
 	}
 }
 
-func play(sdpConfig packet.StreamResponse) (*player, error) {
+func play(sdpConfig packet.StreamResponse) (Player, error) {
 	ports := utils.FindFreePorts(2) //We pray that the next ports are also open
 	sdpConfig.SetPorts(ports[0], ports[1])
 
@@ -49,7 +55,7 @@ func play(sdpConfig packet.StreamResponse) (*player, error) {
 	done := make(chan struct{}, 1)
 	input := make(chan packet.StreamPacket, 100)
 	player := player{
-		ffplay: exec.Command("ffplay", "-window_title", streamID, "-protocol_whitelist", "pipe,udp,rtp", "-f", "sdp", "-i", "-"),
+		ffplay: exec.Command("ffplay", "-window_title", sdpConfig.StreamID, "-protocol_whitelist", "pipe,udp,rtp", "-f", "sdp", "-i", "-"),
 		done: done,
 		input: input,
 	}
@@ -83,14 +89,18 @@ func play(sdpConfig packet.StreamResponse) (*player, error) {
 
 	go func() {
 		utils.Warn(player.ffplay.Wait())
-		player.input = nil
-		close(input)
 		done <- struct{}{}
 		close(done)
 	}()
 
 	go func() {
-		for p := range input {
+		for {
+			var p packet.StreamPacket
+			select {
+				case p = <-input:
+				case <-done: return
+			}
+
 			var err error
 
 			switch p.Type {
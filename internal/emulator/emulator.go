@@ -0,0 +1,288 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SLP25/ESR/internal/bootstrapper"
+	"github.com/SLP25/ESR/internal/client"
+	"github.com/SLP25/ESR/internal/node"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/server"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//Address of the bootstrapper. No topology places a machine there
+var BootAddr = netip.MustParseAddrPort("127.0.0.1:6321")
+
+//Time given to each tier (bootstrapper, servers, nodes) to start before the next one is launched
+const startupDelay = 300 * time.Millisecond
+
+//Provides the transport of each machine of the emulation
+type Network func(addr netip.Addr) service.Transport
+
+//Every machine binds its own loopback address
+func LoopbackNetwork(addr netip.Addr) service.Transport {
+	return service.NetTransport{Addr: addr}
+}
+
+//Every machine is a host of the in-memory network
+func MemoryNetwork(network *service.MemNetwork) Network {
+	return network.Host
+}
+
+//Runs a whole topology (bootstrapper, nodes and servers) in the current process,
+//either over loopback or over an in-memory network.
+//Servers host synthetic streams, and clients count the packets they receive instead of playing them
+type Emulation struct {
+	Topology *Topology
+	network Network
+
+	boot *bootstrapper.Bootstrapper
+	servers []*server.Server
+	nodes map[string]*node.Node
+
+	clientsMutex sync.Mutex
+	clients map[string]*Client
+
+	running sync.WaitGroup
+}
+
+type Client struct {
+	*client.Client
+	Name string
+	Addr netip.Addr
+	player *countingPlayer
+	done chan struct{}
+}
+
+//Number of stream packets received by the client so far
+func (this *Client) Received() int {
+	return int(this.player.received.Load())
+}
+
+//Fraction of the packets of the stream, from the first received to the last, that were received
+func (this *Client) Delivery() float64 {
+	return this.player.delivery()
+}
+
+//Closed when the client terminates (the stream ended, the access node disconnected, ...)
+func (this *Client) Done() <-chan struct{} {
+	return this.done
+}
+
+//An edge of the distribution tree of a stream
+type Edge struct {
+	From string
+	To string
+}
+
+func Start(topo *Topology, network Network) *Emulation {
+	return StartWith(topo, network, nil)
+}
+
+//Starts the topology, calling setup (if not nil) on each node before it runs
+func StartWith(topo *Topology, network Network, setup func(name string, n *node.Node)) *Emulation {
+	this := &Emulation{
+		Topology: topo,
+		network: network,
+		nodes: make(map[string]*node.Node),
+		clients: make(map[string]*Client),
+	}
+
+	this.boot = bootstrapper.New(bootstrapper.MustParseConfig(topo.bootConfig))
+	this.run("bootstrapper", func() error { return this.boot.RunWith(network(BootAddr.Addr()), BootAddr.Port()) })
+	time.Sleep(startupDelay)
+
+	for i, spec := range topo.Servers {
+		s := server.New()
+		for _, streamID := range spec.Streams {
+			if err := s.AddStream(streamID, NewSyntheticSource()); err != nil {
+				slog.Error("Emulator: error adding stream", "server", i, "streamID", streamID, "err", err)
+			}
+		}
+
+		this.servers = append(this.servers, s)
+		addr := spec.Addr
+		this.run(topo.Name(addr.Addr()), func() error { return s.RunWith(network(addr.Addr()), addr.Port()) })
+	}
+	time.Sleep(startupDelay)
+
+	for name, addr := range topo.Nodes {
+		n := node.New(BootAddr)
+		if setup != nil {
+			setup(name, n)
+		}
+		this.nodes[name] = n
+		addr := addr
+		this.run(name, func() error { return n.RunWith(network(addr.Addr()), addr.Port()) })
+	}
+	time.Sleep(startupDelay)
+
+	return this
+}
+
+func (this *Emulation) run(name string, f func() error) {
+	this.running.Add(1)
+	go func() {
+		defer this.running.Done()
+		if err := f(); err != nil {
+			slog.Error("Emulator: service stopped with an error", "name", name, "err", err)
+		}
+	}()
+}
+
+//Starts a client on the given address (of the CORE topology, it's mapped to loopback).
+//The bootstrapper assigns the access node closest to that address
+func (this *Emulation) AddClient(name string, addr netip.Addr, streamID string) *Client {
+	c := &Client{
+		Client: client.New(BootAddr, streamID),
+		Name: name,
+		Addr: Loopback(addr),
+		player: &countingPlayer{done: make(chan struct{})},
+		done: make(chan struct{}),
+	}
+	c.SetPlayer(func(packet.StreamResponse) (client.Player, error) { return c.player, nil })
+
+	this.clientsMutex.Lock()
+	this.clients[name] = c
+	this.clientsMutex.Unlock()
+
+	this.run(name, func() error {
+		defer close(c.done)
+		return c.RunWith(this.network(c.Addr))
+	})
+	return c
+}
+
+func (this *Emulation) Node(name string) *node.Node {
+	return this.nodes[name]
+}
+
+//Stops the node, as if its machine crashed
+func (this *Emulation) StopNode(name string) {
+	if n, ok := this.nodes[name]; ok {
+		n.Close()
+		delete(this.nodes, name)
+	}
+}
+
+func (this *Emulation) name(addr netip.Addr) string {
+	this.clientsMutex.Lock()
+	defer this.clientsMutex.Unlock()
+
+	for name, c := range this.clients {
+		if c.Addr == addr {
+			return name
+		}
+	}
+	return this.Topology.Name(addr)
+}
+
+//Returns the edges of the distribution tree currently formed for the stream, sorted
+func (this *Emulation) Tree(streamID string) []Edge {
+	edges := make(map[Edge]bool)
+
+	for name, n := range this.nodes {
+		s, ok := n.RunningStreams()[streamID]
+		if !ok { continue }
+
+		edges[Edge{From: this.name(s.From), To: name}] = true
+		for _, to := range s.To {
+			edges[Edge{From: name, To: this.name(to.Addr())}] = true
+		}
+	}
+
+	ans := make([]Edge, 0, len(edges))
+	for e := range edges {
+		ans = append(ans, e)
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].From < ans[j].From || (ans[i].From == ans[j].From && ans[i].To < ans[j].To)
+	})
+	return ans
+}
+
+//Polls the condition until it holds or the timeout expires. Returns whether the condition holds
+func WaitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cond()
+}
+
+//Stops every service and waits for them to return
+func (this *Emulation) Close() {
+	this.clientsMutex.Lock()
+	for _, c := range this.clients {
+		c.Close()
+	}
+	this.clientsMutex.Unlock()
+
+	for _, n := range this.nodes {
+		n.Close()
+	}
+	for _, s := range this.servers {
+		s.Close()
+	}
+	this.boot.Close()
+
+	this.running.Wait()
+}
+
+
+//Counts the packets received, and which of the numbered packets of the synthetic source they are
+type countingPlayer struct {
+	received atomic.Int64
+	done chan struct{}
+	closing sync.Once
+
+	mutex sync.Mutex
+	numbers utils.Set[uint32]
+	first uint32
+	last uint32
+}
+
+func (this *countingPlayer) PushPacket(p packet.StreamPacket) {
+	this.received.Add(1)
+	if len(p.Content) < 4 {
+		return
+	}
+
+	n := binary.BigEndian.Uint32(p.Content)
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.numbers == nil {
+		this.numbers = utils.EmptySet[uint32]()
+		this.first, this.last = n, n
+	}
+	this.numbers.Add(n)
+	this.first, this.last = min(this.first, n), max(this.last, n)
+}
+
+func (this *countingPlayer) delivery() float64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.numbers == nil {
+		return 0
+	}
+	return float64(this.numbers.Length()) / float64(this.last - this.first + 1)
+}
+
+func (this *countingPlayer) Close() {
+	this.closing.Do(func() { close(this.done) })
+}
+
+func (this *countingPlayer) Done() <-chan struct{} {
+	return this.done
+}
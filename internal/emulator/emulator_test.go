@@ -0,0 +1,344 @@
+package emulator
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/node"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+)
+
+const streamTimeout = 10 * time.Second
+
+func startOn(t *testing.T, dir string, network Network) *Emulation {
+	topo, err := LoadTopology(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emu := Start(topo, network)
+	t.Cleanup(emu.Close)
+	return emu
+}
+
+func startMem(t *testing.T, dir string) (*Emulation, *service.MemNetwork) {
+	network := service.NewMemNetwork()
+	return startOn(t, dir, MemoryNetwork(network)), network
+}
+
+//Waits for the client to receive some packets
+func waitPackets(t *testing.T, c *Client, packets int) {
+	if !WaitFor(streamTimeout, func() bool { return c.Received() >= packets }) {
+		t.Fatalf("client %s received %d packets, want at least %d", c.Name, c.Received(), packets)
+	}
+}
+
+func testSingleNode(t *testing.T, emu *Emulation) {
+	c := emu.AddClient("c0", netip.MustParseAddr("10.0.4.21"), "perfect")
+	waitPackets(t, c, 50)
+
+	want := []Edge{{From: "n3", To: "c0"}, {From: "s0", To: "n3"}}
+	if got := emu.Tree("perfect"); !slices.Equal(got, want) {
+		t.Errorf("tree %v, want %v", got, want)
+	}
+}
+
+func TestSingleNode(t *testing.T) {
+	emu, _ := startMem(t, "../../test/one")
+	testSingleNode(t, emu)
+}
+
+//The same, with real sockets bound to the loopback addresses of the machines
+func TestSingleNodeLoopback(t *testing.T) {
+	testSingleNode(t, startOn(t, "../../test/one", LoopbackNetwork))
+}
+
+//Checks that the tree is rooted at the server and reaches every client through a node
+func checkTree(emu *Emulation, tree []Edge, root string, clients []*Client) error {
+	parents := make(map[string]string)
+	for _, e := range tree {
+		if p, ok := parents[e.To]; ok {
+			return fmt.Errorf("%s receives the stream from both %s and %s", e.To, p, e.From)
+		}
+		parents[e.To] = e.From
+	}
+
+	for _, c := range clients {
+		if access := parents[c.Name]; emu.Node(access) == nil {
+			return fmt.Errorf("client %s served by %q, which isn't a node", c.Name, access)
+		}
+
+		at := c.Name
+		for hops := 0; at != root; hops++ {
+			p, ok := parents[at]
+			if !ok || hops > len(tree) {
+				return fmt.Errorf("%s isn't connected to the server", c.Name)
+			}
+			at = p
+		}
+	}
+	return nil
+}
+
+//Both clients are served by a single tree rooted at the server, through their access nodes.
+//n6 is two hops from n1 both through n2 and through n5, and joins through n5, which already receives the stream
+func TestSharedTree(t *testing.T) {
+	emu, _ := startMem(t, "../../test/2c")
+	c1 := emu.AddClient("c1", netip.MustParseAddr("10.0.11.21"), "perfect")
+	waitPackets(t, c1, 50)
+	c0 := emu.AddClient("c0", netip.MustParseAddr("10.0.16.21"), "perfect")
+	waitPackets(t, c0, 50)
+
+	//a node may briefly receive the stream from two upstreams while it switches between them
+	want := []Edge{{From: "n1", To: "n5"}, {From: "n5", To: "c1"}, {From: "n5", To: "n6"}, {From: "n6", To: "c0"}, {From: "s0", To: "n1"}}
+	var tree []Edge
+	if !WaitFor(streamTimeout, func() bool { tree = emu.Tree("perfect"); return slices.Equal(tree, want) }) {
+		t.Errorf("tree %v, want %v", tree, want)
+	}
+	if err := checkTree(emu, tree, "s0", []*Client{c0, c1}); err != nil {
+		t.Error(err)
+	}
+}
+
+//Each stream of test/2s is served from the server hosting it, to clients on both ends of the topology
+func TestTwoServers(t *testing.T) {
+	emu, _ := startMem(t, "../../test/2s")
+	streams := map[string]string{"perfect": "s0", "1000years": "s1"}
+	clients := map[string][]*Client{
+		"perfect": {emu.AddClient("c0", netip.MustParseAddr("10.0.16.21"), "perfect"), emu.AddClient("c1", netip.MustParseAddr("10.0.1.21"), "perfect")},
+		"1000years": {emu.AddClient("c2", netip.MustParseAddr("10.0.12.21"), "1000years")},
+	}
+
+	for streamID, root := range streams {
+		for _, c := range clients[streamID] {
+			waitPackets(t, c, 50)
+		}
+
+		var tree []Edge
+		var err error
+		WaitFor(streamTimeout, func() bool {
+			tree = emu.Tree(streamID)
+			err = checkTree(emu, tree, root, clients[streamID])
+			return err == nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v: %v", streamID, err, tree)
+		}
+	}
+}
+
+//A viewer joining a stream its sole viewer paused resumes it, instead of waiting on it forever
+func TestJoinPausedStream(t *testing.T) {
+	emu, _ := startMem(t, "../../test/one")
+	c0 := emu.AddClient("c0", netip.MustParseAddr("10.0.4.21"), "perfect")
+	waitPackets(t, c0, 20)
+
+	resp, err := c0.Control(packet.Pause, 0)
+	if err != nil || !resp.Paused {
+		t.Fatalf("pause: %v, %+v", err, resp)
+	}
+	time.Sleep(200 * time.Millisecond) //for the packets already sent
+	paused := c0.Received()
+
+	//c1 joins through the same node, where the stream is still running
+	c1 := emu.AddClient("c1", netip.MustParseAddr("10.0.4.22"), "perfect")
+	waitPackets(t, c1, 20)
+	waitPackets(t, c0, paused + 20)
+
+	if resp, err := c0.Control(packet.Status, 0); err != nil || resp.Paused {
+		t.Errorf("status after the join: %v, %+v", err, resp)
+	}
+}
+
+func TestUnknownStream(t *testing.T) {
+	emu, _ := startMem(t, "../../test/one")
+	c := emu.AddClient("c0", netip.MustParseAddr("10.0.4.21"), "missing")
+
+	select {
+		case <-c.Done():
+		case <-time.After(streamTimeout):
+			t.Fatal("the client of an unknown stream didn't terminate")
+	}
+	if c.Received() != 0 {
+		t.Errorf("received %d packets of an unknown stream", c.Received())
+	}
+	if tree := emu.Tree("missing"); len(tree) != 0 {
+		t.Errorf("tree %v of an unknown stream", tree)
+	}
+}
+
+//Runs the 2c topology with the given FEC policy and loss on the links between nodes,
+//returning the mean delivery of clients one and two lossy hops away from the server
+func lossyDelivery(t *testing.T, fec node.FECPolicy, loss float64) float64 {
+	topo, err := LoadTopology("../../test/2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := service.NewMemNetwork()
+	for _, a := range topo.Nodes {
+		for _, b := range topo.Nodes {
+			if a != b {
+				network.SetLink(a.Addr(), b.Addr(), service.LinkConditions{Latency: 100 * time.Millisecond, Loss: loss})
+			}
+		}
+	}
+
+	emu := StartWith(topo, MemoryNetwork(network), func(name string, n *node.Node) { n.SetFECPolicy(fec) })
+	defer emu.Close()
+
+	clients := []*Client{
+		emu.AddClient("c0", netip.MustParseAddr("10.0.16.21"), "perfect"),
+		emu.AddClient("c1", netip.MustParseAddr("10.0.19.21"), "perfect"),
+		emu.AddClient("c2", netip.MustParseAddr("10.0.11.21"), "perfect"),
+		emu.AddClient("c3", netip.MustParseAddr("10.0.14.21"), "perfect"),
+	}
+	total := 0.0
+	for _, c := range clients {
+		waitPackets(t, c, 300)
+		total += c.Delivery()
+	}
+	return total / float64(len(clients))
+}
+
+//Repairs rebuild packets lost between nodes, which retransmissions (lost as well) may never deliver
+func TestFECDelivery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the topology twice")
+	}
+
+	//both runs at once, to halve the time
+	var off, auto float64
+	t.Run("runs", func(t *testing.T) {
+		t.Run("off", func(t *testing.T) {
+			t.Parallel()
+			off = lossyDelivery(t, node.FECOff, 0.4)
+		})
+		t.Run("auto", func(t *testing.T) {
+			t.Parallel()
+			auto = lossyDelivery(t, node.FECAuto, 0.4)
+		})
+	})
+	if t.Failed() {
+		return
+	}
+
+	t.Logf("delivery %.3f without FEC, %.3f with it", off, auto)
+	if auto <= off {
+		t.Errorf("delivery %.3f with FEC, no better than %.3f without it", auto, off)
+	}
+}
+
+//n6 is two hops from the RP (n1) both through n2 and through n5, but the 10000 bps edge between n2 and n6 of test/2s
+//can't carry the stream: n2 doesn't offer it to n6 (Node.fitsAditional), and the link drops most of it.
+//Once n5 is down, n6 can only receive the stream if that edge is widened
+func TestNarrowLink(t *testing.T) {
+	tests := []struct {
+		name string
+		bandwidth int
+		reached bool
+	}{
+		{"10000 bps", 10000, false},
+		{"widened", 1000000000, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			topo, err := LoadTopology("../../test/2s")
+			if err != nil {
+				t.Fatal(err)
+			}
+			setBandwidth(t, topo, "n2", "n6", test.bandwidth)
+
+			network := service.NewMemNetwork()
+			topo.Condition(network)
+			emu := Start(topo, MemoryNetwork(network))
+			defer emu.Close()
+			emu.StopNode("n5")
+
+			c := emu.AddClient("c0", netip.MustParseAddr("10.0.16.21"), "perfect")
+			if test.reached {
+				waitPackets(t, c, 50)
+			} else {
+				WaitFor(streamTimeout / 2, func() bool { return c.Received() != 0 })
+				if c.Received() != 0 {
+					t.Errorf("received %d packets through the narrow edge", c.Received())
+				}
+			}
+
+			if through := slices.Contains(emu.Tree("perfect"), Edge{From: "n2", To: "n6"}); through != test.reached {
+				t.Errorf("stream through n2 -> n6 %v, want %v: %v", through, test.reached, emu.Tree("perfect"))
+			}
+		})
+	}
+}
+
+//n6 switches its upstream once the link to it degrades. The new upstream (n2) is subscribed to first,
+//and the old one (n5) keeps sending until the new one delivers, when it's canceled (make-before-break)
+func TestMakeBeforeBreak(t *testing.T) {
+	topo, err := LoadTopology("../../test/2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setBandwidth(t, topo, "n2", "n6", 1000000000)
+
+	//n6 joins through n5, as the link to n2 is slower
+	network := service.NewMemNetwork()
+	n2, n5, n6 := topo.Nodes["n2"].Addr(), topo.Nodes["n5"].Addr(), topo.Nodes["n6"].Addr()
+	network.SetLink(n2, n6, service.LinkConditions{Latency: 30 * time.Millisecond})
+	emu := Start(topo, MemoryNetwork(network))
+	defer emu.Close()
+
+	//n5 and n2 carry the stream to their own clients
+	c1 := emu.AddClient("c1", netip.MustParseAddr("10.0.11.21"), "perfect")
+	waitPackets(t, c1, 20)
+	c2 := emu.AddClient("c2", netip.MustParseAddr("10.0.14.21"), "perfect")
+	waitPackets(t, c2, 20)
+	c0 := emu.AddClient("c0", netip.MustParseAddr("10.0.16.21"), "perfect")
+	waitPackets(t, c0, 20)
+	if tree := emu.Tree("perfect"); !slices.Contains(tree, Edge{From: "n5", To: "n6"}) {
+		t.Fatalf("n6 joined through another node: %v", tree)
+	}
+
+	//then the link to n5 loses datagrams. The StreamCancel reaches n5 right away, while n2 takes at least 20ms
+	//to get the StreamRequest and deliver its first packet: canceling n5 any earlier would leave n6 without an upstream
+	network.SetLink(n5, n6, service.LinkConditions{Loss: 0.3})
+	network.SetLink(n2, n6, service.LinkConditions{Latency: 10 * time.Millisecond})
+
+	//the upstreams that send to n6, as they see it (the tree also has the upstream n6 receives from)
+	sendsToN6 := func(name string) bool {
+		s, ok := emu.Node(name).RunningStreams()["perfect"]
+		return ok && slices.ContainsFunc(s.To, func(to netip.AddrPort) bool { return to.Addr() == n6 })
+	}
+	overlapped, broken := false, false
+	switched := WaitFor(20 * time.Second, func() bool {
+		for start := time.Now(); time.Since(start) < 50 * time.Millisecond; time.Sleep(time.Millisecond) {
+			fromN5, fromN2 := sendsToN6("n5"), sendsToN6("n2")
+			overlapped = overlapped || (fromN5 && fromN2)
+			broken = broken || (!fromN5 && !fromN2)
+			if fromN2 && !fromN5 {
+				return true
+			}
+		}
+		return false
+	})
+
+	if !switched {
+		t.Fatalf("n6 didn't switch to n2: %v", emu.Tree("perfect"))
+	}
+	if !overlapped {
+		t.Error("n5 stopped sending before n2 started")
+	}
+	if broken {
+		t.Error("n6 was left without an upstream")
+	}
+	if s := emu.Node("n6").RunningStreams()["perfect"]; s.From != n2 {
+		t.Errorf("n6 receives from %s, want n2", s.From)
+	}
+
+	waitPackets(t, c0, c0.Received() + 20)
+}
@@ -0,0 +1,64 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/server"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+const syntheticBitrate = 100000
+const syntheticInterval = 20 * time.Millisecond
+const syntheticDuration = 10 * time.Minute
+
+//Emits a numbered video packet every 20ms, so streams can be emulated without media files or ffmpeg.
+//The stream lasts 10 minutes (and loops), so its playback can be controlled
+type syntheticSource struct{}
+
+func NewSyntheticSource() server.Source {
+	return syntheticSource{}
+}
+
+func (this syntheticSource) Probe() (utils.StreamMetadata, error) {
+	return utils.StreamMetadata{
+		Bitrate: syntheticBitrate,
+		Duration: syntheticDuration,
+		Tracks: []utils.TrackMetadata{{Kind: utils.VideoTrack, Codec: "synthetic", Bitrate: syntheticBitrate, FrameRate: float64(time.Second / syntheticInterval)}},
+	}, nil
+}
+
+func (this syntheticSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	session := sdp.SessionDescription{
+		Origin: sdp.Origin{Username: "-", NetworkType: "IN", AddressType: "IP4", UnicastAddress: "127.0.0.1"},
+		SessionName: "synthetic",
+		TimeDescriptions: []sdp.TimeDescription{{}},
+	}
+	session.WithMedia(&sdp.MediaDescription{
+		MediaName: sdp.MediaName{Media: "video", Port: sdp.RangedPort{Value: 0}, Protos: []string{"RTP", "AVP"}, Formats: []string{"96"}},
+	})
+
+	go func() {
+		ticker := time.NewTicker(syntheticInterval)
+		defer ticker.Stop()
+
+		n := uint32(offset / syntheticInterval)
+		for {
+			select {
+				case <-ticker.C:
+				case <-stop: return
+			}
+
+			p := packet.StreamPacket{Type: packet.Video, Content: binary.BigEndian.AppendUint32(nil, n)}
+			select {
+				case out <- p:
+				case <-stop: return
+			}
+			n++
+		}
+	}()
+
+	return session, nil, nil
+}
@@ -0,0 +1,139 @@
+package emulator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/SLP25/ESR/internal/bootstrapper"
+	"github.com/SLP25/ESR/internal/server"
+	"github.com/SLP25/ESR/internal/service"
+)
+
+//Maps an address of a CORE topology to a loopback address with the same last three bytes
+//(10.0.5.20 becomes 127.0.5.20), so every machine gets its own address on this host.
+//Linux routes the whole 127.0.0.0/8 block to the loopback interface
+func Loopback(addr netip.Addr) netip.Addr {
+	b := addr.As4()
+	b[0] = 127
+	return netip.AddrFrom4(b)
+}
+
+func loopbackPort(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(Loopback(addr.Addr()), addr.Port())
+}
+
+type ServerSpec struct {
+	Addr netip.AddrPort
+	Streams []string
+}
+
+//A topology read from one of the test directories (test/one, test/2s, ...),
+//with every address already mapped to loopback
+type Topology struct {
+	Nodes map[string]netip.AddrPort
+	Servers []ServerSpec
+	RP string
+
+	bootConfig []byte
+}
+
+//Reads the bootConfig.json and serverConfig*.json files in the directory.
+//The bootConfig doesn't say which server hosts which streams, so the server configs (sorted by name)
+//are assigned to the servers in the order they are listed. A single server config is assigned to every server.
+//Tests can change the assignment through Topology.Servers before starting the emulation
+func LoadTopology(dir string) (*Topology, error) {
+	bytes, err := os.ReadFile(filepath.Join(dir, "bootConfig.json"))
+	if err != nil { return nil, err }
+
+	var data map[string]any
+	err = json.Unmarshal(bytes, &data)
+	if err != nil { return nil, err }
+
+	topo := &Topology{Nodes: make(map[string]netip.AddrPort)}
+
+	servers, _ := data["servers"].([]any)
+	for i, aux := range servers {
+		addr, err := netip.ParseAddrPort(aux.(string))
+		if err != nil { return nil, err }
+
+		addr = loopbackPort(addr)
+		servers[i] = addr.String()
+		topo.Servers = append(topo.Servers, ServerSpec{Addr: addr})
+	}
+
+	nodes, _ := data["nodes"].(map[string]any)
+	for name, aux := range nodes {
+		addr, err := netip.ParseAddrPort(aux.(string))
+		if err != nil { return nil, err }
+
+		addr = loopbackPort(addr)
+		nodes[name] = addr.String()
+		topo.Nodes[name] = addr
+	}
+
+	topo.RP, _ = data["rp"].(string)
+
+	topo.bootConfig, err = json.Marshal(data)
+	if err != nil { return nil, err }
+
+	configs, err := filepath.Glob(filepath.Join(dir, "serverConfig*.json"))
+	if err != nil { return nil, err }
+	sort.Strings(configs)
+
+	if len(configs) != 1 && len(configs) != len(topo.Servers) {
+		return nil, errors.New("emulator: can't assign " + dir + " server configs to its servers")
+	}
+
+	for i := range topo.Servers {
+		conf := server.MustReadConfig(configs[min(i, len(configs)-1)])
+		for streamID := range conf {
+			topo.Servers[i].Streams = append(topo.Servers[i].Streams, streamID)
+		}
+		sort.Strings(topo.Servers[i].Streams)
+	}
+
+	return topo, nil
+}
+
+//Returns the name of the machine with the given address: the node name, "s<i>" for servers,
+//or the address itself if it isn't part of the topology
+func (this *Topology) Name(addr netip.Addr) string {
+	for name, n := range this.Nodes {
+		if n.Addr() == addr {
+			return name
+		}
+	}
+
+	for i, s := range this.Servers {
+		if s.Addr.Addr() == addr {
+			return "s" + strconv.Itoa(i)
+		}
+	}
+
+	return addr.String()
+}
+
+//Makes every edge of the topology enforce the metrics the bootConfig gives it.
+//Latency, PacketLoss and Bandwidth override the network's default conditions, which still provide
+//the jitter and loss burst length, and the conditions of the links outside the overlay (servers, clients).
+//The Latency of the bootConfig is read as a time.Duration, in nanoseconds
+func (this *Topology) Condition(network *service.MemNetwork) {
+	config := bootstrapper.MustParseConfig(this.bootConfig)
+	for _, link := range config.Links() {
+		conditions := network.Default
+		if link.Metrics.Latency > 0 {
+			conditions.Latency = link.Metrics.Latency
+		}
+		if link.Metrics.PacketLoss > 0 {
+			conditions.Loss = link.Metrics.PacketLoss
+		}
+		conditions.Bandwidth = link.Metrics.Bandwidth
+
+		network.SetLink(link.A.Addr(), link.B.Addr(), conditions)
+	}
+}
@@ -0,0 +1,102 @@
+package emulator
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/service"
+)
+
+const testBootConfig = `{
+	"servers": ["10.0.0.21:6321"],
+	"nodes": {"n1": "10.0.1.20:6321", "n2": "10.0.2.20:6321", "n3": "10.0.3.20:6321"},
+	"edges": [
+		{"n1": "n2", "Bandwidth": 1000000, "Latency": 20000000, "PacketLoss": 0.05},
+		{"n2": "n3", "Bandwidth": 10000}
+	],
+	"rp": "n1"
+}`
+
+func writeTopology(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{"bootConfig.json": testBootConfig, "serverConfig.json": `{"perfect": "perfect.mp4"}`}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadTopology(t *testing.T) {
+	topo, err := LoadTopology(writeTopology(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := topo.Nodes["n2"]; got != netip.MustParseAddrPort("127.0.2.20:6321") {
+		t.Errorf("n2 at %s, want it mapped to 127.0.2.20:6321", got)
+	}
+	if len(topo.Servers) != 1 || len(topo.Servers[0].Streams) != 1 || topo.Servers[0].Streams[0] != "perfect" {
+		t.Errorf("servers %+v, want one hosting perfect", topo.Servers)
+	}
+	if name := topo.Name(netip.MustParseAddr("127.0.0.21")); name != "s0" {
+		t.Errorf("the server is named %q", name)
+	}
+}
+
+//The metrics of each edge of the bootConfig become the conditions of its link, in both directions
+func TestCondition(t *testing.T) {
+	topo, err := LoadTopology(writeTopology(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := service.NewMemNetwork()
+	network.Default = service.LinkConditions{Latency: time.Millisecond, Jitter: 2 * time.Millisecond, Loss: 0.01, BurstLength: 3}
+	topo.Condition(network)
+
+	n1, n2, n3 := topo.Nodes["n1"].Addr(), topo.Nodes["n2"].Addr(), topo.Nodes["n3"].Addr()
+	tests := []struct {
+		from netip.Addr
+		to netip.Addr
+		want service.LinkConditions
+	}{
+		{n1, n2, service.LinkConditions{Latency: 20 * time.Millisecond, Jitter: 2 * time.Millisecond, Loss: 0.05, BurstLength: 3, Bandwidth: 1000000}},
+		{n2, n1, service.LinkConditions{Latency: 20 * time.Millisecond, Jitter: 2 * time.Millisecond, Loss: 0.05, BurstLength: 3, Bandwidth: 1000000}},
+		//the metrics the edge doesn't give are the network's defaults
+		{n3, n2, service.LinkConditions{Latency: time.Millisecond, Jitter: 2 * time.Millisecond, Loss: 0.01, BurstLength: 3, Bandwidth: 10000}},
+		//not an edge of the overlay
+		{n1, n3, network.Default},
+		{topo.Servers[0].Addr.Addr(), n1, network.Default},
+	}
+
+	for _, test := range tests {
+		if got := network.Link(test.from, test.to); got != test.want {
+			t.Errorf("%s -> %s: %+v, want %+v", topo.Name(test.from), topo.Name(test.to), got, test.want)
+		}
+	}
+}
+
+//Sets the bandwidth the bootConfig gives the edge between two nodes
+func setBandwidth(t *testing.T, topo *Topology, a string, b string, bandwidth int) {
+	var data map[string]any
+	if err := json.Unmarshal(topo.bootConfig, &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, aux := range data["edges"].([]any) {
+		edge := aux.(map[string]any)
+		if edge[a] == b || edge[b] == a {
+			edge["Bandwidth"] = bandwidth
+		}
+	}
+
+	var err error
+	if topo.bootConfig, err = json.Marshal(data); err != nil {
+		t.Fatal(err)
+	}
+}
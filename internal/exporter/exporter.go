@@ -0,0 +1,243 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Metrics in the Prometheus text exposition format (version 0.0.4), without depending on the Prometheus client
+type Registry struct {
+	mutex sync.Mutex
+	families map[string]*family
+}
+
+type kind string
+
+const (
+	counter kind = "counter"
+	gauge kind = "gauge"
+)
+
+//A metric with every combination of its labels
+type family struct {
+	name string
+	help string
+	kind kind
+	labels []string
+
+	mutex sync.Mutex
+	values map[string]*value	//by the joined label values
+	collect func(emit func(v float64, labelValues ...string))	//computed on every scrape instead
+}
+
+type value struct {
+	labelValues []string
+	mutex sync.Mutex
+	v float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+//Returns the family with the given name, registering it the first time
+func (this *Registry) family(name string, help string, k kind, labels []string) *family {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if f, ok := this.families[name]; ok {
+		if f.kind != k || len(f.labels) != len(labels) {
+			panic("exporter: metric " + name + " registered twice with different types or labels")
+		}
+		return f
+	}
+
+	f := &family{name: name, help: help, kind: k, labels: labels, values: make(map[string]*value)}
+	this.families[name] = f
+	return f
+}
+
+//A counter with the given labels. Registering the same name again returns the same counter
+func (this *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{this.family(name, help, counter, labels)}
+}
+
+//A gauge with the given labels. Registering the same name again returns the same gauge
+func (this *Registry) Gauge(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{this.family(name, help, gauge, labels)}
+}
+
+//A gauge computed on every scrape: collect emits a value for each combination of labels
+func (this *Registry) GaugeFunc(name string, help string, collect func(emit func(v float64, labelValues ...string)), labels ...string) {
+	f := this.family(name, help, gauge, labels)
+	f.mutex.Lock()
+	f.collect = collect
+	f.mutex.Unlock()
+}
+
+//A counter kept elsewhere, read on every scrape: collect emits a value for each combination of labels
+func (this *Registry) CounterFunc(name string, help string, collect func(emit func(v float64, labelValues ...string)), labels ...string) {
+	f := this.family(name, help, counter, labels)
+	f.mutex.Lock()
+	f.collect = collect
+	f.mutex.Unlock()
+}
+
+func (this *family) with(labelValues []string) *value {
+	if len(labelValues) != len(this.labels) {
+		panic(fmt.Sprintf("exporter: metric %s has %d labels, got %d values", this.name, len(this.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\x00")
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	v, ok := this.values[key]
+	if !ok {
+		v = &value{labelValues: append([]string{}, labelValues...)}
+		this.values[key] = v
+	}
+	return v
+}
+
+type CounterVec struct {
+	f *family
+}
+
+//Returns the counter with the given label values, in the order of the labels
+func (this *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{this.f.with(labelValues)}
+}
+
+type Counter struct {
+	v *value
+}
+
+func (this *Counter) Inc() {
+	this.Add(1)
+}
+
+//Adds a non-negative amount
+func (this *Counter) Add(n float64) {
+	if n < 0 {
+		panic("exporter: counters can't decrease")
+	}
+	this.v.mutex.Lock()
+	this.v.v += n
+	this.v.mutex.Unlock()
+}
+
+type GaugeVec struct {
+	f *family
+}
+
+//Returns the gauge with the given label values, in the order of the labels
+func (this *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{this.f.with(labelValues)}
+}
+
+type Gauge struct {
+	v *value
+}
+
+func (this *Gauge) Set(v float64) {
+	this.v.mutex.Lock()
+	this.v.v = v
+	this.v.mutex.Unlock()
+}
+
+func (this *Gauge) Add(n float64) {
+	this.v.mutex.Lock()
+	this.v.v += n
+	this.v.mutex.Unlock()
+}
+
+type sample struct {
+	labelValues []string
+	v float64
+}
+
+func (this *family) samples() []sample {
+	this.mutex.Lock()
+	collect := this.collect
+	ans := make([]sample, 0, len(this.values))
+	for _, v := range this.values {
+		v.mutex.Lock()
+		ans = append(ans, sample{labelValues: v.labelValues, v: v.v})
+		v.mutex.Unlock()
+	}
+	this.mutex.Unlock()
+
+	if collect != nil {
+		collect(func(v float64, labelValues ...string) {
+			if len(labelValues) != len(this.labels) {
+				panic(fmt.Sprintf("exporter: metric %s has %d labels, got %d values", this.name, len(this.labels), len(labelValues)))
+			}
+			ans = append(ans, sample{labelValues: labelValues, v: v})
+		})
+	}
+
+	sort.Slice(ans, func(i, j int) bool {
+		return strings.Join(ans[i].labelValues, "\x00") < strings.Join(ans[j].labelValues, "\x00")
+	})
+	return ans
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+		case math.IsInf(v, 1): return "+Inf"
+		case math.IsInf(v, -1): return "-Inf"
+		case math.IsNaN(v): return "NaN"
+		default: return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+//Writes every metric, sorted by name and labels
+func (this *Registry) Write(w io.Writer) error {
+	this.mutex.Lock()
+	families := make([]*family, 0, len(this.families))
+	for _, f := range this.families {
+		families = append(families, f)
+	}
+	this.mutex.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, helpEscaper.Replace(f.help), f.name, f.kind)
+
+		for _, s := range f.samples() {
+			b.WriteString(f.name)
+			if len(f.labels) != 0 {
+				b.WriteByte('{')
+				for i, l := range f.labels {
+					if i != 0 { b.WriteByte(',') }
+					fmt.Fprintf(&b, `%s="%s"`, l, labelEscaper.Replace(s.labelValues[i]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatValue(s.v))
+			b.WriteByte('\n')
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+//Serves the metrics, so the registry can be scraped
+func (this *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	this.Write(w)
+}
@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	sent := r.Counter("sent_packets_total", "Packets sent", "stream", "neighbour")
+	sent.With("movie", "10.0.0.2").Add(3)
+	sent.With("movie", "10.0.0.1").Inc()
+	r.Gauge("ports", "Open ports").With().Set(2)
+	r.GaugeFunc("jitter_seconds", "Jitter of each track", func(emit func(float64, ...string)) {
+		emit(0.25, "video")
+		emit(0.5, "audio")
+	}, "track")
+	r.CounterFunc("dropped_total", "Dropped signals", func(emit func(float64, ...string)) {
+		emit(7)
+	})
+
+	//families sorted by name, samples by their labels
+	want := `# HELP dropped_total Dropped signals
+# TYPE dropped_total counter
+dropped_total 7
+# HELP jitter_seconds Jitter of each track
+# TYPE jitter_seconds gauge
+jitter_seconds{track="audio"} 0.5
+jitter_seconds{track="video"} 0.25
+# HELP ports Open ports
+# TYPE ports gauge
+ports 2
+# HELP sent_packets_total Packets sent
+# TYPE sent_packets_total counter
+sent_packets_total{stream="movie",neighbour="10.0.0.1"} 1
+sent_packets_total{stream="movie",neighbour="10.0.0.2"} 3
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("errors_total", "Errors,\nby \\ message", "message").With("bad \"input\"\nat C:\\path").Inc()
+
+	want := `# HELP errors_total Errors,\nby \\ message
+# TYPE errors_total counter
+errors_total{message="bad \"input\"\nat C:\\path"} 1
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSpecialValues(t *testing.T) {
+	r := NewRegistry()
+	g := r.Gauge("value", "A value", "case")
+	g.With("inf").Set(math.Inf(1))
+	g.With("minus inf").Set(math.Inf(-1))
+	g.With("nan").Set(math.NaN())
+	g.With("small").Set(1e-9)
+	g.With("zero").Set(0)
+
+	got := scrape(t, r)
+	for _, line := range []string{
+		`value{case="inf"} +Inf`,
+		`value{case="minus inf"} -Inf`,
+		`value{case="nan"} NaN`,
+		`value{case="small"} 1e-09`,
+		`value{case="zero"} 0`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+}
+
+//Registering a name again returns the same metric, so counts aren't split between copies
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "Requests", "type").With("probe").Inc()
+	r.Counter("requests_total", "Requests", "type").With("probe").Add(2)
+
+	if got := scrape(t, r); !strings.Contains(got, `requests_total{type="probe"} 3`+"\n") {
+		t.Errorf("counts split between registrations:\n%s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registered a counter again as a gauge")
+		}
+	}()
+	r.Gauge("requests_total", "Requests", "type")
+}
+
+func TestWrongLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests", "type")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("accepted two label values for one label")
+		}
+	}()
+	c.With("probe", "extra")
+}
+
+func TestCountersOnlyIncrease(t *testing.T) {
+	c := NewRegistry().Counter("requests_total", "Requests").With()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("decreased a counter")
+		}
+	}()
+	c.Add(-1)
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("ports", "Open ports").With().Set(2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", got)
+	}
+	if got := w.Body.String(); got != scrape(t, r) {
+		t.Errorf("served:\n%s\nwrote:\n%s", got, scrape(t, r))
+	}
+}
@@ -0,0 +1,136 @@
+package node
+
+import (
+    "net/netip"
+
+    "github.com/SLP25/ESR/internal/admin"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+type nodeStatus struct {
+    ID uint32
+    RP bool
+    Servers []netip.AddrPort
+}
+
+type neighbourStatus struct {
+    Port uint16
+    Bootstrapper utils.Metrics     //handed out at startup
+    Current utils.Metrics          //used for route selection
+    Throughput int                 //highest recent throughput received, in bits per second
+}
+
+type runningStatus struct {
+    From netip.Addr
+    ToLocal uint16
+    Subscribers []netip.AddrPort
+    Bitrate int
+    Metrics utils.Metrics
+    Path []uint32
+}
+
+type waitingStatus struct {
+    LocalPort uint16
+    Subscribers []netip.AddrPort
+}
+
+type probeStatus struct {
+    From netip.Addr
+    Exists bool
+    Bitrate int
+    Metrics utils.Metrics
+    Path []uint32
+}
+
+type switchStatus struct {
+    To netip.AddrPort
+    Port uint16
+}
+
+//Serves the state of the node on the admin API
+func (this *Node) RegisterAdmin(a *admin.Server) {
+    a.HandleService(&this.serv, this.exported.registry)
+    a.Handle("/node", this.status)
+    a.Handle("/neighbours", this.neighboursStatus)
+    a.Handle("/streams/running", this.runningStatus)
+    a.Handle("/streams/waiting", this.waitingStatus)
+    a.Handle("/streams/switching", this.switchingStatus)
+    a.Handle("/probes", this.probesStatus)
+}
+
+func (this *Node) status() any {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+    return nodeStatus{ID: this.id, RP: this.isRP(), Servers: this.servers}
+}
+
+func (this *Node) neighboursStatus() any {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[netip.Addr]neighbourStatus, len(this.neighbours))
+    for addr, ni := range this.neighbours {
+        status := neighbourStatus{Port: ni.port, Bootstrapper: ni.metrics, Current: ni.metrics}
+        if this.monitor != nil {
+            status.Current = this.linkMetrics(addr)
+            status.Throughput = this.monitor.Throughput(addr)
+        }
+        ans[addr] = status
+    }
+    return ans
+}
+
+func (this *Node) runningStatus() any {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[string]runningStatus, len(this.runningStreams))
+    for streamID, s := range this.runningStreams {
+        ans[streamID] = runningStatus{
+            From: s.from,
+            ToLocal: s.toLocal,
+            Subscribers: s.to.ToSlice(),
+            Bitrate: s.metadata.Bitrate,
+            Metrics: s.metrics,
+            Path: s.path,
+        }
+    }
+    return ans
+}
+
+func (this *Node) waitingStatus() any {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[string]waitingStatus, len(this.waitingStreams))
+    for streamID, w := range this.waitingStreams {
+        ans[streamID] = waitingStatus{LocalPort: w.localPort, Subscribers: w.to.ToSlice()}
+    }
+    return ans
+}
+
+func (this *Node) switchingStatus() any {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[string]switchStatus, len(this.switching))
+    for streamID, sw := range this.switching {
+        ans[streamID] = switchStatus{To: sw.to, Port: sw.port}
+    }
+    return ans
+}
+
+func (this *Node) probesStatus() any {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[uint32]probeStatus, len(this.probeResponses))
+    for requestID, resp := range this.probeResponses {
+        status := probeStatus{From: resp.from, Exists: resp.stream != nil, Metrics: resp.metrics, Path: resp.path}
+        if resp.stream != nil {
+            status.Bitrate = resp.stream.Bitrate
+        }
+        ans[requestID] = status
+    }
+    return ans
+}
@@ -0,0 +1,73 @@
+package node
+
+import (
+    "context"
+    "net/netip"
+    "slices"
+    "time"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/service"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//Time each hop keeps for itself when forwarding a catalog request: the peers get the rest of the timeout
+const catalogMargin = 100 * time.Millisecond
+
+//Floods the catalog request to the neighbours (except the source) and the servers, like a probe,
+//and answers with the merged catalogs of every peer that answers in time.
+//A request seen before is answered right away with an empty catalog, so the flood doesn't wait on cycles
+func (this *Node) handleCatalog(msg service.TCPMessage, req packet.CatalogRequest) {
+    this.mutex.Lock()
+    duplicate := this.catalogRequests.Contains(req.RequestID)
+    this.catalogRequests.Add(req.RequestID)
+
+    peers := make([]netip.AddrPort, 0, len(this.neighbours) + len(this.servers))
+    for addr, ni := range this.neighbours {
+        if addr != msg.Addr().Addr() {
+            peers = append(peers, netip.AddrPortFrom(addr, ni.port))
+        }
+    }
+    peers = append(peers, this.servers...)
+    this.mutex.Unlock()
+
+    forward := req
+    forward.Timeout = req.Timeout - 2 * catalogMargin
+    if duplicate || len(peers) == 0 || forward.Timeout <= 0 {
+        utils.Warn(msg.SendResponse(req.Respond()))
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), req.Timeout - catalogMargin)
+    defer cancel()
+
+    //the interceptor is registered before sending, so no response can be missed
+    answers := service.InterceptContext(ctx, &this.serv, func(sig service.Signal) bool {
+        m, ok := sig.(service.TCPMessage)
+        if !ok { return false }
+
+        resp, ok := m.Packet().(packet.CatalogResponse)
+        return ok && resp.RequestID == req.RequestID && slices.ContainsFunc(peers, func(p netip.AddrPort) bool {
+            return p.Addr() == m.Addr().Addr()
+        })
+    }, len(peers))
+
+    sent := 0
+    for _, p := range peers {
+        err := this.serv.TCPServer().SendConnect(forward, p)
+        if err != nil {
+            utils.Warn(err)
+            continue
+        }
+        sent++
+    }
+
+    catalogs := make([][]packet.CatalogEntry, 0, sent)
+    for len(catalogs) < sent {
+        sig, ok := <-answers
+        if !ok { break }
+        catalogs = append(catalogs, sig.(service.TCPMessage).Packet().(packet.CatalogResponse).Streams)
+    }
+
+    utils.Warn(msg.SendResponse(req.Respond(packet.MergeCatalogs(catalogs...)...)))
+}
@@ -0,0 +1,118 @@
+package node
+
+import (
+    "net/netip"
+    "reflect"
+
+    "github.com/SLP25/ESR/internal/exporter"
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//The counters of the node, scraped from the admin API (/metrics)
+type nodeExporter struct {
+    registry *exporter.Registry
+    forwardedPackets *exporter.CounterVec
+    forwardedBytes *exporter.CounterVec
+    receivedPackets *exporter.CounterVec
+    receivedBytes *exporter.CounterVec
+    probes *exporter.CounterVec
+    probeTimeouts *exporter.CounterVec
+    control *exporter.CounterVec
+    switches *exporter.CounterVec
+    lost *exporter.CounterVec
+    nacked *exporter.CounterVec
+    recovered *exporter.CounterVec
+    duplicates *exporter.CounterVec
+    retransmitted *exporter.CounterVec
+    repairsSent *exporter.CounterVec
+    repaired *exporter.CounterVec
+}
+
+func (this *Node) registerMetrics() {
+    r := exporter.NewRegistry()
+
+    this.exported = nodeExporter{
+        registry: r,
+        forwardedPackets: r.Counter("esr_node_forwarded_packets_total", "Stream packets forwarded", "stream", "neighbour"),
+        forwardedBytes: r.Counter("esr_node_forwarded_bytes_total", "Bytes of stream content forwarded", "stream", "neighbour"),
+        receivedPackets: r.Counter("esr_node_received_packets_total", "Stream packets received from the upstream", "stream"),
+        receivedBytes: r.Counter("esr_node_received_bytes_total", "Bytes of stream content received from the upstream", "stream"),
+        probes: r.Counter("esr_node_probe_requests_total", "Probe requests received, by outcome (handled, duplicate or local)", "outcome"),
+        probeTimeouts: r.Counter("esr_node_probe_timeouts_total", "Probes left unanswered, by whom (server or stream, when no response arrived for a StreamRequest)", "kind"),
+        control: r.Counter("esr_node_control_packets_total", "Control packets received, by type", "type"),
+        switches: r.Counter("esr_node_upstream_switches_total", "Upstream switches, by outcome (completed or aborted)", "outcome"),
+        lost: r.Counter("esr_node_lost_packets_total", "Stream packets lost from the upstream and given up on", "stream"),
+        nacked: r.Counter("esr_node_nacked_packets_total", "Stream packets asked for again to the upstream (each request counts)", "stream"),
+        recovered: r.Counter("esr_node_recovered_packets_total", "Stream packets lost from the upstream and received once asked for again", "stream"),
+        duplicates: r.Counter("esr_node_duplicate_packets_total", "Stream packets received more than once, which aren't forwarded", "stream"),
+        retransmitted: r.Counter("esr_node_retransmitted_packets_total", "Stream packets sent again to the subscribers that lost them", "stream"),
+        repairsSent: r.Counter("esr_node_fec_repairs_sent_total", "FEC repairs sent over lossy links", "stream", "neighbour"),
+        repaired: r.Counter("esr_node_fec_recovered_packets_total", "Stream packets lost from the upstream and rebuilt from its FEC repairs", "stream"),
+    }
+
+    r.GaugeFunc("esr_node_subscribers", "Subscribers of each running stream, its VOD sessions included", func(emit func(float64, ...string)) {
+        this.mutex.Lock()
+        subscribers := make(map[string]int, len(this.runningStreams))
+        for streamID, s := range this.runningStreams {
+            subscribers[packet.SharedStreamID(streamID)] += s.to.Length()
+        }
+        this.mutex.Unlock()
+
+        for streamID, n := range subscribers {
+            emit(float64(n), streamID)
+        }
+    }, "stream")
+
+    r.GaugeFunc("esr_node_waiting_streams", "Streams waiting for a StreamResponse", func(emit func(float64, ...string)) {
+        this.mutex.Lock()
+        defer this.mutex.Unlock()
+        emit(float64(len(this.waitingStreams)))
+    })
+
+    //the latest smoothed metrics of every monitored peer (servers and neighbours)
+    r.GaugeFunc("esr_node_peer_latency_seconds", "Latest smoothed latency to each monitored peer", func(emit func(float64, ...string)) {
+        for addr, m := range this.peerMetrics() {
+            emit(m.Latency.Seconds(), addr.String())
+        }
+    }, "peer")
+    r.GaugeFunc("esr_node_peer_packet_loss_ratio", "Latest smoothed packet loss to each monitored peer", func(emit func(float64, ...string)) {
+        for addr, m := range this.peerMetrics() {
+            emit(m.PacketLoss, addr.String())
+        }
+    }, "peer")
+    r.GaugeFunc("esr_node_peer_bandwidth_bits_per_second", "Latest bandwidth estimate to each monitored peer (0 if unknown)", func(emit func(float64, ...string)) {
+        for addr, m := range this.peerMetrics() {
+            emit(float64(m.Bandwidth), addr.String())
+        }
+    }, "peer")
+}
+
+func (this *Node) peerMetrics() map[netip.AddrPort]utils.Metrics {
+    this.mutex.Lock()
+    monitor := this.monitor
+    this.mutex.Unlock()
+
+    if monitor == nil {
+        return nil
+    }
+    return monitor.Peers()
+}
+
+//Counts a control packet by its type (StreamRequest, StreamCancel, StreamEnd, ...)
+func (this *nodeExporter) controlPacket(p packet.Packet) {
+    this.control.With(reflect.TypeOf(p).Name()).Inc()
+}
+
+//Counts a stream packet received and forwarded to the addresses.
+//VOD sessions are counted under their stream, so there's a bounded number of series
+func (this *Node) countForwarded(streamID string, p packet.StreamPacket, to []netip.AddrPort) {
+    streamID = packet.SharedStreamID(streamID)
+
+    this.exported.receivedPackets.With(streamID).Inc()
+    this.exported.receivedBytes.With(streamID).Add(float64(len(p.Content)))
+    for _, addrport := range to {
+        this.exported.forwardedPackets.With(streamID, addrport.Addr().String()).Inc()
+        this.exported.forwardedBytes.With(streamID, addrport.Addr().String()).Add(float64(len(p.Content)))
+    }
+}
@@ -0,0 +1,236 @@
+package node
+
+import (
+    "context"
+    "log/slog"
+    "net/netip"
+    "slices"
+    "time"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/service"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+const failoverPeriod = 5 * time.Second          //how often the upstream of each stream is reevaluated
+const reprobeTimeout = 500 * time.Millisecond   //time given to the neighbours to answer a local probe
+const switchTimeout = 2 * time.Second           //time given to the new upstream to start sending packets
+
+//An alternative must beat the current upstream with its metrics improved by 20%, 5ms and 1% of loss
+const switchMargin = 0.2
+const switchLatencyMargin = 5 * time.Millisecond
+const switchLossMargin = 0.01
+
+//A subscription to a new upstream, made while the old one keeps sending.
+//It replaces the old one once its first packet arrives (make-before-break)
+type switchover struct {
+    to netip.AddrPort
+    port uint16
+    requestID uint32
+    metrics utils.Metrics
+    path []uint32
+}
+
+//Whether the alternative is enough of an improvement to switch to it.
+//The margin keeps streams from flapping between paths of similar quality
+func worthSwitching(current utils.Metrics, alternative utils.Metrics) bool {
+    handicapped := utils.Metrics{
+        Latency: max(0, time.Duration(float64(current.Latency) * (1 - switchMargin)) - switchLatencyMargin),
+        PacketLoss: max(0, current.PacketLoss * (1 - switchMargin) - switchLossMargin),
+        Bandwidth: int(float64(current.Bandwidth) * (1 + switchMargin)),
+    }
+    return !handicapped.BetterThan(alternative)
+}
+
+//Reevaluates the upstream of every running stream periodically, until the node closes
+func (this *Node) watchUpstreams() {
+    for {
+        select {
+            case <-this.stop: return
+            case <-time.After(failoverPeriod):
+        }
+
+        this.mutex.Lock()
+        streamIDs := make([]string, 0, len(this.runningStreams))
+        for streamID := range this.runningStreams {
+            if _, ok := this.switching[streamID]; !ok {
+                streamIDs = append(streamIDs, streamID)
+            }
+        }
+        this.mutex.Unlock()
+
+        for _, streamID := range streamIDs {
+            if this.isRP() {
+                go this.reevaluateServer(streamID)
+            } else {
+                go this.reevaluateUpstream(streamID)
+            }
+        }
+    }
+}
+
+//Probes the servers again and switches to the chosen one if its path is clearly better than the current one's
+func (this *Node) reevaluateServer(streamID string) {
+    resp, addr := this.probeServers(packet.ProbeRequest{StreamID: streamID, RequestID: utils.RandID()})
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    s, ok := this.runningStreams[streamID]
+    if !ok { return }
+
+    current := this.monitor.GetMetrics(this.serverAddr(s.from))
+    s.metrics = current
+
+    if resp.Exists && addr != s.from && worthSwitching(current, resp.Metrics) {
+        slog.Info("Switching stream to a better server", "streamID", streamID, "from", s.from, "to", addr)
+        this.startSwitch(streamID, this.serverAddr(addr), resp.Metrics, resp.Path)
+    }
+}
+
+//Asks the neighbours carrying the stream for their current metrics (a local probe, which isn't propagated)
+//and switches to the best of them if it's clearly better than the current upstream.
+//If the current upstream doesn't answer, any alternative is better
+func (this *Node) reevaluateUpstream(streamID string) {
+    this.mutex.Lock()
+    neighbours := make(map[netip.Addr]netip.AddrPort, len(this.neighbours))
+    for addr, ni := range this.neighbours {
+        neighbours[addr] = netip.AddrPortFrom(addr, ni.port)
+    }
+    this.mutex.Unlock()
+
+    req := packet.ProbeRequest{StreamID: streamID, RequestID: utils.RandID(), Local: true}
+    ctx, cancel := context.WithTimeout(context.Background(), reprobeTimeout)
+    defer cancel()
+
+    answers := service.InterceptContext(ctx, &this.serv, func(sig service.Signal) bool {
+        msg, ok := sig.(service.TCPMessage)
+        if !ok { return false }
+
+        resp, ok := msg.Packet().(packet.ProbeResponse)
+        if !ok { return false }
+
+        _, neighbour := neighbours[msg.Addr().Addr()]
+        return neighbour && resp.RequestID == req.RequestID
+    }, len(neighbours))
+
+    for _, addr := range neighbours {
+        utils.Warn(this.serv.TCPServer().SendConnect(req, addr))
+    }
+
+    responses := make(map[netip.Addr]packet.ProbeResponse)
+    for sig := range answers {
+        msg := sig.(service.TCPMessage)
+        responses[msg.Addr().Addr()] = msg.Packet().(packet.ProbeResponse)
+    }
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    s, ok := this.runningStreams[streamID]
+    if !ok { return }
+    if _, ok := this.switching[streamID]; ok { return }
+
+    var best netip.Addr
+    var bestMetrics utils.Metrics
+    for addr, resp := range responses {
+        if addr == s.from || !resp.Exists || slices.Contains(resp.Path, this.id) || !this.fitsAditional(s.metadata.Bitrate, addr) {
+            continue
+        }
+
+        m := this.linkMetrics(addr).Compose(resp.Metrics)
+        if !best.IsValid() || !bestMetrics.BetterThan(m) {
+            best, bestMetrics = addr, m
+        }
+    }
+
+    current, alive := responses[s.from]
+    if alive && current.Exists {
+        s.metrics = this.linkMetrics(s.from).Compose(current.Metrics)
+        s.path = current.Path
+    }
+
+    if best.IsValid() && (!alive || !current.Exists || worthSwitching(s.metrics, bestMetrics)) {
+        slog.Info("Switching stream to a better upstream", "streamID", streamID, "from", s.from, "to", best)
+        this.startSwitch(streamID, neighbours[best], bestMetrics, responses[best].Path)
+    }
+}
+
+//Subscribes to the stream from the new upstream on a new port. Must be called with the mutex locked
+func (this *Node) startSwitch(streamID string, to netip.AddrPort, metrics utils.Metrics, path []uint32) {
+    var port uint16
+    err := this.serv.AddUDPServer(&port)
+    if err != nil {
+        slog.Error("Unable to open a port for the new upstream", "streamID", streamID, "err", err)
+        return
+    }
+
+    sw := &switchover{to: to, port: port, requestID: utils.RandID(), metrics: metrics, path: path}
+    this.switching[streamID] = sw
+
+    p := packet.StreamRequest{StreamID: streamID, RequestID: sw.requestID, Port: port}
+    err = this.serv.TCPServer().SendConnect(p, to)
+    if err != nil {
+        slog.Warn("Unable to request the stream from the new upstream", "streamID", streamID, "addr", to, "err", err)
+        this.abortSwitch(streamID)
+        return
+    }
+
+    go func() {
+        time.Sleep(switchTimeout)
+        this.mutex.Lock()
+        defer this.mutex.Unlock()
+
+        if this.switching[streamID] == sw {
+            slog.Warn("New upstream didn't send the stream in time", "streamID", streamID, "addr", to)
+            this.abortSwitch(streamID)
+        }
+    }()
+}
+
+//Cancels the subscription to the new upstream. Must be called with the mutex locked
+func (this *Node) abortSwitch(streamID string) {
+    sw := this.switching[streamID]
+    delete(this.switching, streamID)
+    this.exported.switches.With("aborted").Inc()
+
+    utils.Warn(this.serv.TCPServer().Send(packet.StreamCancel{StreamID: streamID, Port: sw.port}, sw.to.Addr()))
+    utils.Warn(this.serv.RemoveUDPServer(sw.port))
+}
+
+//Called when a packet arrives at a port. If it's the port of a switchover,
+//the new upstream replaces the old one, which is canceled. Must be called with the mutex locked
+func (this *Node) completeSwitch(localPort uint16) {
+    for streamID, sw := range this.switching {
+        if sw.port != localPort {
+            continue
+        }
+
+        s, ok := this.runningStreams[streamID]
+        if !ok { //the stream ended in the meantime
+            this.abortSwitch(streamID)
+            return
+        }
+
+        delete(this.switching, streamID)
+        utils.Warn(this.serv.TCPServer().Send(packet.StreamCancel{StreamID: streamID, Port: s.toLocal}, s.from))
+        utils.Warn(this.serv.RemoveUDPServer(s.toLocal))
+
+        s.from = sw.to.Addr()
+        s.toLocal = sw.port
+        s.metrics = sw.metrics
+        s.path = sw.path
+        this.exported.switches.With("completed").Inc()
+        return
+    }
+}
+
+//Returns the address (with the port) of the server with the given address
+func (this *Node) serverAddr(addr netip.Addr) netip.AddrPort {
+    for _, s := range this.servers {
+        if s.Addr() == addr {
+            return s
+        }
+    }
+    return netip.AddrPortFrom(addr, 0)
+}
@@ -0,0 +1,50 @@
+package node
+
+import (
+    "testing"
+    "time"
+
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//An alternative must beat the current upstream by 20%, then 5ms of latency or 1% of loss
+func TestWorthSwitching(t *testing.T) {
+    t.Cleanup(func() { utils.SetCostFunction(utils.DefaultCost) })
+
+    tests := []struct {
+        name string
+        cost utils.CostFunction
+        current utils.Metrics
+        alternative utils.Metrics
+        want bool
+    }{
+        //100ms handicapped to 75ms
+        {"latency beyond the margins", utils.LatencyCost, utils.Metrics{Latency: 100 * time.Millisecond}, utils.Metrics{Latency: 74 * time.Millisecond}, true},
+        {"latency at the margins", utils.LatencyCost, utils.Metrics{Latency: 100 * time.Millisecond}, utils.Metrics{Latency: 75 * time.Millisecond}, false},
+        {"latency within 20%", utils.LatencyCost, utils.Metrics{Latency: 100 * time.Millisecond}, utils.Metrics{Latency: 79 * time.Millisecond}, false},
+        //20% of 10ms is less than the 5ms that are also required
+        {"latency within 5ms", utils.LatencyCost, utils.Metrics{Latency: 10 * time.Millisecond}, utils.Metrics{Latency: 4 * time.Millisecond}, false},
+        {"nothing beats a path of 5ms", utils.LatencyCost, utils.Metrics{Latency: 5 * time.Millisecond}, utils.Metrics{}, false},
+
+        //10% of loss handicapped to 7%
+        {"loss beyond the margins", utils.LossCost, utils.Metrics{PacketLoss: 0.1}, utils.Metrics{PacketLoss: 0.065}, true},
+        {"loss within the margins", utils.LossCost, utils.Metrics{PacketLoss: 0.1}, utils.Metrics{PacketLoss: 0.075}, false},
+        {"nothing beats 1% of loss", utils.LossCost, utils.Metrics{PacketLoss: 0.01}, utils.Metrics{}, false},
+
+        //1Mbps handicapped to 1.2Mbps
+        {"bandwidth beyond the margin", utils.BandwidthCost, utils.Metrics{Bandwidth: 1000000}, utils.Metrics{Bandwidth: 1300000}, true},
+        {"bandwidth within the margin", utils.BandwidthCost, utils.Metrics{Bandwidth: 1000000}, utils.Metrics{Bandwidth: 1150000}, false},
+
+        //75ms and 2% handicapped to 55ms and 0.6%: a cost of 85
+        {"default cost beyond the margins", utils.DefaultCost, utils.Metrics{Latency: 75 * time.Millisecond, PacketLoss: 0.02}, utils.Metrics{Latency: 80 * time.Millisecond}, true},
+        {"default cost within the margins", utils.DefaultCost, utils.Metrics{Latency: 75 * time.Millisecond, PacketLoss: 0.02}, utils.Metrics{Latency: 90 * time.Millisecond}, false},
+        {"a worse alternative", utils.DefaultCost, utils.Metrics{Latency: 10 * time.Millisecond}, utils.Metrics{Latency: 20 * time.Millisecond}, false},
+    }
+
+    for _, test := range tests {
+        utils.SetCostFunction(test.cost)
+        if got := worthSwitching(test.current, test.alternative); got != test.want {
+            t.Errorf("%s: switching from %+v to %+v is %v, want %v", test.name, test.current, test.alternative, got, test.want)
+        }
+    }
+}
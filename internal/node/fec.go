@@ -0,0 +1,99 @@
+package node
+
+import (
+    "net/netip"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/recovery"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//Decides on which links to the neighbours the stream packets are protected by FEC repairs
+type FECPolicy int
+
+const (
+    FECAuto FECPolicy = iota    //the links whose packet loss reaches fecMinLoss
+    FECAlways                   //every link to a neighbour
+    FECOff
+)
+
+//Packet loss of a link from which its packets are protected (with FECAuto)
+const fecMinLoss = 0.01
+
+var fecPolicyNames = map[string]FECPolicy{
+    "auto": FECAuto,
+    "always": FECAlways,
+    "off": FECOff,
+}
+
+//Returns the FEC policy with the given name (auto, always or off)
+func FECPolicyByName(name string) (FECPolicy, bool) {
+    p, ok := fecPolicyNames[name]
+    return p, ok
+}
+
+//Sets on which links the stream packets are protected by FEC. Must be called before Run
+func (this *Node) SetFECPolicy(policy FECPolicy) {
+    this.fec = policy
+}
+
+//Returns the size of the groups of packets protected by a repair on the link to the address,
+//or false if the link isn't protected. Clients' links never are, as their loss isn't measured.
+//Must be called with the mutex locked
+func (this *Node) fecGroupSize(addr netip.Addr) (int, bool) {
+    if _, ok := this.neighbours[addr]; !ok || this.fec == FECOff {
+        return 0, false
+    }
+
+    loss := this.linkMetrics(addr).PacketLoss
+    if this.fec == FECAuto && loss < fecMinLoss {
+        return 0, false
+    }
+    return recovery.GroupSize(loss), true
+}
+
+//Forwards a packet of the running stream received from the upstream to the subscribers, followed by the repairs
+//of the groups it completes. The packets it allows to rebuild are forwarded too. Must be called with the mutex locked
+func (this *Node) forward(streamID string, p packet.StreamPacket) {
+    if !this.receive(streamID, p) {
+        return
+    }
+
+    s := this.runningStreams[streamID]
+    to := s.to.ToSlice()
+    for _, addrport := range to {
+        utils.Warn(this.serv.SendUDP(p, addrport))
+
+        size, ok := this.fecGroupSize(addrport.Addr())
+        if !ok {
+            continue
+        }
+
+        encoder, ok := s.encoders[addrport]
+        if !ok {
+            encoder = recovery.NewEncoder()
+            s.encoders[addrport] = encoder
+        }
+        if r, ok := encoder.Add(p, size); ok {
+            utils.Warn(this.serv.SendUDP(r, addrport))
+            this.exported.repairsSent.With(packet.SharedStreamID(streamID), addrport.Addr().String()).Inc()
+        }
+    }
+    this.countForwarded(streamID, p, to)
+
+    for _, rebuilt := range s.decoder.Recover(s.sent, s.received) {
+        this.exported.repaired.With(packet.SharedStreamID(streamID)).Inc()
+        this.forward(streamID, rebuilt)
+    }
+}
+
+//Rebuilds the packets of the running stream lost from the upstream with a repair, and forwards them.
+//Must be called with the mutex locked
+func (this *Node) repair(streamID string, r packet.StreamRepair) {
+    s := this.runningStreams[streamID]
+    s.decoder.Add(r)
+    for _, rebuilt := range s.decoder.Recover(s.sent, s.received) {
+        this.exported.repaired.With(packet.SharedStreamID(streamID)).Inc()
+        this.forward(streamID, rebuilt)
+    }
+}
@@ -0,0 +1,330 @@
+package node
+
+import (
+	"bytes"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//Number and payload size of the pings of a train
+const trainLength = 8
+const trainPayload = 1200
+
+//Minimum time between the trains sent to an address, as they burden slow links
+const trainPeriod = time.Minute
+
+//Passive estimation: the throughput is measured over windows, and the highest of the last ones is kept
+const throughputWindow = time.Second
+const throughputWindows = 10
+
+//Latency of a peer that never answered a ping
+const unreachableLatency = time.Hour
+
+//The timeout is the same as the interval between packets.
+//The latency is 0 (unknown) if no ping is answered, as the loss already accounts for it.
+//If train is set, the bandwidth is estimated by a packet train. Otherwise, or if it can't be, it's returned as 0
+func measureMetrics(transport service.Transport, address netip.AddrPort, packets int, interval time.Duration, train bool) (utils.Metrics, error) {
+	var port uint16
+	var server service.UDPServer
+
+	err := server.OpenWith(transport, &port)
+	if err != nil { return utils.Metrics{}, err}
+	defer server.Close()
+
+	var totalLatency time.Duration
+	receivedPackets := 0
+
+	for i := 0; i < packets; i++ {
+		p := packet.NewPing()
+		err := server.Send(p, address)
+		if err != nil { return utils.Metrics{}, err }
+
+		sent := time.Now()
+		timeout := time.After(interval)
+		L: for {
+			select {
+				case <-timeout: break L
+				case msg := <-server.Output():
+					resp, err := packet.Deserialize(bytes.NewReader(msg.Data))
+					if err != nil { slog.Warn("Error deserializing response to ping", "err", err); continue L }
+					
+					ping, ok := resp.(packet.Ping)
+					if !ok { slog.Warn("Received invalid response to ping", "response", resp); continue L }
+					
+					if ping.ID != p.ID { slog.Warn("Received ping with invalid ID", "sendID", p.ID, "receivedID", ping.ID); continue L }
+			
+					totalLatency += time.Now().Sub(sent)
+					receivedPackets++
+			}
+		}
+	}
+
+	var latency time.Duration
+	if receivedPackets != 0 {
+		latency = totalLatency / time.Duration(receivedPackets)
+	}
+
+	var bandwidth int
+	if train {
+		bandwidth, err = measureBandwidth(&server, address, interval)
+		if err != nil { return utils.Metrics{}, err }
+	}
+
+	metrics := utils.Metrics{
+		Latency: latency,
+		PacketLoss: float64(packets - receivedPackets) / float64(packets),
+		Bandwidth: bandwidth,
+	}
+
+	return metrics, nil
+}
+
+//Sends a train of pings back to back and measures the dispersion of the echoes:
+//the bottleneck of the path spaces them by the time it takes to transmit one.
+//Returns 0 if less than 2 echoes arrive before the timeout
+func measureBandwidth(server *service.UDPServer, address netip.AddrPort, timeout time.Duration) (int, error) {
+	ids := utils.EmptySet[uint32]()
+	size := 0
+
+	for i := 0; i < trainLength; i++ {
+		p := packet.Ping{ID: utils.RandID(), Data: make([]byte, trainPayload)}
+		if size == 0 {
+			var buf bytes.Buffer
+			_, err := packet.Serialize(p, &buf)
+			if err != nil { return 0, err }
+			size = buf.Len()
+		}
+
+		ids.Add(p.ID)
+		err := server.Send(p, address)
+		if err != nil { return 0, err }
+	}
+
+	var first, last time.Time
+	received := 0
+	deadline := time.After(timeout)
+	L: for received < trainLength {
+		select {
+			case <-deadline: break L
+			case msg := <-server.Output():
+				resp, err := packet.Deserialize(bytes.NewReader(msg.Data))
+				if err != nil { slog.Warn("Error deserializing response to ping", "err", err); continue L }
+
+				ping, ok := resp.(packet.Ping)
+				if !ok || !ids.Contains(ping.ID) { continue L } //late echoes of the latency pings are expected
+
+				last = time.Now()
+				if received == 0 { first = last }
+				received++
+		}
+	}
+
+	dispersion := last.Sub(first)
+	if received < 2 || dispersion <= 0 {
+		return 0, nil
+	}
+	return int(float64((received - 1) * size * 8) / dispersion.Seconds()), nil
+}
+
+
+//Throughput of the stream packets received from a peer.
+//It's a lower bound of the bandwidth of the link, measured without sending anything
+type throughputMeter struct {
+	start time.Time	//of the current window
+	bytes int		//received in the current window
+	rates [throughputWindows]int
+	ends [throughputWindows]time.Time	//of the windows of the rates
+	next int
+}
+
+func (this *throughputMeter) add(now time.Time, bytes int) {
+	if this.start.IsZero() {
+		this.start = now
+	} else if elapsed := now.Sub(this.start); elapsed >= throughputWindow {
+		this.rates[this.next] = int(float64(this.bytes * 8) / elapsed.Seconds())
+		this.ends[this.next] = now
+		this.next = (this.next + 1) % throughputWindows
+		this.start = now
+		this.bytes = 0
+	}
+	this.bytes += bytes
+}
+
+//Highest throughput of the windows that ended recently, in bits per second.
+//Once the peer stops sending, its old windows expire and the throughput drops to 0
+func (this *throughputMeter) peak(now time.Time) int {
+	ans := 0
+	for i, rate := range this.rates {
+		if now.Sub(this.ends[i]) < throughputWindow * throughputWindows {
+			ans = max(ans, rate)
+		}
+	}
+	return ans
+}
+
+
+//Weight of a new measurement in the smoothed metrics (EWMA)
+const smoothingWeight = 0.25
+
+//Servers are measured thoroughly but rarely, neighbours briefly but often
+const serverPeriod = 10 * time.Second
+const neighbourPeriod = 2 * time.Second
+
+//Exponentially weighted moving average of the measurements.
+//An unknown latency or bandwidth (0) keeps the previous estimate, so a round without echoes only counts as loss.
+//The first latency measured replaces the one of a peer that was unreachable until then
+func smooth(old utils.Metrics, m utils.Metrics) utils.Metrics {
+	ans := utils.Metrics{
+		Latency: old.Latency,
+		PacketLoss: (1 - smoothingWeight) * old.PacketLoss + smoothingWeight * m.PacketLoss,
+		Bandwidth: old.Bandwidth,
+	}
+
+	if m.Latency != 0 && old.Latency == unreachableLatency {
+		ans.Latency = m.Latency
+	} else if m.Latency != 0 {
+		ans.Latency = time.Duration((1 - smoothingWeight) * float64(old.Latency) + smoothingWeight * float64(m.Latency))
+	}
+
+	if old.Bandwidth == 0 {
+		ans.Bandwidth = m.Bandwidth
+	} else if m.Bandwidth != 0 {
+		ans.Bandwidth = int((1 - smoothingWeight) * float64(old.Bandwidth) + smoothingWeight * float64(m.Bandwidth))
+	}
+	return ans
+}
+
+
+type metricsMonitor struct {
+	transport service.Transport
+	metrics map[netip.AddrPort]utils.Metrics
+	throughput map[netip.Addr]*throughputMeter
+	trains map[netip.AddrPort]time.Time	//when a train was last sent to each address
+	mutex sync.RWMutex
+	cancel chan struct{}
+}
+
+//Measures the address. A packet train is sent at most every trainPeriod,
+//and not while the stream packets received from the address already show its bandwidth
+func (this *metricsMonitor) updateMetrics(addr netip.AddrPort, packets int, interval time.Duration) {
+    train := this.Throughput(addr.Addr()) == 0
+    this.mutex.Lock()
+    if train && time.Since(this.trains[addr]) >= trainPeriod {
+        this.trains[addr] = time.Now()
+    } else {
+        train = false
+    }
+    this.mutex.Unlock()
+
+    m, err := measureMetrics(this.transport, addr, packets, interval, train)
+    if err != nil {
+        slog.Error("Error updating metrics", "addr", addr, "err", err)
+        return
+    }
+
+    this.mutex.Lock()
+    if old, ok := this.metrics[addr]; ok {
+        m = smooth(old, m)
+    } else if m.Latency == 0 {
+        m.Latency = unreachableLatency
+    }
+    this.metrics[addr] = m
+    this.mutex.Unlock()
+	slog.Debug("Calculated new metrics", "addr", addr, "metrics", m)
+}
+
+//Measures the addresses every period, until the monitor is stopped
+func (this *metricsMonitor) measure(addrs []netip.AddrPort, period time.Duration, packets int, interval time.Duration) {
+	for {
+		for _, a := range addrs {
+			go this.updateMetrics(a, packets, interval)
+		}
+
+		select {
+			case <-this.cancel: return
+			case <-time.After(period):
+		}
+	}
+}
+
+//Measures the paths to the servers and the links to the neighbours (which answer pings on their TCP port)
+func (this *Node) monitorMetrics(servers []netip.AddrPort, neighbours []netip.AddrPort) *metricsMonitor {
+	ans := &metricsMonitor{
+		transport: this.serv.Transport(),
+		metrics: make(map[netip.AddrPort]utils.Metrics),
+		throughput: make(map[netip.Addr]*throughputMeter),
+		trains: make(map[netip.AddrPort]time.Time),
+		cancel: make(chan struct{}),
+	}
+
+	go ans.measure(servers, serverPeriod, 10, 200 * time.Millisecond)
+	go ans.measure(neighbours, neighbourPeriod, 5, 100 * time.Millisecond)
+
+	return ans
+}
+
+//Registers bytes of stream packets received from the given peer
+func (this *metricsMonitor) Observe(from netip.Addr, bytes int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	meter, ok := this.throughput[from]
+	if !ok {
+		meter = &throughputMeter{}
+		this.throughput[from] = meter
+	}
+	meter.add(time.Now(), bytes)
+}
+
+//Returns the highest recent throughput received from the peer, in bits per second (0 if none)
+func (this *metricsMonitor) Throughput(from netip.Addr) int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if meter, ok := this.throughput[from]; ok {
+		return meter.peak(time.Now())
+	}
+	return 0
+}
+
+//Returns the smoothed metrics of the address, and whether it was measured yet.
+//The bandwidth is the highest of the packet train estimate and the observed throughput
+func (this *metricsMonitor) Measured(addr netip.AddrPort) (utils.Metrics, bool) {
+	this.mutex.RLock()
+	m, ok := this.metrics[addr]
+	this.mutex.RUnlock()
+
+	m.Bandwidth = max(m.Bandwidth, this.Throughput(addr.Addr()))
+	return m, ok
+}
+
+func (this *metricsMonitor) GetMetrics(server netip.AddrPort) utils.Metrics {
+	m, _ := this.Measured(server)
+	return m
+}
+
+func (this *metricsMonitor) Stop() {
+	close(this.cancel)
+}
+
+//Returns the smoothed metrics of every address measured so far
+func (this *metricsMonitor) Peers() map[netip.AddrPort]utils.Metrics {
+	this.mutex.RLock()
+	addrs := make([]netip.AddrPort, 0, len(this.metrics))
+	for addr := range this.metrics {
+		addrs = append(addrs, addr)
+	}
+	this.mutex.RUnlock()
+
+	ans := make(map[netip.AddrPort]utils.Metrics, len(addrs))
+	for _, addr := range addrs {
+		ans[addr] = this.GetMetrics(addr)
+	}
+	return ans
+}
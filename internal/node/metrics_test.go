@@ -0,0 +1,123 @@
+package node
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+func TestThroughputPeak(t *testing.T) {
+	var meter throughputMeter
+	start := time.Now()
+
+	//1250 bytes every 10ms for 3s: 1Mbps
+	now := start
+	for ; now.Sub(start) < 3 * time.Second; now = now.Add(10 * time.Millisecond) {
+		meter.add(now, 1250)
+	}
+	if got := meter.peak(now); got < 900000 || got > 1100000 {
+		t.Errorf("peak %d bps while receiving 1Mbps", got)
+	}
+
+	//an idle peer keeps its peak for a while, then it expires
+	if got := meter.peak(now.Add(throughputWindow)); got == 0 {
+		t.Error("peak forgotten right after the peer went idle")
+	}
+	if got := meter.peak(now.Add(throughputWindow * (throughputWindows + 1))); got != 0 {
+		t.Errorf("peak %d bps long after the peer went idle, want 0", got)
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	old := utils.Metrics{Latency: 20 * time.Millisecond, PacketLoss: 0.25, Bandwidth: 1000000}
+	tests := []struct {
+		name string
+		m utils.Metrics
+		want utils.Metrics
+	}{
+		{"measured", utils.Metrics{Latency: 60 * time.Millisecond, PacketLoss: 0.75, Bandwidth: 2000000}, utils.Metrics{Latency: 30 * time.Millisecond, PacketLoss: 0.375, Bandwidth: 1250000}},
+		{"unknown bandwidth", utils.Metrics{Latency: 20 * time.Millisecond, PacketLoss: 0.25}, old},
+		//no echo: only the loss changes
+		{"lost round", utils.Metrics{PacketLoss: 1}, utils.Metrics{Latency: 20 * time.Millisecond, PacketLoss: 0.4375, Bandwidth: 1000000}},
+	}
+
+	for _, test := range tests {
+		if got := smooth(old, test.m); got != test.want {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	//a peer unreachable until now takes its first latency as is
+	unreachable := utils.Metrics{Latency: unreachableLatency, PacketLoss: 1}
+	if got := smooth(unreachable, utils.Metrics{Latency: 10 * time.Millisecond}); got.Latency != 10 * time.Millisecond {
+		t.Errorf("latency %v once the unreachable peer answered, want 10ms", got.Latency)
+	}
+	if got := smooth(unreachable, utils.Metrics{PacketLoss: 1}); got != unreachable {
+		t.Errorf("%+v for a peer that still doesn't answer, want %+v", got, unreachable)
+	}
+}
+
+var (
+	hostA = netip.MustParseAddr("10.0.0.1")
+	hostB = netip.MustParseAddr("10.0.0.2")
+)
+
+//Echoes the datagrams received on the address, like the neighbours answer pings, until closed
+func echo(t *testing.T, network *service.MemNetwork, addr netip.AddrPort) net.PacketConn {
+	conn, err := network.Host(addr.Addr()).ListenPacket(addr.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil { return }
+			conn.WriteTo(buf[:n], from)
+		}
+	}()
+	return conn
+}
+
+//A round without any echo counts as loss, and doesn't change the latency
+func TestLostRound(t *testing.T) {
+	network := service.NewMemNetwork()
+	network.SetLink(hostA, hostB, service.LinkConditions{Latency: 10 * time.Millisecond})
+	peer := netip.AddrPortFrom(hostB, 6000)
+	conn := echo(t, network, peer)
+
+	monitor := &metricsMonitor{
+		transport: network.Host(hostA),
+		metrics: make(map[netip.AddrPort]utils.Metrics),
+		throughput: make(map[netip.Addr]*throughputMeter),
+		trains: make(map[netip.AddrPort]time.Time),
+	}
+	monitor.updateMetrics(peer, 3, 100 * time.Millisecond)
+	before, ok := monitor.Measured(peer)
+	if !ok || before.PacketLoss != 0 || before.Latency < 20 * time.Millisecond || before.Latency > 40 * time.Millisecond {
+		t.Fatalf("measured %+v (%v), want a 20ms round trip without loss", before, ok)
+	}
+
+	conn.Close()
+	m, err := measureMetrics(network.Host(hostA), peer, 3, 50 * time.Millisecond, false)
+	if err != nil || m.Latency != 0 || m.PacketLoss != 1 {
+		t.Errorf("measured %+v (%v) without echoes, want an unknown latency and a loss of 1", m, err)
+	}
+
+	monitor.updateMetrics(peer, 3, 50 * time.Millisecond)
+	after, _ := monitor.Measured(peer)
+	if after.Latency != before.Latency || after.PacketLoss != smoothingWeight {
+		t.Errorf("%+v after a lost round, want the latency of %+v and a loss of %v", after, before, smoothingWeight)
+	}
+
+	//a peer that never answered is unreachable
+	other := netip.AddrPortFrom(hostB, 6001)
+	monitor.updateMetrics(other, 2, 20 * time.Millisecond)
+	if m, _ := monitor.Measured(other); m.Latency != unreachableLatency || m.PacketLoss != 1 {
+		t.Errorf("measured %+v for a peer that never answered", m)
+	}
+}
@@ -0,0 +1,675 @@
+package node
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+
+type probeResponse struct {
+    from netip.Addr
+    stream *utils.StreamMetadata
+    metrics utils.Metrics
+    path []uint32
+}
+
+type probeCandidate struct {
+    resp packet.ProbeResponse
+    from netip.Addr
+}
+
+type neighbourInfo struct {
+    port uint16
+    metrics utils.Metrics
+}
+
+
+const bootTimeout = 5 * time.Second
+const probeTimeout = 1500 * time.Millisecond
+const probeWindow = 150 * time.Millisecond    //time given to the other responses to a probe once the first arrives
+const requestIDLifetime = time.Minute          //time the IDs of probes and catalogs are remembered, well past their floods
+
+//Time a StreamRequest waits for the response to its probe before ending the stream: the RP probes the servers
+//for up to probeTimeout, then each node on the way back (up to maxProbeHops) holds the responses for a probeWindow
+const maxProbeHops = 8
+const streamProbeTimeout = probeTimeout + maxProbeHops * probeWindow
+
+type Node struct {
+    serv service.Service
+    bootAddr netip.AddrPort
+    id uint32                                   //random, identifies the node in the paths of probe responses
+    stop chan struct{}                          //closed when the node closes
+    mutex sync.Mutex                            //guards the state below, which is accessed by concurrent handlers
+
+    neighbours map[netip.Addr]neighbourInfo
+    servers []netip.AddrPort
+    monitor *metricsMonitor
+    selector *serverSelector
+    fec FECPolicy
+
+    probeRequests *utils.ExpiringSet[uint32]       //seen recently, so the floods don't loop
+    probeResponses map[uint32]probeResponse     //      same here (BUT! cant delete if there is a running/waiting stream)
+    probeCandidates map[uint32][]probeCandidate //Responses collected during the window of each probe
+    catalogRequests *utils.ExpiringSet[uint32]     //seen recently, like the probes
+    runningStreams streams                      //This node is currently receiving and sending packets for these streams
+    waitingStreams map[string]*waitingStream     //This node is currently waiting for a StreamResponse for these streams
+    switching map[string]*switchover            //These streams are moving to a new upstream
+    exported nodeExporter
+}
+
+func New(bootAddr netip.AddrPort) *Node {
+    this := &Node{
+        bootAddr: bootAddr,
+        id: utils.RandID(),
+        stop: make(chan struct{}),
+        probeRequests: utils.NewExpiringSet[uint32](requestIDLifetime),
+        probeResponses: make(map[uint32]probeResponse),
+        probeCandidates: make(map[uint32][]probeCandidate),
+        catalogRequests: utils.NewExpiringSet[uint32](requestIDLifetime),
+        runningStreams: make(streams),
+        waitingStreams: make(map[string]*waitingStream),
+        switching: make(map[string]*switchover),
+        selector: newServerSelector(LeastLoaded),
+    }
+    this.registerMetrics()
+    return this
+}
+
+//Sets how the RP chooses between servers whose paths have similar scores. Must be called before Run
+func (this *Node) SetSelectionPolicy(policy SelectionPolicy) {
+    this.selector = newServerSelector(policy)
+}
+
+//Runs the node on the given local address until it is closed.
+//If the address is invalid, all local addresses are used
+func (this *Node) Run(addr netip.Addr, tcpPort uint16) error {
+    return this.RunWith(service.NetTransport{Addr: addr}, tcpPort)
+}
+
+//Runs the node through the given transport until it is closed.
+//The port is used both for TCP (control messages) and UDP (pings from the neighbours)
+func (this *Node) RunWith(transport service.Transport, tcpPort uint16) error {
+    this.serv.SetTransport(transport)
+    this.serv.AddHandler(this)
+    return this.serv.Run(&tcpPort, &tcpPort)
+}
+
+func (this *Node) Close() {
+    this.serv.Close()
+}
+
+//Upstream and subscribers of a stream running through a node
+type StreamInfo struct {
+    From netip.Addr
+    To []netip.AddrPort
+}
+
+//Returns a snapshot of the streams this node is currently forwarding
+func (this *Node) RunningStreams() map[string]StreamInfo {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[string]StreamInfo, len(this.runningStreams))
+    for streamID, s := range this.runningStreams {
+        ans[streamID] = StreamInfo{From: s.from, To: s.to.ToSlice()}
+    }
+    return ans
+}
+
+//Returns the current metrics of the links to the neighbours
+func (this *Node) NeighbourMetrics() map[netip.Addr]utils.Metrics {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := make(map[netip.Addr]utils.Metrics, len(this.neighbours))
+    for addr := range this.neighbours {
+        ans[addr] = this.linkMetrics(addr)
+    }
+    return ans
+}
+
+//The metrics of the link to a neighbour: the measured ones once available, otherwise the bootstrapper's.
+//Until the bandwidth is estimated, the bootstrapper's is kept
+func (this *Node) linkMetrics(addr netip.Addr) utils.Metrics {
+    ni := this.neighbours[addr]
+    m, ok := this.monitor.Measured(netip.AddrPortFrom(addr, ni.port))
+    if !ok {
+        return ni.metrics
+    }
+
+    if m.Bandwidth == 0 {
+        m.Bandwidth = ni.metrics.Bandwidth
+    }
+    return m
+}
+
+func (this *Node) isRP() bool {
+    return len(this.servers) != 0
+}
+
+//Probes all servers in parallel and waits for their responses until the probe timeout.
+//Returns the positive response of the server chosen by the selector, with the metrics of the path to it.
+//Must be called without the mutex locked, as it blocks for up to the probe timeout
+func (this *Node) probeServers(req packet.ProbeRequest) (packet.ProbeResponse, netip.Addr) {
+    ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+    defer cancel()
+
+    answers := make(chan serverCandidate, len(this.servers))
+    var wg sync.WaitGroup
+
+    for _, s := range this.servers {
+        wg.Add(1)
+        go func(s netip.AddrPort) {
+            defer wg.Done()
+
+            //the interceptor is registered before sending, so the response can't be missed
+            c := service.InterceptContext(ctx, &this.serv, func(sig service.Signal) bool {
+                msg, ok := sig.(service.TCPMessage)
+                if !ok { return false }
+
+                resp, ok := msg.Packet().(packet.ProbeResponse)
+                if !ok { return false }
+
+                return msg.Addr().Addr() == s.Addr() && resp.RequestID == req.RequestID
+            }, 1)
+
+            err := this.serv.TCPServer().SendConnect(req, s)
+            if err != nil {
+                slog.Warn("Unable to connect to server", "addr", s, "err", err)
+                return
+            }
+
+            sig, ok := <-c
+            if !ok {
+                slog.Warn("Server didn't answer probe in time", "addr", s)
+                this.exported.probeTimeouts.With("server").Inc()
+                return
+            }
+
+            resp := sig.(service.TCPMessage).Packet().(packet.ProbeResponse)
+            answers <- serverCandidate{addr: s, resp: resp, metrics: this.monitor.GetMetrics(s)}
+        }(s)
+    }
+
+    wg.Wait()
+    close(answers)
+
+    candidates := make([]serverCandidate, 0, len(this.servers))
+    for c := range answers {
+        candidates = append(candidates, c)
+    }
+
+    best, ok := this.selector.choose(req.StreamID, candidates)
+    if !ok {
+        return req.RespondNonExistant(), netip.IPv4Unspecified()
+    }
+
+    best.resp.Metrics = best.metrics
+    return best.resp, best.addr.Addr()
+}
+
+func (this *Node) fitsAditional(bitrate int, addr netip.Addr) bool { //TODO: also look at waitingStreams?
+    return this.runningStreams.connUsage(addr) + bitrate < this.linkMetrics(addr).Bandwidth
+}
+
+
+func (this *Node) propagateProbeRequest(req packet.ProbeRequest, ignore ...netip.Addr) {
+    for addr, ni := range this.neighbours {
+        if !utils.Contains(ignore, addr) {
+            utils.Warn(this.serv.TCPServer().SendConnect(req, netip.AddrPortFrom(addr, ni.port)))
+        }
+    }
+}
+
+func (this *Node) propagateProbeResponse(resp packet.ProbeResponse, ignore ...netip.Addr) {
+    for addr, ni := range this.neighbours {
+        if !utils.Contains(ignore, addr) && this.fitsAditional(resp.Stream.Bitrate, addr) {
+            utils.Warn(this.serv.TCPServer().SendConnect(resp, netip.AddrPortFrom(addr, ni.port)))
+        }
+    }
+}
+
+func (this *Node) cancelStream(streamID string, addr netip.Addr, port uint16) {
+    if waitingStream, ok := this.waitingStreams[streamID]; ok {
+        waitingStream.to.Remove(netip.AddrPortFrom(addr, port))
+        if waitingStream.to.Length() == 0 {
+            //fmt.Println("Canceling waiting stream")
+            if waitingStream.localPort != 0 {
+                this.serv.RemoveUDPServer(waitingStream.localPort)
+            }
+            delete(this.waitingStreams, streamID)
+        }
+    }
+    
+    if this.runningStreams.removeSubscriber(streamID, addr, port) {
+        //fmt.Println("Canceling running stream (sending StreamCancel)")
+        addr, localPort := this.runningStreams.endSubscription(streamID)
+        this.serv.RemoveUDPServer(localPort)
+        p := packet.StreamCancel{StreamID: streamID, Port: localPort}
+        utils.Warn(this.serv.TCPServer().Send(p, addr))
+    }
+}
+
+//If the requestID is already in use, the request is ignored
+//If there is a running stream, a response is deduced and handled
+//Otherwise, the request is propagated to both neighbours and servers. The servers' response is then handled
+func (this *Node) handleProbeRequest(req packet.ProbeRequest, source netip.Addr) {
+    //fmt.Println("Processing probe request")
+    
+    if this.probeRequests.Contains(req.RequestID) {
+        this.exported.probes.With("duplicate").Inc()
+        return
+    }
+
+    this.probeRequests.Add(req.RequestID)
+    this.exported.probes.With("handled").Inc()
+
+    if stream, ok := this.runningStreams[req.StreamID]; ok {
+        resp := req.RespondExistant(stream.metadata)
+        resp.Metrics = stream.metrics
+        resp.Path = stream.path
+        this.handleProbeResponse(resp, stream.from)
+    } else {
+        this.propagateProbeRequest(req, source)
+
+        //the servers are probed without the mutex, so the streams keep flowing while waiting for their answers
+        go func() {
+            resp, s := this.probeServers(req)
+
+            this.mutex.Lock()
+            defer this.mutex.Unlock()
+            if resp.Exists || this.isRP() {
+                this.handleProbeResponse(resp, s)
+            }
+        }()
+    }
+}
+
+//If a response is already registered for this requestID, this response is ignored.
+//Otherwise it's collected, and the first response of a probe opens a window for the others.
+//The metrics of the response must be the ones of the path from this node
+func (this *Node) handleProbeResponse(resp packet.ProbeResponse, source netip.Addr) {
+    //fmt.Println("Processing probe response")
+    
+    this.probeRequests.Add(resp.RequestID)
+
+    if _, ok := this.probeResponses[resp.RequestID]; ok {
+        return
+    }
+
+    candidates, collecting := this.probeCandidates[resp.RequestID]
+    this.probeCandidates[resp.RequestID] = append(candidates, probeCandidate{resp: resp, from: source})
+
+    if !collecting {
+        go func(requestID uint32) {
+            time.Sleep(probeWindow)
+            this.mutex.Lock()
+            defer this.mutex.Unlock()
+            this.decideProbe(requestID)
+        }(resp.RequestID)
+    }
+}
+
+//Closes the window of a probe: the best response (positive over negative, then by health and metrics) is stored and progagated.
+//Then, if there is a correspondent waiting stream, a StreamRequest is sent to this response's address
+func (this *Node) decideProbe(requestID uint32) {
+    candidates := this.probeCandidates[requestID]
+    delete(this.probeCandidates, requestID)
+    if len(candidates) == 0 {
+        return
+    }
+
+    best := candidates[0]
+    for _, c := range candidates[1:] {
+        if c.resp.Exists && (!best.resp.Exists || c.resp.Health < best.resp.Health ||
+            (c.resp.Health == best.resp.Health && !best.resp.Metrics.BetterThan(c.resp.Metrics))) {
+            best = c
+        }
+    }
+    resp, source := best.resp, best.from
+
+    if resp.Exists {
+        this.probeResponses[resp.RequestID] = probeResponse{from: source, stream: &resp.Stream, metrics: resp.Metrics, path: resp.Path}
+    } else {
+        this.probeResponses[resp.RequestID] = probeResponse{from: source, stream: nil}
+    }
+    resp.Path = append(slices.Clone(resp.Path), this.id)
+    
+    this.propagateProbeResponse(resp, source)
+
+    //fmt.Println("waitingStreams:", this.waitingStreams[resp.StreamID])
+
+    if waitingStream, ok := this.waitingStreams[resp.StreamID]; ok {
+        //fmt.Println("ProbeResponse reaction")
+
+        if !resp.Exists { //we don't want to start a probe request if the stream doesn't exist
+            for addrport := range waitingStream.to {
+                utils.Warn(this.serv.TCPServer().Send(packet.StreamEnd{StreamID: resp.StreamID}, addrport.Addr()))
+            }
+            delete(this.waitingStreams, resp.StreamID)
+        } else {
+            //receive "ghost" StreamRequest from all subscribers to the stream to propagate it upwards
+            this.handleStreamRequest(resp.StreamID, resp.RequestID, waitingStream.to.ToSlice()...)
+        }
+    }
+}
+
+
+func (this *Node) handleStreamRequest(streamID string, requestID uint32, dests ...netip.AddrPort) {    
+    //fmt.Println("Processing stream request")
+    
+    if len(dests) == 0 {
+        slog.Warn("handleStreamRequest: called with no dests")
+        return
+    }
+    
+    if s, ok := this.runningStreams[streamID]; ok {
+        for _, addrport := range dests {
+            s.to.Add(addrport)
+            utils.Warn(this.serv.TCPServer().Send(packet.StreamResponse{SDP: s.sdp,StreamID:streamID,RequestID:requestID}, addrport.Addr()))
+        }
+
+        //the sole viewer paused the stream, which the new subscribers couldn't resume
+        if s.paused && s.to.Length() > 1 {
+            s.paused = false
+            go this.resumeShared(streamID, this.upstreamAddr(s))
+        }
+    } else if resp, ok := this.probeResponses[requestID]; ok {
+        if resp.stream == nil {
+            for _, addrport := range dests {
+                utils.Warn(this.serv.TCPServer().Send(packet.StreamEnd{StreamID: streamID}, addrport.Addr()))
+            }
+        } else {
+            //fmt.Println("Add addrport to waitingStreams")
+
+            if _, ok := this.waitingStreams[streamID]; !ok {
+                this.waitingStreams[streamID] = &waitingStream{to: utils.EmptySet[netip.AddrPort](), localPort: 0}
+            }
+
+            for _, addrport := range dests {
+                this.waitingStreams[streamID].to.Add(addrport)
+            }
+
+            //the stream was already requested from the upstream of another probe: the dests join that subscription
+            if w := this.waitingStreams[streamID]; w.requestID != 0 && w.requestID != requestID {
+                return
+            }
+
+            if this.waitingStreams[streamID].localPort == 0 {
+                //fmt.Println("Open a new port")
+
+                var port uint16
+                this.serv.AddUDPServer(&port)
+                this.waitingStreams[streamID].localPort = port
+            }
+
+            //fmt.Println("Open a new port and send StreamRequest")
+
+            p := packet.StreamRequest{StreamID: streamID, RequestID: requestID, Port: this.waitingStreams[streamID].localPort}
+            err := this.serv.TCPServer().Send(p, resp.from)
+            if err != nil {
+                slog.Error("Unable to propagate StreamRequest", "err", err)
+                return
+            }
+            this.waitingStreams[streamID].requestID = requestID
+        }
+    } else if !this.probeRequests.Contains(requestID) {
+        //fmt.Println("Add dests to waitingStreams and send probeRequest")
+        for _, addrPort := range dests {
+            if _, ok := this.waitingStreams[streamID]; !ok {
+                this.waitingStreams[streamID] = &waitingStream{to: utils.EmptySet[netip.AddrPort]()}
+            }
+            
+            this.waitingStreams[streamID].to.Add(addrPort)
+        }
+        
+        req := packet.ProbeRequest{StreamID: streamID, RequestID: requestID}
+        this.handleProbeRequest(req, netip.IPv4Unspecified())
+
+        timeout := time.After(streamProbeTimeout)
+        go func() {
+            <-timeout
+            this.mutex.Lock()
+            defer this.mutex.Unlock()
+
+            if _, ok := this.probeResponses[requestID]; !ok {
+                this.exported.probeTimeouts.With("stream").Inc()
+                for _, addrport := range dests {
+                    this.cancelStream(streamID, addrport.Addr(), addrport.Port())
+                    utils.Warn(this.serv.TCPServer().Send(packet.StreamEnd{StreamID: streamID}, addrport.Addr()))
+                }
+            }
+        }()
+    }
+}
+
+
+func (this *Node) Handle(sig service.Signal) bool {
+    //pings are answered right away, without waiting for the node's state
+    if msg, ok := sig.(service.UDPMessage); ok {
+        if ping, ok := msg.Packet().(packet.Ping); ok {
+            utils.Warn(msg.SendResponse(ping))
+            return true
+        }
+    }
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    switch sig.(type) {
+    case service.Init:
+        request := packet.StartupRequest{Service: utils.Node}
+        ctx, cancel := context.WithTimeout(context.Background(), bootTimeout)
+        response, err := service.InterceptTCPResponseContext[packet.StartupResponseNode](ctx, &this.serv, request, this.bootAddr)
+        cancel()
+        if err != nil {
+            slog.Error("Error on Init:", "err", err)
+            this.serv.Close()
+            return true
+        }
+
+        utils.Warn(this.serv.TCPServer().CloseConn(this.bootAddr.Addr()))
+
+        this.neighbours = make(map[netip.Addr]neighbourInfo)
+        neighbours := make([]netip.AddrPort, 0, len(response.Neighbours))
+        for n, m := range response.Neighbours {
+            neighbours = append(neighbours, n)
+            this.neighbours[n.Addr()] = neighbourInfo{port: n.Port(), metrics: m}
+
+            err := this.serv.TCPServer().Connect(n)
+            if err != nil {
+                slog.Warn("Unable to connect to neighbour node", "err", err)
+            }
+        }
+
+        this.servers = response.Servers
+        this.monitor = this.monitorMetrics(this.servers, neighbours)
+        go this.watchUpstreams()
+        return true
+
+    case service.Closing:
+        if this.monitor != nil {
+            this.monitor.Stop()
+        }
+        close(this.stop)
+        return true
+
+    case service.TCPDisconnected:
+        disc := sig.(service.TCPDisconnected)
+        sources, dests := this.runningStreams.eraseAddr(disc.Addr().Addr())
+
+        //cancel unused stream
+        for streamID, port := range dests {
+            //fmt.Println("Canceling unused stream")
+
+            addr, _ := this.runningStreams.endSubscription(streamID)
+            p := packet.StreamCancel{StreamID: streamID, Port: port}
+            utils.Warn(this.serv.TCPServer().Send(p, addr))
+        }
+
+        //re-request unavailable streams
+        for streamID, waiting := range sources {
+            //fmt.Println("Re-request unavailable stream")
+
+            w := waiting
+            this.waitingStreams[streamID] = &w
+            randInt := utils.RandID()
+            //we use goroutines in order to run all requests in parallel
+            go func(streamID string, dests []netip.AddrPort) {
+                this.mutex.Lock()
+                defer this.mutex.Unlock()
+                this.handleStreamRequest(streamID, randInt, dests...)
+            }(streamID, w.to.ToSlice())
+        }
+
+        return true
+
+    case service.TCPMessage:
+        msg := sig.(service.TCPMessage)
+        this.exported.controlPacket(msg.Packet())
+        switch msg.Packet().(type) {
+
+        case packet.ProbeRequest:
+            req := msg.Packet().(packet.ProbeRequest)
+            if !req.Local {
+                this.handleProbeRequest(req, msg.Addr().Addr())
+                return true
+            }
+
+            this.exported.probes.With("local").Inc()
+            if stream, ok := this.runningStreams[req.StreamID]; ok {
+                resp := req.RespondExistant(stream.metadata)
+                resp.Metrics = stream.metrics
+                resp.Path = append(slices.Clone(stream.path), this.id)
+                utils.Warn(msg.SendResponse(resp))
+            }
+            return true
+
+        case packet.ProbeResponse:
+            resp := msg.Packet().(packet.ProbeResponse)
+            if _, ok := this.neighbours[msg.Addr().Addr()]; ok {
+                resp.Metrics = this.linkMetrics(msg.Addr().Addr()).Compose(resp.Metrics)
+            }
+            this.handleProbeResponse(resp, msg.Addr().Addr())
+            return true
+
+        case packet.StreamRequest:
+            p := msg.Packet().(packet.StreamRequest)
+            this.handleStreamRequest(p.StreamID, p.RequestID, netip.AddrPortFrom(msg.Addr().Addr(), p.Port))
+            return true
+
+        case packet.StreamResponse:
+            p := msg.Packet().(packet.StreamResponse)
+
+            //fmt.Println("Processing StreamResponse", p)
+
+            if sw, ok := this.switching[p.StreamID]; ok && sw.requestID == p.RequestID {
+                return true //the switch completes when the packets arrive
+            }
+
+            if resp, ok := this.probeResponses[p.RequestID]; ok {
+                if resp.stream == nil {
+                    slog.Warn("Received StreamResponse for non-existant stream", "streamID", p.StreamID, "requestID", p.RequestID)
+                } else if w, ok := this.waitingStreams[p.StreamID]; ok && w.localPort != 0 {
+                    //fmt.Println("Adding stream to runningStreams and removing from waitingStreams", w)
+                    this.runningStreams.startSubscription(p.StreamID, resp, w.localPort, p.SDP, w.to.ToSlice())
+                    for addrport := range w.to {
+                        utils.Warn(this.serv.TCPServer().Send(p, addrport.Addr()))
+                    }
+                    delete(this.waitingStreams, p.StreamID)
+
+                    //fmt.Println("runningStreams:", this.runningStreams)
+                    //fmt.Println("waitingStreams:", this.waitingStreams)
+                }
+            }
+
+            return true
+
+        case packet.CatalogRequest:
+            //the peers' answers are waited for without holding the node's state
+            go this.handleCatalog(msg, msg.Packet().(packet.CatalogRequest))
+            return true
+
+        case packet.TraceRequest:
+            //the upstream's answer is waited for without holding the node's state
+            go this.handleTrace(msg, msg.Packet().(packet.TraceRequest))
+            return true
+
+        case packet.PlaybackRequest:
+            //the upstream's answer is waited for without holding the node's state
+            go this.handlePlayback(msg, msg.Packet().(packet.PlaybackRequest))
+            return true
+
+        case packet.Nack:
+            this.retransmit(msg.Packet().(packet.Nack), msg.Addr().Addr())
+            return true
+
+        case packet.StreamCancel:
+            p := msg.Packet().(packet.StreamCancel)
+            this.cancelStream(p.StreamID, msg.Addr().Addr(), p.Port)
+            return true
+
+        case packet.StreamEnd:
+            p := msg.Packet().(packet.StreamEnd)
+
+            if w, ok := this.waitingStreams[p.StreamID]; ok && w.localPort != 0 { //the upstream refused the StreamRequest
+                for addr := range w.to {
+                    utils.Warn(this.serv.TCPServer().Send(p, addr.Addr()))
+                }
+                this.serv.RemoveUDPServer(w.localPort)
+                delete(this.waitingStreams, p.StreamID)
+                return true
+            }
+
+            s, ok := this.runningStreams[p.StreamID]
+            if !ok || s.from != msg.Addr().Addr() { //discard
+                return true
+            }
+
+            //propagate StreamEnd
+            for addr := range s.to {
+                utils.Warn(this.serv.TCPServer().Send(p, addr.Addr()))
+            }
+
+            //locally remove the subscription
+            _, port := this.runningStreams.endSubscription(p.StreamID)
+            this.serv.RemoveUDPServer(port)
+
+            return true
+        }
+
+    case service.UDPMessage:
+        msg := sig.(service.UDPMessage)
+        switch msg.Packet().(type) {
+
+        case packet.StreamPacket:
+            p := msg.Packet().(packet.StreamPacket)
+            this.monitor.Observe(msg.Addr().Addr(), len(p.Content))
+            this.completeSwitch(msg.LocalPort())
+
+            if streamID := this.runningStreams.byLocalPort(msg.LocalPort()); streamID != "" {
+                this.forward(streamID, p)
+            }
+            return true
+
+        case packet.StreamRepair:
+            p := msg.Packet().(packet.StreamRepair)
+            this.monitor.Observe(msg.Addr().Addr(), len(p.Content))
+
+            if streamID := this.runningStreams.byLocalPort(msg.LocalPort()); streamID != "" {
+                this.repair(streamID, p)
+            }
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,112 @@
+package node
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/netip"
+    "time"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/service"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//Time each hop keeps for itself when forwarding a playback request: the upstream gets the rest of the timeout
+const playbackMargin = 100 * time.Millisecond
+const resumeTimeout = 3 * time.Second
+
+//Forwards a playback request upstream if it came from the stream's only subscriber (any subscriber, for Status),
+//and relays the answer, remembering whether the stream was left paused
+func (this *Node) handlePlayback(msg service.TCPMessage, req packet.PlaybackRequest) {
+    upstream, refusal := this.playbackUpstream(req, msg.Addr().Addr())
+    if refusal != "" {
+        utils.Warn(msg.SendResponse(req.Refuse("%s", refusal)))
+        return
+    }
+
+    forward := req
+    forward.Timeout = req.Timeout - 2 * playbackMargin
+    if forward.Timeout <= 0 {
+        utils.Warn(msg.SendResponse(req.Refuse("playback request timed out")))
+        return
+    }
+
+    resp, err := this.requestPlayback(forward, upstream, req.Timeout - playbackMargin)
+    if err != nil {
+        utils.Warn(msg.SendResponse(req.Refuse("%s", err)))
+        return
+    }
+
+    if resp.Error == "" {
+        this.mutex.Lock()
+        if s, ok := this.runningStreams[req.StreamID]; ok {
+            s.paused = resp.Paused
+        }
+        this.mutex.Unlock()
+    }
+    utils.Warn(msg.SendResponse(resp))
+}
+
+//Sends a playback request upstream and waits for the answer until the timeout
+func (this *Node) requestPlayback(req packet.PlaybackRequest, upstream netip.AddrPort, timeout time.Duration) (packet.PlaybackResponse, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    //the interceptor is registered before sending, so the response can't be missed
+    answer := service.InterceptContext(ctx, &this.serv, func(sig service.Signal) bool {
+        m, ok := sig.(service.TCPMessage)
+        if !ok { return false }
+
+        resp, ok := m.Packet().(packet.PlaybackResponse)
+        return ok && m.Addr().Addr() == upstream.Addr() && resp.RequestID == req.RequestID
+    }, 1)
+
+    err := this.serv.TCPServer().SendConnect(req, upstream)
+    if err != nil {
+        return packet.PlaybackResponse{}, fmt.Errorf("unable to reach the upstream: %w", err)
+    }
+
+    sig, ok := <-answer
+    if !ok {
+        return packet.PlaybackResponse{}, errors.New("the upstream didn't answer in time")
+    }
+    return sig.(service.TCPMessage).Packet().(packet.PlaybackResponse), nil
+}
+
+//Resumes a stream its sole viewer paused, once it gains another subscriber: the new viewers would
+//otherwise wait on a stream none of them can resume
+func (this *Node) resumeShared(streamID string, upstream netip.AddrPort) {
+    req := packet.PlaybackRequest{StreamID: streamID, RequestID: utils.RandID(), Action: packet.Resume, Timeout: resumeTimeout}
+    resp, err := this.requestPlayback(req, upstream, resumeTimeout)
+    if err == nil && resp.Error != "" {
+        err = errors.New(resp.Error)
+    }
+    if err != nil {
+        slog.Warn("Unable to resume a paused stream for its new subscribers", "streamID", streamID, "err", err)
+    }
+}
+
+//Returns the upstream a playback request from the given address is forwarded to, or why it's refused
+func (this *Node) playbackUpstream(req packet.PlaybackRequest, from netip.Addr) (netip.AddrPort, string) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    s, ok := this.runningStreams[req.StreamID]
+    if !ok {
+        return netip.AddrPort{}, "the stream isn't running"
+    }
+
+    subscribed := false
+    for sub := range s.to {
+        subscribed = subscribed || sub.Addr() == from
+    }
+
+    if !subscribed {
+        return netip.AddrPort{}, "not subscribed to the stream"
+    } else if req.Action != packet.Status && s.to.Length() != 1 {
+        return netip.AddrPort{}, "the stream is shared with other viewers"
+    }
+    return this.upstreamAddr(s), ""
+}
@@ -0,0 +1,92 @@
+package node
+
+import (
+    "net/netip"
+    "testing"
+    "time"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+var (
+    neighbourA = netip.MustParseAddr("10.0.1.1")
+    neighbourB = netip.MustParseAddr("10.0.1.2")
+)
+
+//A response to a probe from the given neighbour, whether it has the stream, with the health of its source and the latency of its path
+func probeFrom(from netip.Addr, exists bool, health packet.StreamHealth, latency time.Duration) probeCandidate {
+    resp := packet.ProbeResponse{StreamID: "movie", RequestID: 1, Exists: exists, Health: health, Metrics: utils.Metrics{Latency: latency}}
+    return probeCandidate{resp: resp, from: from}
+}
+
+//A response that exists wins, then the healthier source, then the better path
+func TestDecideProbe(t *testing.T) {
+    tests := []struct {
+        name string
+        candidates []probeCandidate
+        want netip.Addr
+        exists bool
+    }{
+        {
+            name: "none has the stream",
+            candidates: []probeCandidate{probeFrom(neighbourA, false, packet.Healthy, 0), probeFrom(neighbourB, false, packet.Healthy, 0)},
+            want: neighbourA,
+        },
+        {
+            name: "a response that exists beats a better one that doesn't",
+            candidates: []probeCandidate{probeFrom(neighbourA, false, packet.Healthy, time.Millisecond), probeFrom(neighbourB, true, packet.Degraded, time.Second)},
+            want: neighbourB,
+            exists: true,
+        },
+        {
+            name: "a missing response never replaces one that exists",
+            candidates: []probeCandidate{probeFrom(neighbourA, true, packet.Degraded, time.Second), probeFrom(neighbourB, false, packet.Healthy, time.Millisecond)},
+            want: neighbourA,
+            exists: true,
+        },
+        {
+            name: "a healthy source beats a better path",
+            candidates: []probeCandidate{probeFrom(neighbourA, true, packet.Degraded, 10 * time.Millisecond), probeFrom(neighbourB, true, packet.Healthy, 80 * time.Millisecond)},
+            want: neighbourB,
+            exists: true,
+        },
+        {
+            name: "a better path doesn't make up for a worse health",
+            candidates: []probeCandidate{probeFrom(neighbourA, true, packet.Healthy, 80 * time.Millisecond), probeFrom(neighbourB, true, packet.Degraded, 10 * time.Millisecond)},
+            want: neighbourA,
+            exists: true,
+        },
+        {
+            name: "equally healthy sources are decided by the path",
+            candidates: []probeCandidate{probeFrom(neighbourA, true, packet.Healthy, 80 * time.Millisecond), probeFrom(neighbourB, true, packet.Healthy, 10 * time.Millisecond)},
+            want: neighbourB,
+            exists: true,
+        },
+        {
+            name: "equal paths keep the first response",
+            candidates: []probeCandidate{probeFrom(neighbourA, true, packet.Healthy, 10 * time.Millisecond), probeFrom(neighbourB, true, packet.Healthy, 10 * time.Millisecond)},
+            want: neighbourA,
+            exists: true,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            n := New(netip.MustParseAddrPort("10.0.0.254:6321"))
+            n.probeCandidates[1] = test.candidates
+            n.decideProbe(1)
+
+            got, ok := n.probeResponses[1]
+            if !ok {
+                t.Fatal("no response stored")
+            }
+            if got.from != test.want || (got.stream != nil) != test.exists {
+                t.Errorf("stored the response of %s (exists %v), want %s (exists %v)", got.from, got.stream != nil, test.want, test.exists)
+            }
+            if _, ok := n.probeCandidates[1]; ok {
+                t.Error("the candidates are kept once decided")
+            }
+        })
+    }
+}
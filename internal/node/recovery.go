@@ -0,0 +1,51 @@
+package node
+
+import (
+    "net/netip"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//Registers a packet of the running stream received from the upstream, which is asked for the packets found missing.
+//Returns false if the packet was already received, so it isn't forwarded again. Must be called with the mutex locked
+func (this *Node) receive(streamID string, p packet.StreamPacket) bool {
+    s := this.runningStreams[streamID]
+    r := s.received.Receive(p.Seq)
+    shared := packet.SharedStreamID(streamID)
+
+    if r.Lost != 0 {
+        this.exported.lost.With(shared).Add(float64(r.Lost))
+    }
+    if !r.Fresh {
+        this.exported.duplicates.With(shared).Inc()
+        return false
+    }
+    if r.Recovered {
+        this.exported.recovered.With(shared).Inc()
+    }
+
+    s.sent.Add(p)
+    if len(r.Nack) != 0 {
+        this.exported.nacked.With(shared).Add(float64(len(r.Nack)))
+        utils.Warn(this.serv.TCPServer().Send(packet.Nack{StreamID: streamID, Port: s.toLocal, Missing: r.Nack}, s.from))
+    }
+    return true
+}
+
+//Sends again to a subscriber the packets it lost, among the ones still kept.
+//The ones this node lost too reach it once recovered from the upstream. Must be called with the mutex locked
+func (this *Node) retransmit(p packet.Nack, from netip.Addr) {
+    s, ok := this.runningStreams[p.StreamID]
+    to := netip.AddrPortFrom(from, p.Port)
+    if !ok || !s.to.Contains(to) {
+        return
+    }
+
+    for _, seq := range p.Missing {
+        if sp, ok := s.sent.Get(seq); ok {
+            utils.Warn(this.serv.SendUDP(sp, to))
+            this.exported.retransmitted.With(packet.SharedStreamID(p.StreamID)).Inc()
+        }
+    }
+}
@@ -0,0 +1,148 @@
+package node
+
+import (
+    "cmp"
+    "math"
+    "net/netip"
+    "slices"
+    "sync"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//Decides between servers whose paths have about the same score
+type SelectionPolicy int
+
+const (
+    LeastLoaded SelectionPolicy = iota     //the server with the fewest subscribers
+    LowestLatency                           //the server with the lowest measured latency
+    Sticky                                  //the server chosen last time for the stream, otherwise the least loaded
+)
+
+var policyNames = map[string]SelectionPolicy{
+    "least-loaded": LeastLoaded,
+    "lowest-latency": LowestLatency,
+    "sticky": Sticky,
+}
+
+//Returns the policy with the given name (least-loaded, lowest-latency or sticky)
+func PolicyByName(name string) (SelectionPolicy, bool) {
+    p, ok := policyNames[name]
+    return p, ok
+}
+
+//The answer of a server to a probe, with the measured metrics of the path to it
+type serverCandidate struct {
+    addr netip.AddrPort
+    resp packet.ProbeResponse
+    metrics utils.Metrics
+}
+
+//Whether the path can carry the stream. An unknown bandwidth is assumed to
+func (this serverCandidate) fits() bool {
+    return this.metrics.Bandwidth == 0 || this.resp.Stream.Bitrate <= this.metrics.Bandwidth
+}
+
+//Relative difference below which two scores are considered the same, as the measurements are noisy
+const scoreTolerance = 0.05
+
+//How much worse each subscriber of a server makes the path to it look
+const loadPenalty = 0.1
+
+//Cost of the metrics of the path, inflated by the load of the server: the lower, the better
+func (this serverCandidate) score() float64 {
+    cost := this.metrics.Cost()
+    inflation := 1 + loadPenalty * float64(max(this.resp.Load, 0))
+    if cost < 0 { //the better paths have the more negative costs (e.g. utils.BandwidthCost)
+        return cost / inflation
+    }
+    return cost * inflation
+}
+
+func similar(a float64, b float64) bool {
+    return math.Abs(a - b) <= scoreTolerance * max(math.Abs(a), math.Abs(b))
+}
+
+//Chooses the server a stream is requested from. Servers that have the stream are ranked:
+//first by the health of their source, then the ones whose path fits the stream's bitrate, then by their score.
+//The servers whose score is within the tolerance of the best one are decided by the policy
+//(and then by address, so the choice is deterministic)
+type serverSelector struct {
+    mutex sync.Mutex
+    policy SelectionPolicy
+    last map[string]netip.AddrPort     //server chosen last time for each stream
+}
+
+func newServerSelector(policy SelectionPolicy) *serverSelector {
+    return &serverSelector{policy: policy, last: make(map[string]netip.AddrPort)}
+}
+
+//Returns whether the policy prefers a over b, which have about the same score
+func (this *serverSelector) prefer(streamID string, a serverCandidate, b serverCandidate) bool {
+    switch this.policy {
+    case Sticky:
+        if last, ok := this.last[streamID]; ok && (a.addr == last) != (b.addr == last) {
+            return a.addr == last
+        }
+        fallthrough
+
+    case LeastLoaded:
+        if a.resp.Load != b.resp.Load {
+            return a.resp.Load < b.resp.Load
+        }
+
+    case LowestLatency:
+        if a.metrics.Latency != b.metrics.Latency {
+            return a.metrics.Latency < b.metrics.Latency
+        }
+    }
+
+    if a.addr.Addr() != b.addr.Addr() {
+        return a.addr.Addr().Less(b.addr.Addr())
+    }
+    return a.addr.Port() < b.addr.Port()
+}
+
+//Returns the best server that has the stream, or false if none has it.
+//Servers whose source of the stream failed are left out
+func (this *serverSelector) choose(streamID string, candidates []serverCandidate) (serverCandidate, bool) {
+    existing := make([]serverCandidate, 0, len(candidates))
+    for _, c := range candidates {
+        if c.resp.Exists && c.resp.Health != packet.Failed {
+            existing = append(existing, c)
+        }
+    }
+
+    if len(existing) == 0 {
+        return serverCandidate{}, false
+    }
+
+    healthiest := slices.MinFunc(existing, func(a serverCandidate, b serverCandidate) int {
+        return cmp.Compare(a.resp.Health, b.resp.Health)
+    }).resp.Health
+    existing = slices.DeleteFunc(existing, func(c serverCandidate) bool { return c.resp.Health != healthiest })
+
+    if slices.ContainsFunc(existing, serverCandidate.fits) {
+        existing = slices.DeleteFunc(existing, func(c serverCandidate) bool { return !c.fits() })
+    }
+
+    best := math.Inf(1)
+    for _, c := range existing {
+        best = min(best, c.score())
+    }
+    existing = slices.DeleteFunc(existing, func(c serverCandidate) bool { return !similar(c.score(), best) })
+
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    ans := existing[0]
+    for _, c := range existing[1:] {
+        if this.prefer(streamID, c, ans) {
+            ans = c
+        }
+    }
+
+    this.last[streamID] = ans.addr
+    return ans, true
+}
@@ -0,0 +1,195 @@
+package node
+
+import (
+    "net/netip"
+    "testing"
+    "time"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+var (
+    serverA = netip.MustParseAddrPort("10.0.0.1:6321")
+    serverB = netip.MustParseAddrPort("10.0.0.2:6321")
+    serverC = netip.MustParseAddrPort("10.0.0.3:6321")
+)
+
+//A server that has the stream, at the given latency, with the given number of subscribers
+func candidate(addr netip.AddrPort, latency time.Duration, load int) serverCandidate {
+    return serverCandidate{
+        addr: addr,
+        resp: packet.ProbeResponse{StreamID: "movie", Exists: true, Load: load, Stream: utils.StreamMetadata{Bitrate: 1000000}},
+        metrics: utils.Metrics{Latency: latency},
+    }
+}
+
+func withHealth(c serverCandidate, health packet.StreamHealth) serverCandidate {
+    c.resp.Health = health
+    return c
+}
+
+func withBandwidth(c serverCandidate, bandwidth int) serverCandidate {
+    c.metrics.Bandwidth = bandwidth
+    return c
+}
+
+func TestChoose(t *testing.T) {
+    missing := candidate(serverC, time.Millisecond, 0)
+    missing.resp.Exists = false
+
+    tests := []struct {
+        name string
+        policy SelectionPolicy
+        last netip.AddrPort     //server chosen before for the stream, if valid
+        candidates []serverCandidate
+        want netip.AddrPort     //invalid if no server should be chosen
+    }{
+        {
+            name: "no server has the stream",
+            candidates: []serverCandidate{missing},
+        },
+        {
+            name: "failed sources are left out",
+            candidates: []serverCandidate{withHealth(candidate(serverA, 10 * time.Millisecond, 0), packet.Failed)},
+        },
+        {
+            name: "servers without the stream are left out",
+            candidates: []serverCandidate{missing, candidate(serverB, 50 * time.Millisecond, 3)},
+            want: serverB,
+        },
+        {
+            name: "a healthy source beats a better path",
+            candidates: []serverCandidate{
+                withHealth(candidate(serverA, 10 * time.Millisecond, 0), packet.Degraded),
+                candidate(serverB, 80 * time.Millisecond, 0),
+            },
+            want: serverB,
+        },
+        {
+            name: "a path that fits the bitrate beats a better one",
+            candidates: []serverCandidate{
+                withBandwidth(candidate(serverA, 10 * time.Millisecond, 0), 500000),
+                withBandwidth(candidate(serverB, 80 * time.Millisecond, 0), 2000000),
+            },
+            want: serverB,
+        },
+        {
+            name: "an unknown bandwidth is assumed to fit",
+            candidates: []serverCandidate{
+                withBandwidth(candidate(serverA, 80 * time.Millisecond, 0), 2000000),
+                candidate(serverB, 10 * time.Millisecond, 0),
+            },
+            want: serverB,
+        },
+        {
+            name: "a clearly cheaper path wins whatever the policy",
+            policy: LeastLoaded,
+            candidates: []serverCandidate{candidate(serverA, 20 * time.Millisecond, 2), candidate(serverB, 50 * time.Millisecond, 0)},
+            want: serverA,
+        },
+        {
+            name: "the load makes a path look worse",
+            policy: LowestLatency,
+            candidates: []serverCandidate{candidate(serverA, 100 * time.Millisecond, 5), candidate(serverB, 120 * time.Millisecond, 0)},
+            want: serverB,
+        },
+        {
+            name: "similar scores are decided by the load",
+            policy: LeastLoaded,
+            candidates: []serverCandidate{candidate(serverA, 100 * time.Millisecond, 1), candidate(serverB, 108 * time.Millisecond, 0)},
+            want: serverB,
+        },
+        {
+            name: "similar scores are decided by the latency",
+            policy: LowestLatency,
+            candidates: []serverCandidate{candidate(serverA, 100 * time.Millisecond, 1), candidate(serverB, 108 * time.Millisecond, 0)},
+            want: serverA,
+        },
+        {
+            name: "sticky keeps the last server among similar ones",
+            policy: Sticky,
+            last: serverA,
+            candidates: []serverCandidate{candidate(serverA, 100 * time.Millisecond, 1), candidate(serverB, 108 * time.Millisecond, 0)},
+            want: serverA,
+        },
+        {
+            name: "sticky leaves the last server once clearly worse",
+            policy: Sticky,
+            last: serverA,
+            candidates: []serverCandidate{candidate(serverA, 100 * time.Millisecond, 1), candidate(serverB, 50 * time.Millisecond, 0)},
+            want: serverB,
+        },
+        {
+            name: "sticky without a last server is least loaded",
+            policy: Sticky,
+            candidates: []serverCandidate{candidate(serverA, 100 * time.Millisecond, 1), candidate(serverB, 108 * time.Millisecond, 0)},
+            want: serverB,
+        },
+        {
+            name: "equal servers are decided by address",
+            candidates: []serverCandidate{
+                candidate(serverC, 40 * time.Millisecond, 0),
+                candidate(serverA, 40 * time.Millisecond, 0),
+                candidate(serverB, 40 * time.Millisecond, 0),
+            },
+            want: serverA,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            selector := newServerSelector(test.policy)
+            if test.last.IsValid() {
+                selector.last["movie"] = test.last
+            }
+
+            got, ok := selector.choose("movie", test.candidates)
+            if !test.want.IsValid() {
+                if ok {
+                    t.Errorf("chose %s, want none", got.addr)
+                }
+            } else if !ok || got.addr != test.want {
+                t.Errorf("chose %s (%v), want %s", got.addr, ok, test.want)
+            }
+        })
+    }
+}
+
+//The choice doesn't depend on the order the servers answered in
+func TestChooseOrder(t *testing.T) {
+    candidates := []serverCandidate{
+        candidate(serverA, 100 * time.Millisecond, 1),
+        candidate(serverB, 104 * time.Millisecond, 0),
+        candidate(serverC, 109 * time.Millisecond, 0),
+    }
+    want, _ := newServerSelector(LeastLoaded).choose("movie", candidates)
+
+    for i := range candidates {
+        rotated := append(append([]serverCandidate{}, candidates[i:]...), candidates[:i]...)
+        if got, _ := newServerSelector(LeastLoaded).choose("movie", rotated); got.addr != want.addr {
+            t.Errorf("chose %s with the servers in order %v, and %s before", got.addr, rotated, want.addr)
+        }
+    }
+}
+
+//With the bandwidth cost, the wider path is the better one even as the costs are negative
+func TestChooseBandwidthCost(t *testing.T) {
+    utils.SetCostFunction(utils.BandwidthCost)
+    t.Cleanup(func() { utils.SetCostFunction(utils.DefaultCost) })
+
+    candidates := []serverCandidate{
+        withBandwidth(candidate(serverA, 0, 0), 10000000),
+        withBandwidth(candidate(serverB, 0, 0), 50000000),
+    }
+    if got, _ := newServerSelector(LeastLoaded).choose("movie", candidates); got.addr != serverB {
+        t.Errorf("chose %s, want the wider path to %s", got.addr, serverB)
+    }
+
+    //the load makes the wider path look narrower
+    candidates[1].resp.Load = 5
+    candidates[1].metrics.Bandwidth = 12000000
+    if got, _ := newServerSelector(LowestLatency).choose("movie", candidates); got.addr != serverA {
+        t.Errorf("chose the loaded %s, want %s", got.addr, serverA)
+    }
+}
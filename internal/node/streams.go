@@ -1,9 +1,10 @@
-package main
+package node
 
 import (
 	"log/slog"
 	"net/netip"
 
+	"github.com/SLP25/ESR/internal/recovery"
 	"github.com/SLP25/ESR/internal/utils"
 	"github.com/pion/sdp/v2"
 )
@@ -11,6 +12,7 @@ import (
 type waitingStream struct {
     to utils.Set[netip.AddrPort]
     localPort uint16
+    requestID uint32    //of the StreamRequest sent upstream, 0 if none was sent yet
 }
 
 type stream struct {
@@ -18,7 +20,14 @@ type stream struct {
     toLocal uint16
     to utils.Set[netip.AddrPort]
     metadata utils.StreamMetadata
+    metrics utils.Metrics           //of the path to the server, updated when the upstream is reevaluated
+    path []uint32                   //IDs of the nodes upstream, from the server to the upstream node
     sdp sdp.SessionDescription
+    received *recovery.Tracker      //detects the packets lost from the upstream
+    sent *recovery.Buffer           //for the retransmission of the packets lost by the subscribers
+    encoders map[netip.AddrPort]*recovery.Encoder  //of the repairs sent to each subscriber, on lossy links
+    decoder *recovery.Decoder       //of the repairs received from the upstream
+    paused bool                     //by the sole subscriber, as last relayed upstream
 }
 
 type streams map[string]*stream
@@ -39,17 +48,24 @@ func (this streams) startSubscription(streamID string, resp probeResponse, port
         toLocal: port,
         to: utils.SetFrom(children...),
         metadata: *resp.stream,
+        metrics: resp.metrics,
+        path: resp.path,
         sdp: sdp,
+        received: recovery.NewTracker(),
+        sent: recovery.NewBuffer(),
+        encoders: make(map[netip.AddrPort]*recovery.Encoder),
+        decoder: recovery.NewDecoder(),
     }
 }
 
-func (this streams) ForwardAddresses(localPort uint16) []netip.AddrPort {
-    for _, stream := range this {
+//Returns the ID of the stream received on the local port, or "" if there is none
+func (this streams) byLocalPort(localPort uint16) string {
+    for streamID, stream := range this {
         if localPort == stream.toLocal {
-            return stream.to.ToSlice()
+            return streamID
         }
     }
-    return make([]netip.AddrPort, 0)
+    return ""
 }
 
 func (this streams) endSubscription(streamID string) (netip.Addr, uint16) {
@@ -65,6 +81,7 @@ func (this streams) removeSubscriber(streamID string, addr netip.Addr, port uint
         return false
     }
     
+    delete(this[streamID].encoders, netip.AddrPortFrom(addr, port))
     return this[streamID].to.Remove(netip.AddrPortFrom(addr, port)) && this[streamID].to.Length() == 0
 }
 
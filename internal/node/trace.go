@@ -0,0 +1,105 @@
+package node
+
+import (
+    "context"
+    "net/netip"
+    "time"
+
+    "github.com/SLP25/ESR/internal/packet"
+    "github.com/SLP25/ESR/internal/service"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+//Time each hop keeps for itself when forwarding a trace: the upstream gets the rest of the timeout
+const traceMargin = 100 * time.Millisecond
+
+//Answers a trace with this node's state of the stream, followed by the hops upstream of it
+func (this *Node) handleTrace(msg service.TCPMessage, req packet.TraceRequest) {
+    hop, upstream, ok := this.traceHop(req.StreamID)
+    if !ok {
+        utils.Warn(msg.SendResponse(req.Respond(hop)))
+        return
+    }
+
+    forward := req
+    forward.Timeout = req.Timeout - 2 * traceMargin
+    if forward.Timeout <= 0 {
+        hop.Error = "trace timed out"
+        utils.Warn(msg.SendResponse(req.Respond(hop)))
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), req.Timeout - traceMargin)
+    defer cancel()
+
+    //the interceptor is registered before sending, so the response can't be missed
+    answer := service.InterceptContext(ctx, &this.serv, func(sig service.Signal) bool {
+        m, ok := sig.(service.TCPMessage)
+        if !ok { return false }
+
+        resp, ok := m.Packet().(packet.TraceResponse)
+        return ok && m.Addr().Addr() == upstream.Addr() && resp.RequestID == req.RequestID
+    }, 1)
+
+    start := time.Now()
+    err := this.serv.TCPServer().SendConnect(forward, upstream)
+    if err != nil {
+        hop.Error = "unable to reach the upstream: " + err.Error()
+        utils.Warn(msg.SendResponse(req.Respond(hop)))
+        return
+    }
+
+    sig, ok := <-answer
+    if !ok {
+        hop.Error = "the upstream didn't answer in time"
+        utils.Warn(msg.SendResponse(req.Respond(hop)))
+        return
+    }
+
+    //the round trip of this hop is what's left once the hops upstream take theirs
+    resp := sig.(service.TCPMessage).Packet().(packet.TraceResponse)
+    hop.UpstreamRTT = time.Since(start)
+    for _, h := range resp.Hops {
+        hop.UpstreamRTT -= h.UpstreamRTT
+    }
+    utils.Warn(msg.SendResponse(req.Respond(append([]packet.TraceHop{hop}, resp.Hops...)...)))
+}
+
+//Returns the state of the stream on this node and the address of its upstream.
+//If the stream isn't running, the trace stops here (false is returned)
+func (this *Node) traceHop(streamID string) (packet.TraceHop, netip.AddrPort, bool) {
+    this.mutex.Lock()
+    defer this.mutex.Unlock()
+
+    hop := packet.TraceHop{ID: this.id}
+
+    s, ok := this.runningStreams[streamID]
+    if !ok {
+        if _, waiting := this.waitingStreams[streamID]; waiting {
+            hop.Error = "waiting for the stream"
+        } else {
+            hop.Error = "not carrying the stream"
+        }
+        return hop, netip.AddrPort{}, false
+    }
+
+    upstream := this.upstreamAddr(s)
+    if _, ok := this.neighbours[s.from]; ok {
+        hop.Link = this.linkMetrics(s.from)
+    } else {
+        hop.Link = this.monitor.GetMetrics(upstream)
+    }
+
+    hop.Running = true
+    hop.Upstream = upstream
+    hop.Subscribers = s.to.ToSlice()
+    return hop, upstream, true
+}
+
+//Returns the TCP address of the node or server the stream is received from. Must hold the node's mutex
+func (this *Node) upstreamAddr(s *stream) netip.AddrPort {
+    if ni, ok := this.neighbours[s.from]; ok {
+        return netip.AddrPortFrom(s.from, ni.port)
+    }
+    return this.serverAddr(s.from)
+}
@@ -0,0 +1,79 @@
+package packet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//client/node -> node/server
+//Floods the overlay like a probe. Every node answers with the streams hosted by the servers it reaches
+type CatalogRequest struct {
+	RequestID uint32
+	Timeout time.Duration //time the sender waits for the response. Each hop forwards the request with less
+}
+
+//node/server -> client/node
+type CatalogResponse struct {
+	RequestID uint32
+	Streams []CatalogEntry //sorted by streamID
+}
+
+//A stream hosted by some server
+type CatalogEntry struct {
+	StreamID string
+	Stream utils.StreamMetadata
+}
+
+func (this CatalogRequest) Respond(streams ...CatalogEntry) CatalogResponse {
+	return CatalogResponse{RequestID: this.RequestID, Streams: streams}
+}
+
+func (this CatalogRequest) marshal(w *writer) {
+	w.u32(this.RequestID)
+	w.duration(this.Timeout)
+}
+
+func (this *CatalogRequest) unmarshal(r *reader) {
+	this.RequestID = r.u32()
+	this.Timeout = r.duration()
+}
+
+func (this CatalogResponse) marshal(w *writer) {
+	w.u32(this.RequestID)
+	w.uvarint(uint64(len(this.Streams)))
+	for _, e := range this.Streams {
+		w.string(e.StreamID)
+		w.streamMetadata(e.Stream)
+	}
+}
+
+func (this *CatalogResponse) unmarshal(r *reader) {
+	this.RequestID = r.u32()
+	n := r.length()
+	this.Streams = make([]CatalogEntry, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		var e CatalogEntry
+		e.StreamID = r.string()
+		e.Stream = r.streamMetadata()
+		this.Streams = append(this.Streams, e)
+	}
+}
+
+//Merges catalogs into one, sorted by streamID. The first entry of each stream is kept
+func MergeCatalogs(catalogs ...[]CatalogEntry) []CatalogEntry {
+	seen := make(map[string]bool)
+	ans := []CatalogEntry{}
+	for _, c := range catalogs {
+		for _, e := range c {
+			if !seen[e.StreamID] {
+				seen[e.StreamID] = true
+				ans = append(ans, e)
+			}
+		}
+	}
+
+	sort.Slice(ans, func(i, j int) bool { return ans[i].StreamID < ans[j].StreamID })
+	return ans
+}
@@ -0,0 +1,281 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+type Encoding byte
+
+const (
+	Binary Encoding = iota
+	JSON			//human-readable bodies, meant for debugging
+)
+
+//Set in the type byte of a frame when its body is JSON encoded.
+//Receivers decode both encodings, so nodes with different settings interoperate
+const jsonFlag byte = 0x80
+
+var encoding = Binary
+
+func init() {
+	if os.Getenv("ESR_ENCODING") == "json" {
+		encoding = JSON
+	}
+}
+
+//Selects the encoding used by Serialize. The default is Binary,
+//unless the ESR_ENCODING environment variable is set to "json"
+func SetEncoding(e Encoding) {
+	encoding = e
+}
+
+func GetEncoding() Encoding {
+	return encoding
+}
+
+var errShortBuffer = errors.New("packet: unexpected end of packet body")
+
+//Types implementing marshaler and unmarshaler (on the pointer) can be sent with the Binary encoding
+type marshaler interface {
+	marshal(w *writer)
+}
+
+type unmarshaler interface {
+	unmarshal(r *reader)
+}
+
+
+//Little endian writer for packet bodies
+type writer struct {
+	buf []byte
+}
+
+func (this *writer) u8(v byte) {
+	this.buf = append(this.buf, v)
+}
+
+func (this *writer) bool(v bool) {
+	if v {
+		this.u8(1)
+	} else {
+		this.u8(0)
+	}
+}
+
+func (this *writer) u16(v uint16) {
+	this.buf = binary.LittleEndian.AppendUint16(this.buf, v)
+}
+
+func (this *writer) u32(v uint32) {
+	this.buf = binary.LittleEndian.AppendUint32(this.buf, v)
+}
+
+func (this *writer) u64(v uint64) {
+	this.buf = binary.LittleEndian.AppendUint64(this.buf, v)
+}
+
+func (this *writer) uvarint(v uint64) {
+	this.buf = binary.AppendUvarint(this.buf, v)
+}
+
+func (this *writer) varint(v int64) {
+	this.buf = binary.AppendVarint(this.buf, v)
+}
+
+func (this *writer) f64(v float64) {
+	this.u64(math.Float64bits(v))
+}
+
+func (this *writer) duration(v time.Duration) {
+	this.varint(int64(v))
+}
+
+func (this *writer) bytes(v []byte) {
+	this.uvarint(uint64(len(v)))
+	this.buf = append(this.buf, v...)
+}
+
+func (this *writer) string(v string) {
+	this.uvarint(uint64(len(v)))
+	this.buf = append(this.buf, v...)
+}
+
+func (this *writer) addr(v netip.Addr) {
+	this.bytes(v.AsSlice())
+}
+
+func (this *writer) addrPort(v netip.AddrPort) {
+	this.addr(v.Addr())
+	this.u16(v.Port())
+}
+
+func (this *writer) metrics(v utils.Metrics) {
+	this.duration(v.Latency)
+	this.f64(v.PacketLoss)
+	this.varint(int64(v.Bandwidth))
+}
+
+func (this *writer) streamMetadata(v utils.StreamMetadata) {
+	this.varint(int64(v.Bitrate))
+	this.duration(v.Duration)
+	this.uvarint(uint64(len(v.Tracks)))
+	for _, t := range v.Tracks {
+		this.string(t.Kind)
+		this.string(t.Codec)
+		this.varint(int64(t.Bitrate))
+		this.uvarint(uint64(t.Width))
+		this.uvarint(uint64(t.Height))
+		this.f64(t.FrameRate)
+		this.uvarint(uint64(t.SampleRate))
+		this.uvarint(uint64(t.Channels))
+	}
+}
+
+
+//Little endian reader for packet bodies.
+//The first error is kept and every later read returns a zero value
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (this *reader) next(n int) []byte {
+	if this.err != nil {
+		return nil
+	}
+	if n < 0 || len(this.buf) < n {
+		this.err = errShortBuffer
+		return nil
+	}
+
+	ans := this.buf[:n]
+	this.buf = this.buf[n:]
+	return ans
+}
+
+func (this *reader) u8() byte {
+	b := this.next(1)
+	if b == nil { return 0 }
+	return b[0]
+}
+
+func (this *reader) bool() bool {
+	return this.u8() != 0
+}
+
+func (this *reader) u16() uint16 {
+	b := this.next(2)
+	if b == nil { return 0 }
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (this *reader) u32() uint32 {
+	b := this.next(4)
+	if b == nil { return 0 }
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (this *reader) u64() uint64 {
+	b := this.next(8)
+	if b == nil { return 0 }
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (this *reader) uvarint() uint64 {
+	if this.err != nil { return 0 }
+
+	v, n := binary.Uvarint(this.buf)
+	if n <= 0 {
+		this.err = errShortBuffer
+		return 0
+	}
+	this.buf = this.buf[n:]
+	return v
+}
+
+func (this *reader) varint() int64 {
+	if this.err != nil { return 0 }
+
+	v, n := binary.Varint(this.buf)
+	if n <= 0 {
+		this.err = errShortBuffer
+		return 0
+	}
+	this.buf = this.buf[n:]
+	return v
+}
+
+//Reads a length prefix, rejecting lengths that can't possibly fit in the rest of the body
+func (this *reader) length() int {
+	n := this.uvarint()
+	if n > uint64(len(this.buf)) {
+		this.err = errShortBuffer
+		return 0
+	}
+	return int(n)
+}
+
+func (this *reader) f64() float64 {
+	return math.Float64frombits(this.u64())
+}
+
+func (this *reader) duration() time.Duration {
+	return time.Duration(this.varint())
+}
+
+func (this *reader) bytes() []byte {
+	b := this.next(this.length())
+	if b == nil { return nil }
+
+	ans := make([]byte, len(b))
+	copy(ans, b)
+	return ans
+}
+
+func (this *reader) string() string {
+	return string(this.next(this.length()))
+}
+
+func (this *reader) addr() netip.Addr {
+	addr, _ := netip.AddrFromSlice(this.next(this.length()))
+	return addr
+}
+
+func (this *reader) addrPort() netip.AddrPort {
+	addr := this.addr()
+	return netip.AddrPortFrom(addr, this.u16())
+}
+
+func (this *reader) metrics() utils.Metrics {
+	return utils.Metrics{
+		Latency: this.duration(),
+		PacketLoss: this.f64(),
+		Bandwidth: int(this.varint()),
+	}
+}
+
+func (this *reader) streamMetadata() utils.StreamMetadata {
+	ans := utils.StreamMetadata{Bitrate: int(this.varint()), Duration: this.duration()}
+	n := this.length()
+	ans.Tracks = make([]utils.TrackMetadata, 0, n)
+	for i := 0; i < n && this.err == nil; i++ {
+		ans.Tracks = append(ans.Tracks, utils.TrackMetadata{
+			Kind: this.string(),
+			Codec: this.string(),
+			Bitrate: int(this.varint()),
+			Width: int(this.uvarint()),
+			Height: int(this.uvarint()),
+			FrameRate: this.f64(),
+			SampleRate: int(this.uvarint()),
+			Channels: int(this.uvarint()),
+		})
+	}
+	return ans
+}
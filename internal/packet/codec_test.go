@@ -0,0 +1,243 @@
+package packet
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+var sampleStreamPacket = StreamPacket{Type: Video, Seq: 4000000000, Content: bytes.Repeat([]byte{0xAB}, 1200)}
+
+var sampleProbeResponse = ProbeResponse{
+	StreamID: "movie",
+	RequestID: 0xDEADBEEF,
+	Exists: true,
+	Stream: utils.StreamMetadata{
+		Bitrate: 1500000,
+		Duration: 90 * time.Second,
+		Tracks: []utils.TrackMetadata{
+			{Kind: utils.VideoTrack, Codec: "h264", Bitrate: 1400000, Width: 1280, Height: 720, FrameRate: 29.97},
+			{Kind: utils.AudioTrack, Codec: "opus", Bitrate: 100000, SampleRate: 48000, Channels: 2},
+		},
+	},
+	Metrics: utils.Metrics{Latency: 12 * time.Millisecond, PacketLoss: 0.015, Bandwidth: 10000000},
+	Load: 3,
+	Path: []uint32{1, 2, 3},
+	Health: Degraded,
+}
+
+var sampleStartupResponseNode = StartupResponseNode{
+	Neighbours: map[netip.AddrPort]utils.Metrics{
+		netip.MustParseAddrPort("10.0.0.1:5000"): {Latency: 5 * time.Millisecond, PacketLoss: 0.01, Bandwidth: 1000000},
+		netip.MustParseAddrPort("[fd00::2]:5000"): {Latency: 20 * time.Millisecond},
+	},
+	Servers: []netip.AddrPort{netip.MustParseAddrPort("10.0.1.1:6000")},
+}
+
+var sampleTraceResponse = TraceResponse{
+	StreamID: "movie",
+	RequestID: 9,
+	Hops: []TraceHop{
+		{
+			ID: 0xCAFE,
+			Running: true,
+			Upstream: netip.MustParseAddrPort("10.0.1.1:6000"),
+			Link: utils.Metrics{Latency: 3 * time.Millisecond, PacketLoss: 0.125, Bandwidth: 5000000},
+			Subscribers: []netip.AddrPort{netip.MustParseAddrPort("10.0.2.21:5000"), netip.MustParseAddrPort("[fd00::3]:5001")},
+			UpstreamRTT: 7 * time.Millisecond,
+		},
+		//a server has no upstream
+		{Server: true, Running: true, Subscribers: []netip.AddrPort{}},
+		{ID: 1, Subscribers: []netip.AddrPort{}, Error: "stream not running"},
+	},
+}
+
+var sampleCatalogResponse = CatalogResponse{
+	RequestID: 11,
+	Streams: []CatalogEntry{
+		{StreamID: "live", Stream: utils.StreamMetadata{Bitrate: 800000, Tracks: []utils.TrackMetadata{}}},
+		{StreamID: "movie", Stream: sampleProbeResponse.Stream},
+	},
+}
+
+var samples = map[string]Packet{
+	"StreamPacket": sampleStreamPacket,
+	"ProbeResponse": sampleProbeResponse,
+	"StartupResponseNode": sampleStartupResponseNode,
+	"ProbeRequest": ProbeRequest{StreamID: "movie", RequestID: 7, Local: true},
+	"Ping": Ping{ID: 42, Data: []byte{1, 2, 3}},
+	"Nack": Nack{StreamID: "movie", Port: 5000, Missing: []uint32{10, 12, 4294967295}},
+	"StreamRepair": StreamRepair{Base: 4294967290, Mask: 0b10111, Type: Audio, Length: 300, Content: []byte{9, 8, 7}},
+	"TraceRequest": TraceRequest{StreamID: "movie", RequestID: 9, Timeout: 1500 * time.Millisecond},
+	"TraceResponse": sampleTraceResponse,
+	"CatalogRequest": CatalogRequest{RequestID: 11, Timeout: time.Second},
+	"CatalogResponse": sampleCatalogResponse,
+}
+
+//Sets the encoding for the duration of the test or benchmark
+func withEncoding(tb testing.TB, e Encoding) {
+	old := GetEncoding()
+	SetEncoding(e)
+	tb.Cleanup(func() { SetEncoding(old) })
+}
+
+func roundTrip(t *testing.T, p Packet) (Packet, []byte) {
+	var buf bytes.Buffer
+	n, err := Serialize(p, &buf)
+	if err != nil {
+		t.Fatalf("Serialize(%T): %v", p, err)
+	} else if n != buf.Len() {
+		t.Fatalf("Serialize(%T) returned %d bytes, but wrote %d", p, n, buf.Len())
+	}
+
+	wire := bytes.Clone(buf.Bytes())
+	ans, err := Deserialize(&buf)
+	if err != nil {
+		t.Fatalf("Deserialize(%T): %v", p, err)
+	} else if buf.Len() != 0 {
+		t.Fatalf("Deserialize(%T) left %d bytes unread", p, buf.Len())
+	}
+	return ans, wire
+}
+
+func TestRoundTripBinary(t *testing.T) {
+	withEncoding(t, Binary)
+	for name, p := range samples {
+		t.Run(name, func(t *testing.T) {
+			got, wire := roundTrip(t, p)
+			if wire[2] & jsonFlag != 0 {
+				t.Errorf("binary frame has the JSON flag set: type byte %#x", wire[2])
+			}
+			if !reflect.DeepEqual(got, p) {
+				t.Errorf("got %+v, want %+v", got, p)
+			}
+		})
+	}
+}
+
+func TestRoundTripJSON(t *testing.T) {
+	withEncoding(t, JSON)
+	for name, p := range samples {
+		t.Run(name, func(t *testing.T) {
+			got, wire := roundTrip(t, p)
+			if wire[2] & jsonFlag == 0 {
+				t.Errorf("JSON frame lacks the JSON flag: type byte %#x", wire[2])
+			}
+			if !reflect.DeepEqual(got, p) {
+				t.Errorf("got %+v, want %+v", got, p)
+			}
+		})
+	}
+}
+
+//A receiver decodes both encodings whatever its own setting, as told by the flag of each frame
+func TestMixedEncodings(t *testing.T) {
+	var buf bytes.Buffer
+	withEncoding(t, JSON)
+	if _, err := Serialize(sampleProbeResponse, &buf); err != nil {
+		t.Fatal(err)
+	}
+	SetEncoding(Binary)
+	if _, err := Serialize(sampleStreamPacket, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []Packet{sampleProbeResponse, sampleStreamPacket} {
+		got, err := Deserialize(&buf)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestTruncatedBody(t *testing.T) {
+	withEncoding(t, Binary)
+	var buf bytes.Buffer
+	if _, err := Serialize(sampleProbeResponse, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := buf.Bytes()
+	frame[0]--	//claim a body one byte shorter than the one written
+	frame = frame[:len(frame) - 1]
+	if _, err := Deserialize(bytes.NewReader(frame)); err == nil {
+		t.Error("truncated body decoded without error")
+	}
+}
+
+func TestUnknownType(t *testing.T) {
+	frame := []byte{2, 0, 0x7F, 1, 2}
+	r := bytes.NewReader(frame)
+	_, err := Deserialize(r)
+	if _, ok := err.(UnknownTypeError); !ok {
+		t.Fatalf("got error %v, want UnknownTypeError", err)
+	} else if r.Len() != 0 {
+		t.Errorf("%d bytes of the unknown packet left unread", r.Len())
+	}
+}
+
+
+//Reports the bytes on the wire of a packet, and the time to serialize it
+func benchmarkSerialize(b *testing.B, e Encoding, p Packet) {
+	withEncoding(b, e)
+	var buf bytes.Buffer
+	n, err := Serialize(p, &buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		Serialize(p, &buf)
+	}
+	b.ReportMetric(float64(n), "wire-bytes")
+}
+
+//Reports the time to deserialize a packet
+func benchmarkDeserialize(b *testing.B, e Encoding, p Packet) {
+	withEncoding(b, e)
+	var buf bytes.Buffer
+	if _, err := Serialize(p, &buf); err != nil {
+		b.Fatal(err)
+	}
+	frame := buf.Bytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Deserialize(bytes.NewReader(frame)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(frame)), "wire-bytes")
+}
+
+func benchmarkCodec(b *testing.B, p Packet) {
+	for _, e := range []struct {
+		name string
+		encoding Encoding
+	}{{"Binary", Binary}, {"JSON", JSON}} {
+		b.Run(e.name + "/Serialize", func(b *testing.B) { benchmarkSerialize(b, e.encoding, p) })
+		b.Run(e.name + "/Deserialize", func(b *testing.B) { benchmarkDeserialize(b, e.encoding, p) })
+	}
+}
+
+func BenchmarkStreamPacket(b *testing.B) {
+	benchmarkCodec(b, sampleStreamPacket)
+}
+
+func BenchmarkProbeResponse(b *testing.B) {
+	benchmarkCodec(b, sampleProbeResponse)
+}
+
+func BenchmarkStartupResponseNode(b *testing.B) {
+	benchmarkCodec(b, sampleStartupResponseNode)
+}
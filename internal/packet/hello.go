@@ -0,0 +1,76 @@
+package packet
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Version of the wire protocol. Peers only talk to each other if their versions match.
+//Bump it whenever a packet type is added or the encoding of an existing one changes
+const ProtocolVersion uint16 = 12
+
+type Capability uint32
+
+const (
+	CapBinaryEncoding Capability = 1 << iota	//can decode binary bodies
+	CapJSONEncoding								//can decode JSON bodies
+)
+
+//Capabilities advertised by this build
+const LocalCapabilities = CapBinaryEncoding | CapJSONEncoding
+
+//Capabilities a peer must advertise to be accepted
+const RequiredCapabilities = CapBinaryEncoding
+
+func (this Capability) Has(c Capability) bool {
+	return this & c == c
+}
+
+func (this Capability) String() string {
+	names := []string{}
+	if this.Has(CapBinaryEncoding) { names = append(names, "binary") }
+	if this.Has(CapJSONEncoding) { names = append(names, "json") }
+	return "[" + strings.Join(names, ",") + "]"
+}
+
+//any <-> any
+//First packet sent in both directions of every TCP connection
+type Hello struct {
+	Version uint16
+	Capabilities Capability
+}
+
+func NewHello() Hello {
+	return Hello{Version: ProtocolVersion, Capabilities: LocalCapabilities}
+}
+
+//Returned when a peer's Hello is incompatible with this build
+type HandshakeError struct {
+	Remote Hello
+}
+
+func (this HandshakeError) Error() string {
+	if this.Remote.Version != ProtocolVersion {
+		return fmt.Sprintf("incompatible protocol version: local %d, remote %d", ProtocolVersion, this.Remote.Version)
+	}
+	return fmt.Sprintf("missing capabilities: required %s, remote has %s", RequiredCapabilities, this.Remote.Capabilities)
+}
+
+//Checks whether a peer that sent the given Hello can be talked to
+func (this Hello) Check() error {
+	if this.Version != ProtocolVersion || !this.Capabilities.Has(RequiredCapabilities) {
+		return HandshakeError{Remote: this}
+	}
+	return nil
+}
+
+
+func (this Hello) marshal(w *writer) {
+	w.u16(this.Version)
+	w.u32(uint32(this.Capabilities))
+}
+
+func (this *Hello) unmarshal(r *reader) {
+	this.Version = r.u16()
+	this.Capabilities = Capability(r.u32())
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"math"
@@ -13,88 +14,144 @@ import (
 type Packet interface {
 }
 
-var packet_list = []reflect.Type{
-	reflect.TypeOf(StartupRequest{}),
-	reflect.TypeOf(StartupResponseClient{}),
-	reflect.TypeOf(StartupResponseNode{}),
-
-	reflect.TypeOf(ProbeRequest{}),
-	reflect.TypeOf(ProbeResponse{}),
-	reflect.TypeOf(Ping{}),
-	
-	reflect.TypeOf(StreamRequest{}),
-	reflect.TypeOf(StreamResponse{}),
-	reflect.TypeOf(StreamCancel{}),
-	reflect.TypeOf(StreamEnd{}),
-	reflect.TypeOf(StreamPacket{}),
+//Wire codes of every packet type.
+//The codes are part of the protocol: once assigned, a code must never be changed or reused.
+//New packet types get new codes (below jsonFlag) and a bump of ProtocolVersion
+var packet_list = map[byte]reflect.Type{
+	0: reflect.TypeOf(StartupRequest{}),
+	1: reflect.TypeOf(StartupResponseClient{}),
+	2: reflect.TypeOf(StartupResponseNode{}),
+
+	3: reflect.TypeOf(ProbeRequest{}),
+	4: reflect.TypeOf(ProbeResponse{}),
+	5: reflect.TypeOf(Ping{}),
+
+	6: reflect.TypeOf(StreamRequest{}),
+	7: reflect.TypeOf(StreamResponse{}),
+	8: reflect.TypeOf(StreamCancel{}),
+	9: reflect.TypeOf(StreamEnd{}),
+	10: reflect.TypeOf(StreamPacket{}),
+
+	11: reflect.TypeOf(Hello{}),
+
+	12: reflect.TypeOf(TraceRequest{}),
+	13: reflect.TypeOf(TraceResponse{}),
+
+	14: reflect.TypeOf(CatalogRequest{}),
+	15: reflect.TypeOf(CatalogResponse{}),
+
+	16: reflect.TypeOf(PlaybackRequest{}),
+	17: reflect.TypeOf(PlaybackResponse{}),
+
+	18: reflect.TypeOf(Nack{}),
+	19: reflect.TypeOf(StreamRepair{}),
 }
 
-func encodeType(t reflect.Type) (byte, error) {
-	for i, t2 := range packet_list {
-		if t == t2 {
-			return byte(i), nil
+var type_codes = make(map[reflect.Type]byte)
+
+func init() {
+	for code, t := range packet_list {
+		if code & jsonFlag != 0 {
+			panic("Packet type " + t.Name() + " has a code that collides with the encoding flag")
 		}
+		type_codes[t] = code
+	}
+}
+
+//Returned by Deserialize when the type code of a packet isn't registered.
+//The body of the packet is consumed, so the reader remains aligned with the next packet
+type UnknownTypeError struct {
+	Code byte
+}
+
+func (this UnknownTypeError) Error() string {
+	return fmt.Sprintf("packet.Deserialize(): Unknown packet type code %d", this.Code)
+}
+
+func encodeType(t reflect.Type) (byte, error) {
+	if code, ok := type_codes[t]; ok {
+		return code, nil
 	}
 
 	slog.Error("Packet type not registered", "type", t)
 	return 0, errors.New("Packet type " + t.Name() + " not registered")
 }
 
-func fetchType(name string) reflect.Type {
-	for _, t := range packet_list {
-		if t.Name() == name {
-			return t
-		}
+func encodeBody(val Packet) ([]byte, byte, error) {
+	if encoding == JSON {
+		b, err := json.Marshal(val)
+		return b, jsonFlag, err
 	}
 
-	slog.Error("Unknown packet type", "type", name)
-	return nil
+	m, ok := val.(marshaler)
+	if !ok {
+		return nil, 0, errors.New("Packet type " + reflect.TypeOf(val).Name() + " has no binary encoding")
+	}
+
+	var w writer
+	m.marshal(&w)
+	return w.buf, 0, nil
 }
 
-//TODO: space-efficient marshalling
+func decodeBody(data []byte, val any, isJSON bool) error {
+	if isJSON {
+		return json.Unmarshal(data, val)
+	}
+
+	u, ok := val.(unmarshaler)
+	if !ok {
+		return errors.New("Packet type " + reflect.TypeOf(val).Elem().Name() + " has no binary encoding")
+	}
+
+	r := reader{buf: data}
+	u.unmarshal(&r)
+	return r.err
+}
 
 func Serialize(val Packet, w io.Writer) (int, error) {
-	b, err := json.Marshal(val)
-    if err != nil {
-        slog.Error("Error serializing packet", "err", err)
-        return 0, err
-    }
+	typeCode, err := encodeType(reflect.TypeOf(val))
+	if err != nil { return 0, err }
+
+	b, flags, err := encodeBody(val)
+	if err != nil {
+		slog.Error("Error serializing packet", "err", err)
+		return 0, err
+	}
 
 	if len(b) > math.MaxUint16 {
 		return 0, errors.New("packet.Serialize(): Packet too big")
 	}
 
-	length := make([]byte, 2)
-	binary.LittleEndian.PutUint16(length, uint16(len(b)))
+	ans := make([]byte, 3, 3 + len(b))
+	binary.LittleEndian.PutUint16(ans, uint16(len(b)))
+	ans[2] = typeCode | flags
+	ans = append(ans, b...)
 
-	typeCode, err := encodeType(reflect.TypeOf(val))
-	if err != nil { return 0, err }
-	
-	ans := append(append(length, typeCode), b...)
 	_, err = w.Write(ans)
 	if err != nil { return 0, err }
 
-	return 3 + len(b), nil
+	return len(ans), nil
 }
 
 func Deserialize(r io.Reader) (Packet, error) {
-	var length uint16
-	err := binary.Read(r, binary.LittleEndian, &length)
+	header := make([]byte, 3)
+	_, err := io.ReadFull(r, header)
 	if err != nil { return nil, err }
 
-	var typeCode byte
-	err = binary.Read(r, binary.LittleEndian, &typeCode)
-	if err != nil { return nil, err }
+	length := binary.LittleEndian.Uint16(header)
+	typeCode := header[2] &^ jsonFlag
 
 	data := make([]byte, length)
 	_, err = io.ReadFull(r, data)
 	if err != nil { return nil, err }
 
-	pType := packet_list[typeCode]
+	pType, ok := packet_list[typeCode]
+	if !ok { return nil, UnknownTypeError{Code: typeCode} }
+
 	val := reflect.New(pType).Interface()
 
-	err = json.Unmarshal(data, val)
-    if err != nil { return nil, err }
+	err = decodeBody(data, val, header[2] & jsonFlag != 0)
+	if err != nil { return nil, err }
 
 	return reflect.ValueOf(val).Elem().Interface(), nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,91 @@
+package packet
+
+import (
+	"fmt"
+	"time"
+)
+
+//Action of a PlaybackRequest
+type PlaybackAction uint8
+
+const (
+	Status PlaybackAction = iota	//only reports the position. Allowed to any viewer
+	Seek							//moves to Offset from the start of the stream
+	Skip							//moves by Offset (possibly negative) from the current position
+	Pause
+	Resume
+)
+
+func (this PlaybackAction) String() string {
+	switch this {
+		case Status: return "status"
+		case Seek: return "seek"
+		case Skip: return "skip"
+		case Pause: return "pause"
+		case Resume: return "resume"
+		default: return fmt.Sprintf("PlaybackAction(%d)", uint8(this))
+	}
+}
+
+//client -> node -> ... -> server
+//Controls the playback of a stream, which is shared by all its viewers. So every action but Status is only
+//allowed to the sole viewer: each hop forwards the request upstream only if it came from its only subscriber
+type PlaybackRequest struct {
+	StreamID string
+	RequestID uint32
+	Action PlaybackAction
+	Offset time.Duration
+	Timeout time.Duration //time the sender waits for the response. Each hop forwards the request with less
+}
+
+//server -> ... -> node -> client
+type PlaybackResponse struct {
+	StreamID string
+	RequestID uint32
+	Position time.Duration //of the stream after the action
+	Duration time.Duration //of the stream, 0 if it's live
+	Paused bool
+	Error string //why the action was refused, if it was
+}
+
+func (this PlaybackRequest) Respond(position time.Duration, duration time.Duration, paused bool) PlaybackResponse {
+	return PlaybackResponse{StreamID: this.StreamID, RequestID: this.RequestID, Position: position, Duration: duration, Paused: paused}
+}
+
+func (this PlaybackRequest) Refuse(format string, args ...any) PlaybackResponse {
+	return PlaybackResponse{StreamID: this.StreamID, RequestID: this.RequestID, Error: fmt.Sprintf(format, args...)}
+}
+
+func (this PlaybackRequest) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.u8(byte(this.Action))
+	w.duration(this.Offset)
+	w.duration(this.Timeout)
+}
+
+func (this *PlaybackRequest) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	this.Action = PlaybackAction(r.u8())
+	this.Offset = r.duration()
+	this.Timeout = r.duration()
+}
+
+func (this PlaybackResponse) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.duration(this.Position)
+	w.duration(this.Duration)
+	w.bool(this.Paused)
+	w.string(this.Error)
+}
+
+func (this *PlaybackResponse) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	this.Position = r.duration()
+	this.Duration = r.duration()
+	this.Paused = r.bool()
+	this.Error = r.string()
+}
@@ -1,11 +1,16 @@
 package packet
 
-import "github.com/SLP25/ESR/internal/utils"
+import (
+	"fmt"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
 
 //node -> node
 type ProbeRequest struct {
 	StreamID string
 	RequestID uint32 //random number to identify a request
+	Local bool //only answered by nodes carrying the stream, and never propagated
 }
 
 //node -> node
@@ -14,6 +19,28 @@ type ProbeResponse struct {
 	RequestID uint32 //random number to identify a request
 	Exists bool
 	Stream utils.StreamMetadata
+	Metrics utils.Metrics //of the path from the sender to the stream's server
+	Load int //subscribers currently served by the server. Only set by servers
+	Path []uint32 //IDs of the nodes the stream flows through, from the server to the sender
+	Health StreamHealth //of the stream's source on the server
+}
+
+//State of the source of a stream on its server
+type StreamHealth uint8
+
+const (
+	Healthy StreamHealth = iota
+	Degraded	//the source failed recently and is being restarted
+	Failed		//the source failed repeatedly and isn't restarted for a while
+)
+
+func (this StreamHealth) String() string {
+	switch this {
+		case Healthy: return "healthy"
+		case Degraded: return "degraded"
+		case Failed: return "failed"
+		default: return fmt.Sprintf("StreamHealth(%d)", uint8(this))
+	}
 }
 
 
@@ -23,4 +50,45 @@ func (this ProbeRequest) RespondNonExistant() ProbeResponse {
 
 func (this ProbeRequest) RespondExistant(stream utils.StreamMetadata) ProbeResponse {
 	return ProbeResponse{StreamID: this.StreamID, RequestID: this.RequestID, Exists: true, Stream: stream}
-}
\ No newline at end of file
+}
+
+func (this ProbeRequest) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.bool(this.Local)
+}
+
+func (this *ProbeRequest) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	this.Local = r.bool()
+}
+
+func (this ProbeResponse) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.bool(this.Exists)
+	w.streamMetadata(this.Stream)
+	w.metrics(this.Metrics)
+	w.varint(int64(this.Load))
+	w.uvarint(uint64(len(this.Path)))
+	for _, id := range this.Path {
+		w.u32(id)
+	}
+	w.u8(byte(this.Health))
+}
+
+func (this *ProbeResponse) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	this.Exists = r.bool()
+	this.Stream = r.streamMetadata()
+	this.Metrics = r.metrics()
+	this.Load = int(r.varint())
+	n := r.length()
+	this.Path = make([]uint32, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		this.Path = append(this.Path, r.u32())
+	}
+	this.Health = StreamHealth(r.u8())
+}
@@ -23,11 +23,66 @@ type StartupResponseNode struct {
 }
 
 
+//any <-> node/server (UDP). Echoed back as received
 type Ping struct {
 	ID uint32
-	//Data []byte 		//to measure error rate (not implemented)
+	Data []byte		//padding, so trains of pings can measure the bandwidth
 }
 
 func NewPing() Ping {
 	return Ping{ID: utils.RandID()}
-}
\ No newline at end of file
+}
+
+func (this StartupRequest) marshal(w *writer) {
+	w.u8(byte(this.Service))
+}
+
+func (this *StartupRequest) unmarshal(r *reader) {
+	this.Service = utils.ServiceType(r.u8())
+}
+
+func (this StartupResponseClient) marshal(w *writer) {
+	w.addrPort(this.ConnectTo)
+}
+
+func (this *StartupResponseClient) unmarshal(r *reader) {
+	this.ConnectTo = r.addrPort()
+}
+
+func (this StartupResponseNode) marshal(w *writer) {
+	w.uvarint(uint64(len(this.Neighbours)))
+	for addr, m := range this.Neighbours {
+		w.addrPort(addr)
+		w.metrics(m)
+	}
+
+	w.uvarint(uint64(len(this.Servers)))
+	for _, s := range this.Servers {
+		w.addrPort(s)
+	}
+}
+
+func (this *StartupResponseNode) unmarshal(r *reader) {
+	n := r.length()
+	this.Neighbours = make(map[netip.AddrPort]utils.Metrics, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		addr := r.addrPort()
+		this.Neighbours[addr] = r.metrics()
+	}
+
+	n = r.length()
+	this.Servers = make([]netip.AddrPort, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		this.Servers = append(this.Servers, r.addrPort())
+	}
+}
+
+func (this Ping) marshal(w *writer) {
+	w.u32(this.ID)
+	w.bytes(this.Data)
+}
+
+func (this *Ping) unmarshal(r *reader) {
+	this.ID = r.u32()
+	this.Data = r.bytes()
+}
@@ -1,8 +1,10 @@
 package packet
 
 import (
+	"log/slog"
 	"net/netip"
 
+	"github.com/SLP25/ESR/internal/utils"
 	"github.com/pion/sdp/v2"
 )
 
@@ -39,12 +41,39 @@ const (
 	AudioControl
 )
 
+//Returns the kind of track the packets of this type belong to (utils.VideoTrack or utils.AudioTrack)
+func (this StreamType) Kind() string {
+	if this == Video || this == VideoControl {
+		return utils.VideoTrack
+	}
+	return utils.AudioTrack
+}
+
 //server/node -> node/client
 type StreamPacket struct {
 	Type StreamType
+	Seq uint32			//numbered by the server, per stream, across the tracks
 	Content []byte
 }
 
+//node -> node
+//The XOR of a group of stream packets, from which the receiver rebuilds one of them if it was lost
+type StreamRepair struct {
+	Base uint32			//sequence number of the first packet of the group
+	Mask uint32			//packets of the group: bit i is set if Base+i is in it
+	Type StreamType		//the XOR of the types,
+	Length uint16		//lengths
+	Content []byte		//and contents (padded with zeros to the longest) of the packets
+}
+
+//node/client -> node/server
+//Asks the upstream to send again the packets of the stream received on the port that were lost
+type Nack struct {
+	StreamID string
+	Port uint16
+	Missing []uint32	//sequence numbers of the lost packets
+}
+
 func (this *StreamResponse) SetOrigin(origin netip.AddrPort) {
 	this.SDP.Origin.UnicastAddress = origin.String()
 }
@@ -57,4 +86,116 @@ func (this *StreamResponse) SetPorts(video uint16, audio uint16) {
 			m.MediaName.Port = sdp.RangedPort{Value: int(audio)}
 		}
 	}
-}
\ No newline at end of file
+}
+
+func (this StreamRequest) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.u16(this.Port)
+}
+
+func (this *StreamRequest) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	this.Port = r.u16()
+}
+
+//The session description is sent in its textual form
+func (this StreamResponse) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+
+	var txt []byte
+	if len(this.SDP.MediaDescriptions) != 0 || this.SDP.SessionName != "" {
+		var err error
+		txt, err = this.SDP.Marshal()
+		if err != nil {
+			slog.Error("Error marshalling session description", "err", err)
+			txt = nil
+		}
+	}
+	w.bytes(txt)
+}
+
+func (this *StreamResponse) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+
+	txt := r.bytes()
+	if r.err == nil && len(txt) != 0 {
+		r.err = this.SDP.Unmarshal(txt)
+	}
+}
+
+func (this StreamCancel) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u16(this.Port)
+}
+
+func (this *StreamCancel) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.Port = r.u16()
+}
+
+func (this StreamEnd) marshal(w *writer) {
+	w.string(this.StreamID)
+}
+
+func (this *StreamEnd) unmarshal(r *reader) {
+	this.StreamID = r.string()
+}
+
+//The content is the last field, so it isn't length prefixed
+func (this StreamPacket) marshal(w *writer) {
+	w.u8(byte(this.Type))
+	w.u32(this.Seq)
+	w.buf = append(w.buf, this.Content...)
+}
+
+func (this *StreamPacket) unmarshal(r *reader) {
+	this.Type = StreamType(r.u8())
+	this.Seq = r.u32()
+	if r.err == nil {
+		this.Content = r.buf
+		r.buf = nil
+	}
+}
+
+//The content is the last field, so it isn't length prefixed
+func (this StreamRepair) marshal(w *writer) {
+	w.u32(this.Base)
+	w.u32(this.Mask)
+	w.u8(byte(this.Type))
+	w.u16(this.Length)
+	w.buf = append(w.buf, this.Content...)
+}
+
+func (this *StreamRepair) unmarshal(r *reader) {
+	this.Base = r.u32()
+	this.Mask = r.u32()
+	this.Type = StreamType(r.u8())
+	this.Length = r.u16()
+	if r.err == nil {
+		this.Content = r.buf
+		r.buf = nil
+	}
+}
+
+func (this Nack) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u16(this.Port)
+	w.uvarint(uint64(len(this.Missing)))
+	for _, seq := range this.Missing {
+		w.u32(seq)
+	}
+}
+
+func (this *Nack) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.Port = r.u16()
+	n := r.length()
+	this.Missing = make([]uint32, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		this.Missing = append(this.Missing, r.u32())
+	}
+}
@@ -0,0 +1,94 @@
+package packet
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//operator/node -> node/server
+//Walks the upstream chain of a stream up to its server. Every hop answers with its own state
+//followed by the hops upstream of it
+type TraceRequest struct {
+	StreamID string
+	RequestID uint32
+	Timeout time.Duration //time the sender waits for the response. Each hop forwards the request with less
+}
+
+//node/server -> operator/node
+type TraceResponse struct {
+	StreamID string
+	RequestID uint32
+	Hops []TraceHop //from the hop the request was sent to, up to the server
+}
+
+//The state of the stream on a hop of the chain
+type TraceHop struct {
+	ID uint32 //random ID of the node, 0 for servers
+	Server bool
+	Running bool //whether the hop is receiving (or producing) the stream
+	Upstream netip.AddrPort //where the hop receives the stream from, invalid for servers
+	Link utils.Metrics //measured metrics of the link to the upstream
+	Subscribers []netip.AddrPort
+	UpstreamRTT time.Duration //round trip of the request from this hop to its upstream, without the time spent further up the chain
+	Error string //why the chain stops at this hop, if it isn't a server
+}
+
+func (this TraceRequest) Respond(hops ...TraceHop) TraceResponse {
+	return TraceResponse{StreamID: this.StreamID, RequestID: this.RequestID, Hops: hops}
+}
+
+func (this TraceRequest) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.duration(this.Timeout)
+}
+
+func (this *TraceRequest) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	this.Timeout = r.duration()
+}
+
+func (this TraceResponse) marshal(w *writer) {
+	w.string(this.StreamID)
+	w.u32(this.RequestID)
+	w.uvarint(uint64(len(this.Hops)))
+	for _, h := range this.Hops {
+		w.u32(h.ID)
+		w.bool(h.Server)
+		w.bool(h.Running)
+		w.addrPort(h.Upstream)
+		w.metrics(h.Link)
+		w.uvarint(uint64(len(h.Subscribers)))
+		for _, s := range h.Subscribers {
+			w.addrPort(s)
+		}
+		w.duration(h.UpstreamRTT)
+		w.string(h.Error)
+	}
+}
+
+func (this *TraceResponse) unmarshal(r *reader) {
+	this.StreamID = r.string()
+	this.RequestID = r.u32()
+	n := r.length()
+	this.Hops = make([]TraceHop, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		var h TraceHop
+		h.ID = r.u32()
+		h.Server = r.bool()
+		h.Running = r.bool()
+		h.Upstream = r.addrPort()
+		h.Link = r.metrics()
+		m := r.length()
+		h.Subscribers = make([]netip.AddrPort, 0, m)
+		for j := 0; j < m && r.err == nil; j++ {
+			h.Subscribers = append(h.Subscribers, r.addrPort())
+		}
+		h.UpstreamRTT = r.duration()
+		h.Error = r.string()
+		this.Hops = append(this.Hops, h)
+	}
+}
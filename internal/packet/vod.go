@@ -0,0 +1,59 @@
+package packet
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//A viewer's own on-demand session of a stream, starting at Offset instead of joining the shared stream mid-way.
+//Its ID ("<streamID>@<session>" or "<streamID>@<session>+<offset>") is the stream ID of all its packets,
+//so nodes never merge it with the shared stream or with other sessions
+type VODSession struct {
+	StreamID string
+	Session uint32
+	Offset time.Duration
+}
+
+const vodSeparator = "@"
+
+//Creates a session with a random ID
+func NewVODSession(streamID string, offset time.Duration) VODSession {
+	return VODSession{StreamID: streamID, Session: utils.RandID(), Offset: offset}
+}
+
+func (this VODSession) ID() string {
+	id := this.StreamID + vodSeparator + strconv.FormatUint(uint64(this.Session), 16)
+	if this.Offset != 0 {
+		id += "+" + this.Offset.String()
+	}
+	return id
+}
+
+//Parses the ID of a VOD session. Returns false for the IDs of shared streams and malformed ones
+func ParseVODSession(id string) (VODSession, bool) {
+	streamID, session, found := strings.Cut(id, vodSeparator)
+	if !found {
+		return VODSession{}, false
+	}
+
+	this := VODSession{StreamID: streamID}
+	session, offset, hasOffset := strings.Cut(session, "+")
+	n, err := strconv.ParseUint(session, 16, 32)
+	if err != nil { return VODSession{}, false }
+	this.Session = uint32(n)
+
+	if hasOffset {
+		this.Offset, err = time.ParseDuration(offset)
+		if err != nil || this.Offset < 0 { return VODSession{}, false }
+	}
+	return this, true
+}
+
+//Returns the ID of the shared stream, which is the ID itself unless it names a VOD session
+func SharedStreamID(id string) string {
+	streamID, _, _ := strings.Cut(id, vodSeparator)
+	return streamID
+}
@@ -0,0 +1,43 @@
+package recovery
+
+import (
+	"sync"
+
+	"github.com/SLP25/ESR/internal/packet"
+)
+
+//Packets kept by each hop for retransmission. At a few hundred packets per second, a couple of seconds
+const BufferSize = 1024
+
+//The latest packets sent of a stream, by sequence number, so the ones lost downstream can be sent again
+type Buffer struct {
+	mutex sync.Mutex
+	packets [BufferSize]packet.StreamPacket
+	filled [BufferSize]bool
+}
+
+func NewBuffer() *Buffer {
+	return &Buffer{}
+}
+
+//Keeps the packet, replacing the oldest one
+func (this *Buffer) Add(p packet.StreamPacket) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := p.Seq % BufferSize
+	this.packets[i] = p
+	this.filled[i] = true
+}
+
+//Returns the packet with the sequence number, if it's still kept
+func (this *Buffer) Get(seq uint32) (packet.StreamPacket, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	i := seq % BufferSize
+	if !this.filled[i] || this.packets[i].Seq != seq {
+		return packet.StreamPacket{}, false
+	}
+	return this.packets[i], true
+}
@@ -0,0 +1,45 @@
+package recovery
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBufferGet(t *testing.T) {
+	buffer := NewBuffer()
+	packets := testPackets(math.MaxUint32 - 2, 5) //wrapping around
+	for _, p := range packets {
+		buffer.Add(p)
+	}
+
+	for _, want := range packets {
+		if got, ok := buffer.Get(want.Seq); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("Get(%d) = %v, %v, want the packet added", want.Seq, got.Seq, ok)
+		}
+	}
+	if _, ok := buffer.Get(3); ok {
+		t.Error("got a packet never added")
+	}
+}
+
+//A packet is replaced by the one BufferSize after it, and can't be mistaken for it
+func TestBufferReplace(t *testing.T) {
+	buffer := NewBuffer()
+	old := testPackets(10, 1)[0]
+	buffer.Add(old)
+
+	recent := testPackets(10 + BufferSize, 2)
+	for _, p := range recent {
+		buffer.Add(p)
+	}
+	if _, ok := buffer.Get(old.Seq); ok {
+		t.Error("got a packet that was replaced")
+	}
+	if got, ok := buffer.Get(10 + BufferSize); !ok || !reflect.DeepEqual(got, recent[0]) {
+		t.Error("the packet that replaced it isn't kept")
+	}
+	if _, ok := buffer.Get(11); ok {
+		t.Error("got a packet never added, from the slot of a later one")
+	}
+}
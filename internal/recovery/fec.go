@@ -0,0 +1,146 @@
+package recovery
+
+import (
+	"slices"
+
+	"github.com/SLP25/ESR/internal/packet"
+)
+
+const (
+	MaxGroup = 32			//span of sequence numbers a repair can cover (the bits of its mask)
+	maxGroupSize = 16
+	maxPending = 16			//repairs kept until enough of their packets arrive
+)
+
+//Size of the groups of packets protected by a repair on a link with the given loss.
+//A repair rebuilds a single lost packet, so the groups are small enough to lose one packet in every 4 of them
+func GroupSize(loss float64) int {
+	if loss <= 0 {
+		return maxGroupSize
+	}
+	return min(max(int(0.25 / loss), 2), maxGroupSize)
+}
+
+func xorInto(r *packet.StreamRepair, p packet.StreamPacket) {
+	r.Type ^= p.Type
+	r.Length ^= uint16(len(p.Content))
+	if len(p.Content) > len(r.Content) {
+		r.Content = append(r.Content, make([]byte, len(p.Content) - len(r.Content))...)
+	}
+	for i, b := range p.Content {
+		r.Content[i] ^= b
+	}
+}
+
+//Builds the repairs of the packets sent over a link, one for each group of consecutive packets
+type Encoder struct {
+	repair packet.StreamRepair
+	count int
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+//Adds a packet sent to the current group, returning the repair of the group once it has size packets.
+//Packets from before the group (retransmissions) aren't protected, and one too far ahead closes the group early
+func (this *Encoder) Add(p packet.StreamPacket, size int) (packet.StreamRepair, bool) {
+	var ans packet.StreamRepair
+	ready := false
+
+	if this.count != 0 {
+		offset := p.Seq - this.repair.Base
+		if !after(p.Seq, this.repair.Base) && offset != 0 {
+			return ans, false
+		} else if offset < MaxGroup && this.repair.Mask & (1 << offset) != 0 {
+			return ans, false
+		} else if offset >= MaxGroup {
+			ans, ready = this.flush()
+		}
+	}
+
+	if this.count == 0 {
+		this.repair = packet.StreamRepair{Base: p.Seq}
+	}
+	this.repair.Mask |= 1 << (p.Seq - this.repair.Base)
+	xorInto(&this.repair, p)
+	this.count++
+
+	if this.count >= size {
+		return this.flush()
+	}
+	return ans, ready
+}
+
+func (this *Encoder) flush() (packet.StreamRepair, bool) {
+	ans := this.repair
+	this.repair = packet.StreamRepair{}
+	this.count = 0
+	return ans, true
+}
+
+//Rebuilds the lost packets of a stream from the repairs received.
+//The repairs that miss more than one of their packets are kept, as retransmissions and other repairs may fill them
+type Decoder struct {
+	pending []packet.StreamRepair
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+//Keeps a repair, replacing the oldest one if too many are kept
+func (this *Decoder) Add(r packet.StreamRepair) {
+	if len(this.pending) == maxPending {
+		this.pending = slices.Delete(this.pending, 0, 1)
+	}
+	this.pending = append(this.pending, r)
+}
+
+//Returns the packets rebuilt from the repairs kept, given the packets received, to which they are added.
+//Only packets known to be lost are rebuilt: a packet not received yet may just be late, as datagrams can be reordered
+func (this *Decoder) Recover(received *Buffer, tracker *Tracker) []packet.StreamPacket {
+	var ans []packet.StreamPacket
+	for progress := true; progress; {
+		progress = false
+		kept := this.pending[:0]
+		for _, r := range this.pending {
+			p, missing := rebuild(r, received)
+			if missing == 1 && tracker.Missing(p.Seq) {
+				received.Add(p)
+				ans = append(ans, p)
+				progress = true
+			} else if missing != 0 {
+				kept = append(kept, r)
+			}
+		}
+		this.pending = kept
+	}
+	return ans
+}
+
+//Rebuilds the packet of the repair that wasn't received, if it's the only one. Returns the number of packets missing
+func rebuild(r packet.StreamRepair, received *Buffer) (packet.StreamPacket, int) {
+	acc := packet.StreamRepair{Type: r.Type, Length: r.Length, Content: slices.Clone(r.Content)}
+	missing := 0
+	var seq uint32
+	for i := uint32(0); i < MaxGroup; i++ {
+		if r.Mask & (1 << i) == 0 {
+			continue
+		}
+
+		if p, ok := received.Get(r.Base + i); ok {
+			xorInto(&acc, p)
+		} else {
+			missing++
+			seq = r.Base + i
+		}
+	}
+
+	if missing != 1 {
+		return packet.StreamPacket{}, missing
+	} else if int(acc.Length) > len(acc.Content) { //a corrupt repair, which is dropped
+		return packet.StreamPacket{}, 0
+	}
+	return packet.StreamPacket{Type: acc.Type, Seq: seq, Content: acc.Content[:acc.Length]}, 1
+}
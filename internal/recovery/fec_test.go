@@ -0,0 +1,186 @@
+package recovery
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/SLP25/ESR/internal/packet"
+)
+
+//Packets of different types and lengths, so the repairs have to rebuild both
+func testPackets(first uint32, n int) []packet.StreamPacket {
+	ans := make([]packet.StreamPacket, n)
+	for i := range ans {
+		t := packet.Video
+		if i % 3 == 0 {
+			t = packet.Audio
+		}
+		ans[i] = packet.StreamPacket{Type: t, Seq: first + uint32(i), Content: bytes.Repeat([]byte{byte(i + 1)}, 100 + 37 * i)}
+	}
+	return ans
+}
+
+//Encodes the packets in groups of the given size, returning the repairs
+func encode(packets []packet.StreamPacket, size int) []packet.StreamRepair {
+	encoder := NewEncoder()
+	var ans []packet.StreamRepair
+	for _, p := range packets {
+		if r, ok := encoder.Add(p, size); ok {
+			ans = append(ans, r)
+		}
+	}
+	return ans
+}
+
+//The receiving end of a link: what a node or client keeps of the packets from its upstream
+type receiver struct {
+	received *Buffer
+	tracker *Tracker
+	decoder *Decoder
+}
+
+func newReceiver() receiver {
+	return receiver{received: NewBuffer(), tracker: NewTracker(), decoder: NewDecoder()}
+}
+
+func (this receiver) receive(packets ...packet.StreamPacket) {
+	for _, p := range packets {
+		this.tracker.Receive(p.Seq)
+		this.received.Add(p)
+	}
+}
+
+//Receives the packets except the lost ones
+func (this receiver) receiveExcept(packets []packet.StreamPacket, lost ...uint32) {
+	for _, p := range packets {
+		if !slices.Contains(lost, p.Seq) {
+			this.receive(p)
+		}
+	}
+}
+
+func checkRebuilt(t *testing.T, got []packet.StreamPacket, want ...packet.StreamPacket) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("rebuilt %d packets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Seq != want[i].Seq || got[i].Type != want[i].Type || !bytes.Equal(got[i].Content, want[i].Content) {
+			t.Errorf("rebuilt packet %d (type %d, %d bytes), want %d (type %d, %d bytes)",
+				got[i].Seq, got[i].Type, len(got[i].Content), want[i].Seq, want[i].Type, len(want[i].Content))
+		}
+	}
+}
+
+func TestGroupSize(t *testing.T) {
+	tests := []struct {
+		loss float64
+		want int
+	}{
+		{0, maxGroupSize}, {0.001, maxGroupSize}, {0.02, 12}, {0.05, 5}, {0.1, 2}, {0.5, 2},
+	}
+	for _, test := range tests {
+		if got := GroupSize(test.loss); got != test.want {
+			t.Errorf("GroupSize(%v) = %d, want %d", test.loss, got, test.want)
+		}
+	}
+}
+
+func TestRecoverOneLoss(t *testing.T) {
+	packets := testPackets(100, 8)
+	repairs := encode(packets, 4)
+	if len(repairs) != 2 || repairs[0].Base != 100 || repairs[0].Mask != 0b1111 || repairs[1].Base != 104 {
+		t.Fatalf("repairs %+v, want 2 groups of 4 from 100", repairs)
+	}
+
+	r := newReceiver()
+	r.receiveExcept(packets, 102, 105)
+	for _, repair := range repairs {
+		r.decoder.Add(repair)
+	}
+
+	checkRebuilt(t, r.decoder.Recover(r.received, r.tracker), packets[2], packets[5])
+	if _, ok := r.received.Get(102); !ok {
+		t.Error("the rebuilt packet isn't kept with the received ones")
+	}
+	if got := r.decoder.Recover(r.received, r.tracker); len(got) != 0 {
+		t.Errorf("rebuilt %d packets again", len(got))
+	}
+}
+
+//A group that lost two packets waits until one of them is retransmitted
+func TestRecoverTwoLosses(t *testing.T) {
+	packets := testPackets(100, 4)
+	repairs := encode(packets, 4)
+
+	r := newReceiver()
+	r.receiveExcept(packets, 101, 102)
+	r.decoder.Add(repairs[0])
+
+	if got := r.decoder.Recover(r.received, r.tracker); len(got) != 0 {
+		t.Fatalf("rebuilt %d packets of a group that lost two", len(got))
+	}
+
+	r.receive(packets[1]) //the retransmission
+	checkRebuilt(t, r.decoder.Recover(r.received, r.tracker), packets[2])
+}
+
+//A packet not received yet isn't rebuilt until a later one shows it's lost, as it may just be late
+func TestRecoverOnlyLost(t *testing.T) {
+	packets := testPackets(100, 4)
+	repairs := encode(packets, 4)
+
+	r := newReceiver()
+	r.receive(packets[:3]...)
+	r.decoder.Add(repairs[0])
+	if got := r.decoder.Recover(r.received, r.tracker); len(got) != 0 {
+		t.Fatalf("rebuilt packet %d before it was known to be lost", got[0].Seq)
+	}
+
+	r.receive(testPackets(104, 1)...)
+	checkRebuilt(t, r.decoder.Recover(r.received, r.tracker), packets[3])
+}
+
+//Groups and repairs across the wraparound of the sequence numbers
+func TestRecoverWraparound(t *testing.T) {
+	packets := testPackets(math.MaxUint32 - 1, 4) //..., 2^32-2, 2^32-1, 0, 1
+	repairs := encode(packets, 4)
+	if len(repairs) != 1 || repairs[0].Base != math.MaxUint32 - 1 || repairs[0].Mask != 0b1111 {
+		t.Fatalf("repairs %+v, want one group from %d", repairs, uint32(math.MaxUint32 - 1))
+	}
+
+	for _, lost := range []int{1, 2} {
+		r := newReceiver()
+		r.receiveExcept(packets, packets[lost].Seq)
+		r.decoder.Add(repairs[0])
+		checkRebuilt(t, r.decoder.Recover(r.received, r.tracker), packets[lost])
+	}
+}
+
+func TestEncoderGroups(t *testing.T) {
+	base := uint32(math.MaxUint32 - 9)
+	encoder := NewEncoder()
+	encoder.Add(testPackets(base, 1)[0], 4)
+	encoder.Add(testPackets(base + 1, 1)[0], 4)
+
+	//a retransmission from before the group, or of a packet already in it, isn't protected
+	if _, ok := encoder.Add(testPackets(base - 1, 1)[0], 4); ok {
+		t.Error("a packet from before the group closed it")
+	}
+	if _, ok := encoder.Add(testPackets(base + 1, 1)[0], 4); ok {
+		t.Error("a packet already in the group closed it")
+	}
+
+	//a packet past the span of the mask closes the group early, and starts the next, across the wraparound
+	far := testPackets(base + MaxGroup, 1)[0]
+	r, ok := encoder.Add(far, 4)
+	if !ok || r.Base != base || r.Mask != 0b11 {
+		t.Fatalf("repair %+v (%v), want the group of 2 from %d", r, ok, base)
+	}
+	r, ok = encoder.Add(testPackets(far.Seq + 1, 1)[0], 2)
+	if !ok || r.Base != far.Seq || r.Mask != 0b11 {
+		t.Errorf("repair %+v (%v), want the group of 2 from %d", r, ok, far.Seq)
+	}
+}
@@ -0,0 +1,119 @@
+package recovery
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	window = BufferSize					//sequence numbers remembered to discard duplicates
+	maxGap = window / 2					//a bigger jump is a new numbering (e.g. a new server), not a loss
+	maxNacks = 3						//times a lost packet is asked for before giving up
+	nackInterval = 50 * time.Millisecond //between the requests of a lost packet
+)
+
+//What the reception of a packet revealed
+type Reception struct {
+	Fresh bool			//not received before, so it must be played or forwarded
+	Recovered bool		//it had been asked for again
+	Nack []uint32		//sequence numbers to ask the upstream for, now
+	Lost int			//packets given up on
+}
+
+type missing struct {
+	nacks int
+	lastNack time.Time		//or when the gap was found, if it wasn't asked for yet
+}
+
+//Detects the packets of a stream lost on the hop from the upstream, by their sequence numbers.
+//Gaps are asked for once they last nackInterval, so packets merely reordered aren't asked for,
+//and then periodically (with each packet received), until they arrive or are given up
+type Tracker struct {
+	mutex sync.Mutex
+	started bool
+	highest uint32
+	seen [window]uint32		//the sequence numbers received, by their position in the window
+	filled [window]bool
+	missing map[uint32]*missing
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{missing: make(map[uint32]*missing)}
+}
+
+//Whether a is after b, accounting for the numbering wrapping around
+func after(a uint32, b uint32) bool {
+	return int32(a - b) > 0
+}
+
+//Registers the reception of a packet
+func (this *Tracker) Receive(seq uint32) Reception {
+	return this.receive(seq, time.Now())
+}
+
+func (this *Tracker) receive(seq uint32, now time.Time) Reception {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	ans := Reception{Fresh: true}
+	diff := int32(seq - this.highest)
+
+	switch {
+		case !this.started || diff > maxGap || diff < -maxGap:
+			this.reset(seq)
+
+		case diff > 0:
+			for s := this.highest + 1; s != seq; s++ {
+				this.missing[s] = &missing{lastNack: now}
+			}
+			this.highest = seq
+
+		case this.filled[seq % window] && this.seen[seq % window] == seq:
+			return Reception{}
+
+		default:
+			_, ans.Recovered = this.missing[seq]
+			delete(this.missing, seq)
+	}
+
+	this.seen[seq % window] = seq
+	this.filled[seq % window] = true
+	ans.Nack, ans.Lost = this.due(now)
+	return ans
+}
+
+//Whether the packet is known to be lost (a later one was received) and still expected
+func (this *Tracker) Missing(seq uint32) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, ok := this.missing[seq]
+	return ok
+}
+
+//Forgets the stream, which continues at seq
+func (this *Tracker) reset(seq uint32) {
+	this.started = true
+	this.highest = seq
+	this.filled = [window]bool{}
+	clear(this.missing)
+}
+
+//Returns the missing packets to ask for again, and the number of them given up on
+func (this *Tracker) due(now time.Time) ([]uint32, int) {
+	var nack []uint32
+	lost := 0
+	for seq, m := range this.missing {
+		late := now.Sub(m.lastNack) >= nackInterval
+		if !after(seq, this.highest - maxGap) || (late && m.nacks == maxNacks) {
+			delete(this.missing, seq)
+			lost++
+		} else if late {
+			m.nacks++
+			m.lastNack = now
+			nack = append(nack, seq)
+		}
+	}
+	slices.Sort(nack)
+	return nack, lost
+}
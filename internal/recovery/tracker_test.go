@@ -0,0 +1,132 @@
+package recovery
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+//Feeds a tracker the receptions of a test, at instants of its own
+type trackerClock struct {
+	t *testing.T
+	tracker *Tracker
+	now time.Time
+}
+
+func newTrackerClock(t *testing.T) *trackerClock {
+	return &trackerClock{t: t, tracker: NewTracker(), now: time.Now()}
+}
+
+func (this *trackerClock) after(d time.Duration, seq uint32) Reception {
+	this.now = this.now.Add(d)
+	return this.tracker.receive(seq, this.now)
+}
+
+//Fails the test unless the reception asked for the given packets
+func (this *trackerClock) nacked(r Reception, want ...uint32) {
+	this.t.Helper()
+	if !slices.Equal(r.Nack, want) {
+		this.t.Errorf("asked for %v, want %v", r.Nack, want)
+	}
+}
+
+//A packet that arrives late, within nackInterval, isn't asked for
+func TestReorder(t *testing.T) {
+	clock := newTrackerClock(t)
+	clock.after(0, 10)
+
+	r := clock.after(time.Millisecond, 12)
+	clock.nacked(r)
+	if !r.Fresh || !clock.tracker.Missing(11) {
+		t.Errorf("reception %+v, want a fresh packet and 11 missing", r)
+	}
+
+	r = clock.after(10 * time.Millisecond, 11)
+	clock.nacked(r)
+	if !r.Fresh || !r.Recovered || clock.tracker.Missing(11) {
+		t.Errorf("reception of 11 %+v, want it fresh and recovered", r)
+	}
+	clock.nacked(clock.after(time.Second, 13))
+}
+
+//A gap is asked for once it lasts nackInterval, then every nackInterval until maxNacks, and then given up
+func TestNackUntilGivenUp(t *testing.T) {
+	clock := newTrackerClock(t)
+	clock.after(0, 1)
+	clock.nacked(clock.after(0, 4))
+
+	clock.nacked(clock.after(nackInterval - time.Millisecond, 5))
+	clock.nacked(clock.after(time.Millisecond, 6), 2, 3)
+	clock.nacked(clock.after(nackInterval / 2, 7))
+	for i := 1; i < maxNacks; i++ {
+		clock.nacked(clock.after(nackInterval, 7 + uint32(i)), 2, 3)
+	}
+
+	r := clock.after(nackInterval, 20)
+	if r.Lost != 2 || clock.tracker.Missing(2) || clock.tracker.Missing(3) {
+		t.Errorf("reception %+v after %d NACKs, want 2 and 3 lost", r, maxNacks)
+	}
+	//the gap of 10 to 19 is new, so it isn't asked for yet
+	clock.nacked(r)
+
+	//a packet given up on is still fresh if it shows up
+	if r := clock.after(0, 2); !r.Fresh || r.Recovered {
+		t.Errorf("reception of a packet given up on %+v, want fresh and not recovered", r)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	clock := newTrackerClock(t)
+	for _, seq := range []uint32{5, 7, 6} {
+		clock.after(0, seq)
+	}
+	for _, seq := range []uint32{5, 6, 7} {
+		if r := clock.after(0, seq); r.Fresh || r.Recovered || r.Nack != nil {
+			t.Errorf("reception of duplicate %d %+v, want nothing", seq, r)
+		}
+	}
+
+	//duplicates are told by the sequence number kept in their slot of the window, which 5 + window now takes
+	for seq := uint32(8); seq <= 5 + window; seq++ {
+		clock.after(0, seq)
+	}
+	if r := clock.after(0, 5 + window); r.Fresh {
+		t.Error("a duplicate was taken for a fresh packet")
+	}
+}
+
+//A jump of more than maxGap is a new numbering, not a loss, and the sequence numbers wrap around
+func TestGapReset(t *testing.T) {
+	clock := newTrackerClock(t)
+	clock.after(0, 100)
+	clock.after(0, 102)
+
+	r := clock.after(0, 102 + maxGap + 1)
+	if !r.Fresh || r.Lost != 0 || clock.tracker.Missing(101) || clock.tracker.Missing(103) {
+		t.Errorf("jump of more than maxGap %+v, want a fresh start with nothing missing", r)
+	}
+	clock.after(0, 102 + maxGap + 3)
+	clock.nacked(clock.after(nackInterval, 102 + maxGap + 4), 102 + maxGap + 2)
+
+	clock = newTrackerClock(t)
+	clock.after(0, math.MaxUint32 - 1)
+	clock.nacked(clock.after(0, 1))
+	clock.nacked(clock.after(nackInterval, 2), 0, math.MaxUint32)
+	if r := clock.after(0, math.MaxUint32); !r.Recovered {
+		t.Error("a packet missing before the wraparound wasn't recovered")
+	}
+}
+
+//Missing packets that fall maxGap behind the highest are given up, however few times they were asked for
+func TestMissingOutdated(t *testing.T) {
+	clock := newTrackerClock(t)
+	clock.after(0, 0)
+	clock.after(0, 2)
+	if r := clock.after(0, maxGap); r.Lost != 0 || !clock.tracker.Missing(1) {
+		t.Fatalf("reception %+v, want 1 still missing", r)
+	}
+	if r := clock.after(0, maxGap + 1); r.Lost != 1 || clock.tracker.Missing(1) {
+		t.Errorf("reception %+v, want 1 given up", r)
+	}
+}
@@ -0,0 +1,192 @@
+package rtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+//A packet of a capture, as it was received
+type CapturedPacket struct {
+	Time time.Duration	//since the start of the capture
+	Port uint16			//UDP destination port
+	Data []byte			//RTP or RTCP packet
+}
+
+var errCapture = errors.New("rtp: unknown capture format (expected rtpdump or pcap)")
+
+const rtpdumpMagic = "#!rtpplay1.0 "
+
+//Reads all the packets of an rtpdump (rtptools) or a pcap capture, detected by their magic.
+//Only UDP over IPv4 or IPv6 is read from pcaps, and IP fragments are skipped
+func ReadCapture(r io.Reader) ([]CapturedPacket, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(rtpdumpMagic))
+	if err != nil { return nil, errCapture }
+
+	if string(magic) == rtpdumpMagic {
+		return readRTPDump(br)
+	}
+	return readPcap(br)
+}
+
+//rtpdump: a text line "#!rtpplay1.0 address/port", a binary header (start time, source, port)
+//and packets prefixed by their length, the RTP length (0 for RTCP) and the offset in milliseconds
+func readRTPDump(r *bufio.Reader) ([]CapturedPacket, error) {
+	_, err := r.ReadString('\n')
+	if err != nil { return nil, err }
+
+	var header [16]byte
+	_, err = io.ReadFull(r, header[:])
+	if err != nil { return nil, fmt.Errorf("rtpdump: %w", err) }
+	port := binary.BigEndian.Uint16(header[12:])
+
+	packets := []CapturedPacket{}
+	var hdr [8]byte
+	for {
+		_, err = io.ReadFull(r, hdr[:])
+		if err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("rtpdump: %w", err)
+		}
+
+		length := int(binary.BigEndian.Uint16(hdr[0:]))
+		if length < len(hdr) {
+			return nil, fmt.Errorf("rtpdump: invalid packet length %d", length)
+		}
+
+		data := make([]byte, length - len(hdr))
+		_, err = io.ReadFull(r, data)
+		if err != nil { return nil, fmt.Errorf("rtpdump: %w", err) }
+
+		packets = append(packets, CapturedPacket{
+			Time: time.Duration(binary.BigEndian.Uint32(hdr[4:])) * time.Millisecond,
+			Port: port,
+			Data: data,
+		})
+	}
+}
+
+//Link types of pcap files
+const (
+	linkNull = 0
+	linkEthernet = 1
+	linkRaw = 101
+	linkLinuxSLL = 113
+	linkIPv4 = 228
+	linkIPv6 = 229
+	linkLinuxSLL2 = 276
+)
+
+//pcap (libpcap's classic format, not pcapng), in either byte order and with micro or nanosecond timestamps
+func readPcap(r *bufio.Reader) ([]CapturedPacket, error) {
+	var header [24]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil { return nil, errCapture }
+
+	var order binary.ByteOrder
+	var unit time.Duration
+	switch {
+		case bytes.Equal(header[:4], []byte{0xa1, 0xb2, 0xc3, 0xd4}): order, unit = binary.BigEndian, time.Microsecond
+		case bytes.Equal(header[:4], []byte{0xd4, 0xc3, 0xb2, 0xa1}): order, unit = binary.LittleEndian, time.Microsecond
+		case bytes.Equal(header[:4], []byte{0xa1, 0xb2, 0x3c, 0x4d}): order, unit = binary.BigEndian, time.Nanosecond
+		case bytes.Equal(header[:4], []byte{0x4d, 0x3c, 0xb2, 0xa1}): order, unit = binary.LittleEndian, time.Nanosecond
+		default: return nil, errCapture
+	}
+	link := order.Uint32(header[20:]) & 0xffff
+
+	packets := []CapturedPacket{}
+	var start time.Duration
+	var record [16]byte
+	for {
+		_, err = io.ReadFull(r, record[:])
+		if err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("pcap: %w", err)
+		}
+
+		data := make([]byte, order.Uint32(record[8:]))
+		_, err = io.ReadFull(r, data)
+		if err != nil { return nil, fmt.Errorf("pcap: %w", err) }
+
+		t := time.Duration(order.Uint32(record[0:])) * time.Second + time.Duration(order.Uint32(record[4:])) * unit
+		if len(packets) == 0 {
+			start = t
+		}
+
+		port, payload, ok := udpPayload(link, data)
+		if ok {
+			packets = append(packets, CapturedPacket{Time: t - start, Port: port, Data: payload})
+		}
+	}
+}
+
+//Extracts the destination port and payload of a UDP datagram from a frame of the given link type
+func udpPayload(link uint32, frame []byte) (uint16, []byte, bool) {
+	var ethertype uint16
+	switch link {
+		case linkNull:
+			if len(frame) < 4 { return 0, nil, false }
+			frame = frame[4:] //the address family, in the byte order of the capturing host
+		case linkEthernet:
+			if len(frame) < 14 { return 0, nil, false }
+			ethertype, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+			for ethertype == 0x8100 && len(frame) >= 4 { //802.1Q
+				ethertype, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+			}
+		case linkLinuxSLL:
+			if len(frame) < 16 { return 0, nil, false }
+			ethertype, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+		case linkLinuxSLL2:
+			if len(frame) < 20 { return 0, nil, false }
+			ethertype, frame = binary.BigEndian.Uint16(frame[0:]), frame[20:]
+		case linkRaw, linkIPv4, linkIPv6:
+		default:
+			return 0, nil, false
+	}
+
+	if ethertype != 0 && ethertype != 0x0800 && ethertype != 0x86dd {
+		return 0, nil, false
+	} else if len(frame) == 0 {
+		return 0, nil, false
+	}
+
+	var udp []byte
+	switch frame[0] >> 4 {
+		case 4:
+			ihl := int(frame[0] & 0x0f) * 4
+			if len(frame) < 20 || ihl < 20 || len(frame) < ihl || frame[9] != 17 {
+				return 0, nil, false
+			}
+			if binary.BigEndian.Uint16(frame[6:]) & 0x3fff != 0 { //more fragments or a fragment offset
+				return 0, nil, false
+			}
+			total := int(binary.BigEndian.Uint16(frame[2:]))
+			if total >= ihl && total <= len(frame) {
+				frame = frame[:total] //drops the link layer padding
+			}
+			udp = frame[ihl:]
+		case 6:
+			if len(frame) < 40 || frame[6] != 17 { //extension headers aren't followed
+				return 0, nil, false
+			}
+			udp = frame[40:]
+		default:
+			return 0, nil, false
+	}
+
+	if len(udp) < 8 {
+		return 0, nil, false
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) { //truncated by the capture
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(udp[2:]), udp[8:length], true
+}
@@ -0,0 +1,118 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//Static payload type of JPEG video (RFC 3551)
+const JPEGPayloadType = 26
+
+var errJPEG = errors.New("rtp: unsupported JPEG")
+
+//Splits JPEG frames into RTP/JPEG packets (RFC 2435).
+//Frames must be baseline 4:2:0 with the standard Huffman tables, which is what image/jpeg encodes
+type JPEGPacketizer struct {
+	SSRC uint32
+	Sequence uint16		//of the next packet
+	MaxPayload int		//bytes of payload per packet, headers included
+}
+
+//The parts of a JPEG file RTP/JPEG carries
+type jpegFrame struct {
+	width int
+	height int
+	tables []byte	//the quantization tables, in zig-zag order
+	scan []byte		//entropy coded data
+}
+
+func parseJPEG(b []byte) (jpegFrame, error) {
+	var f jpegFrame
+	if len(b) < 4 || b[0] != 0xff || b[1] != 0xd8 {
+		return f, errJPEG
+	}
+
+	for i := 2; i + 4 <= len(b); {
+		if b[i] != 0xff {
+			return f, errJPEG
+		}
+		marker := b[i + 1]
+		length := int(binary.BigEndian.Uint16(b[i + 2:]))
+		if i + 2 + length > len(b) {
+			return f, errJPEG
+		}
+		segment := b[i + 4 : i + 2 + length]
+
+		switch marker {
+		case 0xdb: //DQT: 8 bit tables only
+			for len(segment) >= 65 {
+				if segment[0] >> 4 != 0 {
+					return f, errJPEG
+				}
+				f.tables = append(f.tables, segment[1:65]...)
+				segment = segment[65:]
+			}
+
+		case 0xc0: //SOF0, baseline
+			if len(segment) < 5 {
+				return f, errJPEG
+			}
+			f.height = int(binary.BigEndian.Uint16(segment[1:]))
+			f.width = int(binary.BigEndian.Uint16(segment[3:]))
+
+		case 0xc1, 0xc2, 0xc3, 0xdd: //other processes, restart intervals
+			return f, errJPEG
+
+		case 0xda: //SOS: the scan runs up to the EOI marker
+			end := len(b)
+			if end >= 2 && b[end - 2] == 0xff && b[end - 1] == 0xd9 {
+				end -= 2
+			}
+			f.scan = b[i + 2 + length : end]
+
+			if f.width == 0 || f.width > 2040 || f.height > 2040 || f.width % 8 != 0 || f.height % 8 != 0 || len(f.tables) != 128 {
+				return f, errJPEG
+			}
+			return f, nil
+		}
+
+		i += 2 + length
+	}
+	return f, errJPEG
+}
+
+//Returns the RTP packets of the frame. The marker bit is set on the last one
+func (this *JPEGPacketizer) Packetize(frame []byte, timestamp uint32) ([][]byte, error) {
+	f, err := parseJPEG(frame)
+	if err != nil { return nil, err }
+
+	maxPayload := this.MaxPayload
+	if maxPayload <= 0 {
+		maxPayload = 1200
+	}
+
+	ans := [][]byte{}
+	for offset := 0; offset < len(f.scan); {
+		h := Header{PayloadType: JPEGPayloadType, Sequence: this.Sequence, Timestamp: timestamp, SSRC: this.SSRC}
+		this.Sequence++
+
+		//main JPEG header: type 1 (4:2:0), Q 255 (tables in the first packet)
+		payload := []byte{0, byte(offset >> 16), byte(offset >> 8), byte(offset), 1, 255, byte(f.width / 8), byte(f.height / 8)}
+		if offset == 0 {
+			payload = append(payload, 0, 0)
+			payload = binary.BigEndian.AppendUint16(payload, uint16(len(f.tables)))
+			payload = append(payload, f.tables...)
+		}
+
+		n := min(len(f.scan) - offset, maxPayload - len(payload))
+		if n <= 0 {
+			return nil, errors.New("rtp: maximum payload too small for the JPEG headers")
+		}
+		payload = append(payload, f.scan[offset : offset + n]...)
+		offset += n
+
+		h.Marker = offset == len(f.scan)
+		ans = append(ans, append(h.Append(make([]byte, 0, 12 + len(payload))), payload...))
+	}
+	return ans, nil
+}
@@ -0,0 +1,109 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//Clock rate of video payloads
+const VideoClockRate = 90000
+
+var errShortPacket = errors.New("rtp: packet too short")
+var errVersion = errors.New("rtp: not an RTP version 2 packet")
+
+//The fixed header of an RTP packet (RFC 3550). CSRCs and extensions are skipped when parsing and never written
+type Header struct {
+	Marker bool
+	PayloadType uint8
+	Sequence uint16
+	Timestamp uint32
+	SSRC uint32
+}
+
+//Appends the header to the buffer
+func (this Header) Append(b []byte) []byte {
+	second := this.PayloadType & 0x7f
+	if this.Marker {
+		second |= 0x80
+	}
+
+	b = append(b, 2 << 6, second)
+	b = binary.BigEndian.AppendUint16(b, this.Sequence)
+	b = binary.BigEndian.AppendUint32(b, this.Timestamp)
+	return binary.BigEndian.AppendUint32(b, this.SSRC)
+}
+
+//Parses an RTP packet, returning its header and payload
+func Parse(b []byte) (Header, []byte, error) {
+	if len(b) < 12 {
+		return Header{}, nil, errShortPacket
+	} else if b[0] >> 6 != 2 {
+		return Header{}, nil, errVersion
+	}
+
+	h := Header{
+		Marker: b[1] & 0x80 != 0,
+		PayloadType: b[1] & 0x7f,
+		Sequence: binary.BigEndian.Uint16(b[2:]),
+		Timestamp: binary.BigEndian.Uint32(b[4:]),
+		SSRC: binary.BigEndian.Uint32(b[8:]),
+	}
+
+	offset := 12 + 4 * int(b[0] & 0x0f) //CSRCs
+	if b[0] & 0x10 != 0 { //extension
+		if len(b) < offset + 4 {
+			return Header{}, nil, errShortPacket
+		}
+		offset += 4 + 4 * int(binary.BigEndian.Uint16(b[offset + 2:]))
+	}
+
+	end := len(b)
+	if b[0] & 0x20 != 0 && end > 0 { //padding
+		end -= int(b[end - 1])
+	}
+
+	if offset > end {
+		return Header{}, nil, errShortPacket
+	}
+	return h, b[offset:end], nil
+}
+
+//Whether the packet is RTCP rather than RTP, when both share a port (RFC 5761)
+func IsRTCP(b []byte) bool {
+	return len(b) >= 2 && b[1] >= 192 && b[1] <= 223
+}
+
+//A payload format, as in the rtpmap attribute of a session description
+type PayloadFormat struct {
+	Media string		//"audio" or "video"
+	Encoding string		//<name>/<clock rate>[/<channels>], e.g. "H264/90000"
+}
+
+//The clock rate of the encoding, or 0 if it's invalid
+func (this PayloadFormat) ClockRate() int {
+	parts := strings.Split(this.Encoding, "/")
+	if len(parts) < 2 {
+		return 0
+	}
+	rate, _ := strconv.Atoi(parts[1])
+	return rate
+}
+
+//The static payload types of RFC 3551. Dynamic ones (96-127) are only known from a session description
+var StaticPayloadTypes = map[uint8]PayloadFormat{
+	0: {"audio", "PCMU/8000"},
+	3: {"audio", "GSM/8000"},
+	4: {"audio", "G723/8000"},
+	8: {"audio", "PCMA/8000"},
+	9: {"audio", "G722/8000"},
+	10: {"audio", "L16/44100/2"},
+	11: {"audio", "L16/44100"},
+	14: {"audio", "MPA/90000"},
+	26: {"video", "JPEG/90000"},
+	31: {"video", "H261/90000"},
+	32: {"video", "MPV/90000"},
+	33: {"video", "MP2T/90000"},
+	34: {"video", "H263/90000"},
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+    "net/netip"
+    "time"
+
+    "github.com/SLP25/ESR/internal/admin"
+    "github.com/SLP25/ESR/internal/utils"
+)
+
+type streamStatus struct {
+    Metadata utils.StreamMetadata
+    Position time.Duration     //moment of the source currently transmitted
+    Running bool               //whether the source is running (there are subscribers)
+    Paused bool                //whether the sole subscriber paused the stream
+    Subscribers []netip.AddrPort
+    Health string              //healthy, degraded (the source failed recently) or failed
+    Failures int               //consecutive failures of the source
+    LastError string           //of the last failure
+}
+
+//Serves the hosted streams on the admin API
+func (this *Server) RegisterAdmin(a *admin.Server) {
+    a.HandleService(&this.serv, this.registry)
+    a.Handle("/streams", this.streamsStatus)
+    a.Handle("/sessions", this.sessionsStatus)
+}
+
+func (this *Server) streamsStatus() any {
+    shared := this.sharedStreams()
+    ans := make(map[string]streamStatus, len(shared))
+    for streamID, s := range shared {
+        ans[streamID] = s.status()
+    }
+    return ans
+}
+
+//The running VOD sessions, by ID
+func (this *Server) sessionsStatus() any {
+    this.sessionsMutex.Lock()
+    sessions := make(map[string]*stream, len(this.sessions))
+    for id, s := range this.sessions {
+        sessions[id] = s
+    }
+    this.sessionsMutex.Unlock()
+
+    ans := make(map[string]streamStatus, len(sessions))
+    for id, s := range sessions {
+        ans[id] = s.status()
+    }
+    return ans
+}
+
+func (this *stream) status() streamStatus {
+    this.mutex.Lock()
+    running, paused, position := this.running(), this.paused, this.currentTime()
+    this.mutex.Unlock()
+
+    health := this.health()
+    this.healthMutex.Lock()
+    failures, lastError := this.consecutiveFailures, this.lastError
+    this.healthMutex.Unlock()
+
+    return streamStatus{
+        Metadata: this.metadata,
+        Position: position,
+        Running: running,
+        Paused: paused,
+        Subscribers: this.getSubscribers(),
+        Health: health.String(),
+        Failures: failures,
+        LastError: lastError,
+    }
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/rtp"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+//An RTP session recorded in an rtpdump or pcap file, replayed in Go without ffmpeg.
+//Packets are paced by their RTP timestamps and forwarded as they were captured,
+//and the session description is generated from the payload types found
+type dumpSource struct {
+	filepath string
+	loop bool
+	formats map[uint8]rtp.PayloadFormat	//of the dynamic payload types
+
+	mutex sync.Mutex
+	schedule []scheduledPacket			//loaded by the first Probe
+	tracks []*dumpTrack
+	duration time.Duration
+}
+
+//An RTP stream (SSRC) of the capture
+type dumpTrack struct {
+	payloadType uint8
+	format rtp.PayloadFormat
+	clockRate int
+	packets uint16			//sequence numbers advanced by every loop
+	bytes int
+}
+
+type scheduledPacket struct {
+	at time.Duration	//since the start of the capture
+	track *dumpTrack
+	control bool
+	data []byte
+}
+
+//Formats maps the dynamic payload types to their format, since a capture doesn't describe them
+func NewDumpSource(filepath string, loop bool, formats map[uint8]rtp.PayloadFormat) Source {
+	return &dumpSource{filepath: filepath, loop: loop, formats: formats}
+}
+
+func (this *dumpSource) format(pt uint8) (rtp.PayloadFormat, error) {
+	format, ok := this.formats[pt]
+	if !ok {
+		format, ok = rtp.StaticPayloadTypes[pt]
+	}
+	if !ok || format.ClockRate() == 0 {
+		return format, fmt.Errorf("unknown format of payload type %d in %s", pt, this.filepath)
+	}
+	return format, nil
+}
+
+//Reads the capture and orders its packets by the moment they are to be sent
+func (this *dumpSource) load() error {
+	f, err := os.Open(this.filepath)
+	if err != nil { return err }
+	defer f.Close()
+
+	captured, err := rtp.ReadCapture(f)
+	if err != nil { return fmt.Errorf("%s: %w", this.filepath, err) }
+
+	type timeline struct {
+		start time.Duration		//capture time of the first packet
+		first uint32
+		last uint32
+		elapsed int64			//in units of the clock, unwrapped
+	}
+	tracks := map[uint32]*dumpTrack{}
+	timelines := map[uint32]*timeline{}
+	ports := map[uint16]*dumpTrack{}
+	this.schedule, this.tracks = nil, nil
+
+	for _, p := range captured {
+		if rtp.IsRTCP(p.Data) {
+			//sent at the time it was captured, for the media of the RTP on the previous (or the same) port
+			track := ports[p.Port - 1]
+			if track == nil {
+				track = ports[p.Port]
+			}
+			if track != nil {
+				this.schedule = append(this.schedule, scheduledPacket{at: p.Time, track: track, control: true, data: p.Data})
+			}
+			continue
+		}
+
+		h, _, err := rtp.Parse(p.Data)
+		if err != nil { continue } //other UDP traffic
+
+		track := tracks[h.SSRC]
+		if track == nil {
+			format, err := this.format(h.PayloadType)
+			if err != nil { return err }
+
+			track = &dumpTrack{payloadType: h.PayloadType, format: format, clockRate: format.ClockRate()}
+			tracks[h.SSRC] = track
+			timelines[h.SSRC] = &timeline{start: p.Time, first: h.Timestamp, last: h.Timestamp}
+			this.tracks = append(this.tracks, track)
+		}
+		ports[p.Port] = track
+
+		t := timelines[h.SSRC]
+		t.elapsed += int64(int32(h.Timestamp - t.last))
+		t.last = h.Timestamp
+
+		track.packets++
+		track.bytes += len(p.Data)
+		at := t.start + time.Duration(t.elapsed) * time.Second / time.Duration(track.clockRate)
+		this.schedule = append(this.schedule, scheduledPacket{at: max(0, at), track: track, data: p.Data})
+	}
+
+	if len(this.tracks) == 0 {
+		return fmt.Errorf("no RTP packets in %s", this.filepath)
+	}
+
+	sort.SliceStable(this.schedule, func(i, j int) bool { return this.schedule[i].at < this.schedule[j].at })
+
+	//the last packet is followed by the mean gap between packets before looping
+	last := this.schedule[len(this.schedule) - 1].at
+	this.duration = last
+	if len(this.schedule) > 1 {
+		this.duration += last / time.Duration(len(this.schedule) - 1)
+	}
+	this.duration = max(this.duration, time.Millisecond)
+	return nil
+}
+
+func (this *dumpSource) Probe() (utils.StreamMetadata, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	err := this.load()
+	if err != nil { return utils.StreamMetadata{}, err }
+
+	metadata := utils.StreamMetadata{Duration: this.duration}
+	for _, t := range this.tracks {
+		bitrate := int(float64(t.bytes * 8) / this.duration.Seconds())
+		metadata.Bitrate += bitrate
+
+		name, _, _ := strings.Cut(t.format.Encoding, "/")
+		track := utils.TrackMetadata{Kind: t.format.Media, Codec: strings.ToLower(name), Bitrate: bitrate}
+		if track.Kind == utils.AudioTrack {
+			parts := strings.Split(t.format.Encoding, "/")
+			track.SampleRate, track.Channels = t.clockRate, 1
+			if len(parts) > 2 {
+				track.Channels, _ = strconv.Atoi(parts[2])
+			}
+		}
+		metadata.Tracks = append(metadata.Tracks, track)
+	}
+	return metadata, nil
+}
+
+func (this *dumpSource) description() sdp.SessionDescription {
+	session := sdp.SessionDescription{
+		Origin: sdp.Origin{Username: "-", NetworkType: "IN", AddressType: "IP4", UnicastAddress: "127.0.0.1"},
+		SessionName: sdp.SessionName(this.filepath),
+		ConnectionInformation: &sdp.ConnectionInformation{NetworkType: "IN", AddressType: "IP4", Address: &sdp.Address{Address: "127.0.0.1"}},
+		TimeDescriptions: []sdp.TimeDescription{{}},
+	}
+	for _, t := range this.tracks {
+		pt := strconv.Itoa(int(t.payloadType))
+		session.WithMedia(&sdp.MediaDescription{
+			MediaName: sdp.MediaName{Media: t.format.Media, Port: sdp.RangedPort{Value: 0}, Protos: []string{"RTP", "AVP"}, Formats: []string{pt}},
+			Attributes: []sdp.Attribute{{Key: "rtpmap", Value: pt + " " + t.format.Encoding}},
+		})
+	}
+	return session
+}
+
+//Every loop advances the sequence numbers and timestamps, so the receivers see a single continuous stream
+func rewriteLoop(p scheduledPacket, loop int, duration time.Duration) []byte {
+	if loop == 0 || p.control {
+		return p.data
+	}
+
+	b := append([]byte{}, p.data...)
+	ticks := int64(duration) * int64(p.track.clockRate) / int64(time.Second)
+	binary.BigEndian.PutUint16(b[2:], binary.BigEndian.Uint16(b[2:]) + uint16(loop) * p.track.packets)
+	binary.BigEndian.PutUint32(b[4:], binary.BigEndian.Uint32(b[4:]) + uint32(int64(loop) * ticks))
+	return b
+}
+
+//Without looping, the source ends after the last packet
+func (this *dumpSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.schedule == nil {
+		err := this.load()
+		if err != nil { return sdp.SessionDescription{}, nil, err }
+	}
+	schedule, duration := this.schedule, this.duration
+
+	if this.loop {
+		offset %= duration
+	}
+	i := sort.Search(len(schedule), func(i int) bool { return schedule[i].at >= offset })
+
+	done := make(chan error, 1)
+	go func() {
+		start := time.Now().Add(-offset)
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		<-timer.C
+
+		for loop := 0; ; i++ {
+			if i == len(schedule) {
+				if !this.loop {
+					done <- nil
+					return
+				}
+				i, loop = 0, loop + 1
+				start = start.Add(duration)
+			}
+
+			p := schedule[i]
+			if wait := time.Until(start.Add(p.at)); wait > 0 {
+				timer.Reset(wait)
+				select {
+					case <-timer.C:
+					case <-stop: return
+				}
+			}
+
+			select {
+				case out <- packet.StreamPacket{Type: streamType(p.track.format.Media, p.control), Content: rewriteLoop(p, loop, duration)}:
+				case <-stop: return
+			}
+		}
+	}()
+
+	return this.description(), done, nil
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/rtp"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+//RTP feeds pushed to local UDP ports by an external sender (a camera, an encoder, ffmpeg -f rtp, ...).
+//The session description says which ports receive which media, and is handed to the subscribers as is.
+//The RTCP of each media is received on the next port, unless it's multiplexed on the same one
+type rtpSource struct {
+	session sdp.SessionDescription
+	bitrate int	//0 to take it from the session's b=AS lines
+}
+
+//A single RTP feed received on the given port.
+//The codec is the encoding of the rtpmap attribute, e.g. "H264/90000" or "opus/48000/2"
+func NewRTPSource(port uint16, media string, payloadType uint8, codec string, bitrate int) Source {
+	session := sdp.SessionDescription{
+		Origin: sdp.Origin{Username: "-", NetworkType: "IN", AddressType: "IP4", UnicastAddress: "127.0.0.1"},
+		SessionName: "rtp",
+		ConnectionInformation: &sdp.ConnectionInformation{NetworkType: "IN", AddressType: "IP4", Address: &sdp.Address{Address: "127.0.0.1"}},
+		TimeDescriptions: []sdp.TimeDescription{{}},
+	}
+	pt := strconv.Itoa(int(payloadType))
+	session.WithMedia(&sdp.MediaDescription{
+		MediaName: sdp.MediaName{Media: media, Port: sdp.RangedPort{Value: int(port)}, Protos: []string{"RTP", "AVP"}, Formats: []string{pt}},
+		Attributes: []sdp.Attribute{{Key: "rtpmap", Value: pt + " " + codec}},
+	})
+	return &rtpSource{session: session, bitrate: bitrate}
+}
+
+//The RTP feeds described by the session, received on the ports of its media
+func NewSDPSource(session sdp.SessionDescription, bitrate int) Source {
+	return &rtpSource{session: session, bitrate: bitrate}
+}
+
+//Reads the session description of NewSDPSource from a file
+func ReadSDPSource(filepath string, bitrate int) (Source, error) {
+	txt, err := os.ReadFile(filepath)
+	if err != nil { return nil, err }
+
+	var session sdp.SessionDescription
+	err = session.Unmarshal(txt)
+	if err != nil { return nil, fmt.Errorf("invalid session description %s: %w", filepath, err) }
+
+	return NewSDPSource(session, bitrate), nil
+}
+
+func (this *rtpSource) Probe() (utils.StreamMetadata, error) {
+	var metadata utils.StreamMetadata
+
+	for _, m := range this.session.MediaDescriptions {
+		t := utils.TrackMetadata{Kind: m.MediaName.Media}
+		if rtpmap, ok := m.Attribute("rtpmap"); ok {
+			//"<payload type> <encoding>/<clock rate>[/<channels>]"
+			_, encoding, _ := strings.Cut(rtpmap, " ")
+			parts := strings.Split(encoding, "/")
+			t.Codec = strings.ToLower(parts[0])
+			if t.Kind == utils.AudioTrack && len(parts) > 1 {
+				t.SampleRate, _ = strconv.Atoi(parts[1])
+				t.Channels = 1
+				if len(parts) > 2 {
+					t.Channels, _ = strconv.Atoi(parts[2])
+				}
+			}
+		}
+
+		for _, b := range m.Bandwidth {
+			if b.Type == "AS" {
+				t.Bitrate = int(b.Bandwidth) * 1000
+			}
+		}
+
+		metadata.Bitrate += t.Bitrate
+		metadata.Tracks = append(metadata.Tracks, t)
+	}
+
+	if this.bitrate != 0 {
+		metadata.Bitrate = this.bitrate
+	}
+	return metadata, nil
+}
+
+//Returns the port the RTCP of the media is received on, or 0 if it's multiplexed with the RTP
+func rtcpPort(m *sdp.MediaDescription) uint16 {
+	if _, ok := m.Attribute("rtcp-mux"); ok {
+		return 0
+	}
+	if v, ok := m.Attribute("rtcp"); ok {
+		port, _, _ := strings.Cut(v, " ")
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			return uint16(p)
+		}
+	}
+	return uint16(m.MediaName.Port.Value + 1)
+}
+
+//The type of the stream packets of a media, for RTP or RTCP
+func streamType(media string, control bool) packet.StreamType {
+	switch {
+		case media == utils.VideoTrack && !control: return packet.Video
+		case media == utils.VideoTrack: return packet.VideoControl
+		case !control: return packet.Audio
+		default: return packet.AudioControl
+	}
+}
+
+//Offsets don't apply: a live feed is always received from its current point
+func (this *rtpSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	servers := []*service.UDPServer{}
+	closeServers := func() {
+		for _, s := range servers {
+			s.Close()
+			go func(s *service.UDPServer) {
+				for range s.Output() {} //unblocks the reader of the socket
+			}(s)
+		}
+	}
+
+	listen := func(port uint16, media string, control bool) error {
+		server := &service.UDPServer{}
+		err := server.Open(&port)
+		if err != nil { return err }
+		servers = append(servers, server)
+
+		go func() {
+			for {
+				var p packet.StreamPacket
+				select {
+					case msg, ok := <-server.Output():
+						if !ok { return }
+						p = packet.StreamPacket{Type: streamType(media, control || rtp.IsRTCP(msg.Data)), Content: msg.Data}
+					case <-stop:
+						return
+				}
+
+				select {
+					case out <- p:
+					case <-stop:
+						return
+				}
+			}
+		}()
+		return nil
+	}
+
+	for _, m := range this.session.MediaDescriptions {
+		err := listen(uint16(m.MediaName.Port.Value), m.MediaName.Media, false)
+		if err == nil {
+			if port := rtcpPort(m); port != 0 {
+				err = listen(port, m.MediaName.Media, true)
+			}
+		}
+
+		if err != nil {
+			closeServers()
+			return sdp.SessionDescription{}, nil, fmt.Errorf("unable to receive the %s feed: %w", m.MediaName.Media, err)
+		}
+	}
+
+	go func() {
+		<-stop
+		closeServers()
+	}()
+
+	return this.session, nil, nil
+}
+
+
+//MPEG-TS read from the server's standard input or a named pipe, packetized by ffmpeg
+type pipeSource struct {
+	filepath string	//"" for the standard input
+	bitrate int
+}
+
+func NewPipeSource(filepath string, bitrate int) Source {
+	return &pipeSource{filepath: filepath, bitrate: bitrate}
+}
+
+//A pipe can't be probed without consuming it, so only the configured bitrate is known
+func (this *pipeSource) Probe() (utils.StreamMetadata, error) {
+	return utils.StreamMetadata{Bitrate: this.bitrate}, nil
+}
+
+//Offsets don't apply: the pipe is read from its current point.
+//Opening a named pipe blocks until a writer opens it. The end of the standard input is the end of the stream,
+//while a named pipe is reopened (for the next writer) when its writer closes it
+func (this *pipeSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	var input io.Reader = os.Stdin
+	if this.filepath != "" {
+		f, err := os.Open(this.filepath)
+		if err != nil { return sdp.SessionDescription{}, nil, err }
+
+		go func() {
+			<-stop
+			f.Close()
+		}()
+		input = f
+	}
+
+	return startFFmpeg([]string{"-re", "-f", "mpegts", "-i", "pipe:0"}, input, this.filepath == "", out, stop)
+}
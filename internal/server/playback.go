@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+)
+
+//Applies a playback request from a subscriber of the stream.
+//Only its sole subscriber can control a stream, and live streams can't be controlled
+func (this *Server) playback(req packet.PlaybackRequest, from netip.Addr) packet.PlaybackResponse {
+	s, ok := this.stream(req.StreamID)
+	if !ok {
+		return req.Refuse("stream not hosted by the server")
+	}
+
+	subscribers := s.getSubscribers()
+	if !slices.ContainsFunc(subscribers, func(sub netip.AddrPort) bool { return sub.Addr() == from }) {
+		return req.Refuse("not subscribed to the stream")
+	}
+
+	if req.Action != packet.Status {
+		if len(subscribers) != 1 {
+			return req.Refuse("the stream is shared with other viewers")
+		} else if s.metadata.Duration == 0 {
+			return req.Refuse("live streams can't be controlled")
+		}
+	}
+
+	var err error
+	switch req.Action {
+		case packet.Status:
+		case packet.Seek:
+			if req.Offset < 0 || req.Offset >= s.metadata.Duration {
+				err = errors.New("offset beyond the stream")
+			} else {
+				err = s.moveCurrentTime(req.Offset)
+			}
+		case packet.Skip:
+			s.mutex.Lock()
+			target := s.currentTime() + req.Offset
+			s.mutex.Unlock()
+			err = s.moveCurrentTime(min(max(0, target), s.metadata.Duration - time.Millisecond))
+		case packet.Pause:
+			s.pause()
+		case packet.Resume:
+			err = s.resume()
+		default:
+			return req.Refuse("unknown action %s", req.Action)
+	}
+
+	if err != nil {
+		return req.Refuse("%s failed: %s", req.Action, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return req.Respond(s.currentTime(), s.metadata.Duration, s.paused)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+)
+
+//A server hosting the stream "movie" of a minute, with clientB subscribed to it
+func newPlaybackServer(t *testing.T) (*Server, *fakeSource, fakeRun) {
+	server := New()
+	server.serv.SetTransport(service.NewMemNetwork().Host(serverHost))
+	source := newFakeSource(time.Minute)
+	if err := server.AddStream("movie", source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.subscribe("movie", clientB); err != nil {
+		t.Fatal(err)
+	}
+	return server, source, source.next(t, time.Second)
+}
+
+//Sends a playback request from clientB, failing the test if it's refused
+func control(t *testing.T, server *Server, action packet.PlaybackAction, offset time.Duration) packet.PlaybackResponse {
+	t.Helper()
+	resp := server.playback(packet.PlaybackRequest{StreamID: "movie", Action: action, Offset: offset}, clientB.Addr())
+	if resp.Error != "" {
+		t.Fatalf("%s refused: %s", action, resp.Error)
+	} else if resp.Duration != time.Minute {
+		t.Errorf("%s reported a duration of %v, want 1m", action, resp.Duration)
+	}
+	return resp
+}
+
+//Fails the test unless the position is want, give or take the time the test takes to get there
+func checkPosition(t *testing.T, what string, position time.Duration, want time.Duration) {
+	t.Helper()
+	if position < want || position > want + 200 * time.Millisecond {
+		t.Errorf("%s at %v, want %v", what, position, want)
+	}
+}
+
+//Pausing stops the source and holds the position, which seeks and skips then move.
+//Resuming starts the source from there
+func TestPauseResume(t *testing.T) {
+	server, source, run := newPlaybackServer(t)
+
+	paused := control(t, server, packet.Pause, 0)
+	if !paused.Paused || !run.stopped(time.Second) {
+		t.Fatal("pausing didn't stop the source")
+	}
+	checkPosition(t, "paused", paused.Position, 0)
+	time.Sleep(50 * time.Millisecond)
+	if resp := control(t, server, packet.Status, 0); !resp.Paused || resp.Position != paused.Position {
+		t.Errorf("paused stream at %v (paused %v), want still at %v", resp.Position, resp.Paused, paused.Position)
+	}
+	if resp := control(t, server, packet.Pause, 0); resp.Position != paused.Position {
+		t.Errorf("pausing again moved the stream to %v", resp.Position)
+	}
+
+	if resp := control(t, server, packet.Seek, 30 * time.Second); !resp.Paused || resp.Position != 30 * time.Second {
+		t.Errorf("seeking while paused went to %v (paused %v), want 30s", resp.Position, resp.Paused)
+	}
+	if resp := control(t, server, packet.Skip, -10 * time.Second); !resp.Paused || resp.Position != 20 * time.Second {
+		t.Errorf("skipping while paused went to %v (paused %v), want 20s", resp.Position, resp.Paused)
+	}
+	source.noRun(t, 20 * time.Millisecond)
+
+	resumed := control(t, server, packet.Resume, 0)
+	if resumed.Paused {
+		t.Error("still paused after resuming")
+	}
+	checkPosition(t, "resumed", resumed.Position, 20 * time.Second)
+	run = source.next(t, time.Second)
+	checkPosition(t, "source resumed", run.offset, 20 * time.Second)
+
+	//resuming a playing stream changes nothing
+	control(t, server, packet.Resume, 0)
+	source.noRun(t, 20 * time.Millisecond)
+	if run.stopped(0) {
+		t.Error("resuming a playing stream stopped its source")
+	}
+}
+
+//Seeking and skipping a playing stream restart its source at the new position
+func TestSeekSkip(t *testing.T) {
+	server, source, run := newPlaybackServer(t)
+
+	checkPosition(t, "seeked", control(t, server, packet.Seek, 40 * time.Second).Position, 40 * time.Second)
+	if !run.stopped(time.Second) {
+		t.Fatal("seeking didn't stop the source")
+	}
+	run = source.next(t, time.Second)
+	checkPosition(t, "source seeked", run.offset, 40 * time.Second)
+
+	checkPosition(t, "skipped", control(t, server, packet.Skip, 5 * time.Second).Position, 45 * time.Second)
+	run.stopped(time.Second)
+	run = source.next(t, time.Second)
+	checkPosition(t, "source skipped", run.offset, 45 * time.Second)
+
+	//skips are clamped to the stream
+	checkPosition(t, "skipped back", control(t, server, packet.Skip, -time.Hour).Position, 0)
+	run.stopped(time.Second)
+	checkPosition(t, "source skipped back", source.next(t, time.Second).offset, 0)
+
+	resp := server.playback(packet.PlaybackRequest{StreamID: "movie", Action: packet.Seek, Offset: time.Minute}, clientB.Addr())
+	if resp.Error == "" {
+		t.Error("seeked beyond the stream")
+	}
+	source.noRun(t, 20 * time.Millisecond)
+}
+
+//Only the sole subscriber of a stream controls it, though anyone subscribed may ask its status
+func TestPlaybackRefused(t *testing.T) {
+	server, _, _ := newPlaybackServer(t)
+
+	if resp := server.playback(packet.PlaybackRequest{StreamID: "movie", Action: packet.Status}, clientC.Addr()); resp.Error == "" {
+		t.Error("a remote not subscribed got the status")
+	}
+	if resp := server.playback(packet.PlaybackRequest{StreamID: "other", Action: packet.Status}, clientB.Addr()); resp.Error == "" {
+		t.Error("got the status of a stream not hosted")
+	}
+
+	server.subscribe("movie", clientC)
+	if resp := server.playback(packet.PlaybackRequest{StreamID: "movie", Action: packet.Pause}, clientB.Addr()); resp.Error == "" {
+		t.Error("paused a shared stream")
+	}
+	if resp := server.playback(packet.PlaybackRequest{StreamID: "movie", Action: packet.Status}, clientC.Addr()); resp.Error != "" {
+		t.Errorf("status refused to a subscriber: %s", resp.Error)
+	}
+
+	server.AddStream("live", newFakeSource(0))
+	server.subscribe("live", clientB)
+	if resp := server.playback(packet.PlaybackRequest{StreamID: "live", Action: packet.Pause}, clientB.Addr()); resp.Error == "" {
+		t.Error("paused a live stream")
+	}
+}
+
+//A paused stream resumes where it was paused once it gains another subscriber
+func TestPausedStreamShared(t *testing.T) {
+	server, source, _ := newPlaybackServer(t)
+	control(t, server, packet.Seek, 30 * time.Second)
+	source.next(t, time.Second)
+	control(t, server, packet.Pause, 0)
+
+	time.Sleep(50 * time.Millisecond)
+	server.subscribe("movie", clientC)
+	checkPosition(t, "source resumed", source.next(t, time.Second).offset, 30 * time.Second)
+	if resp := control(t, server, packet.Status, 0); resp.Paused {
+		t.Error("the stream is still paused with two subscribers")
+	}
+}
+
+//Pausing while a failed source waits to be restarted cancels the restart
+func TestPauseDuringRestart(t *testing.T) {
+	server, source, run := newPlaybackServer(t)
+	run.done <- errors.New("crashed")
+	time.Sleep(100 * time.Millisecond)
+
+	paused := control(t, server, packet.Pause, 0)
+	source.noRun(t, 1200 * time.Millisecond)
+
+	resumed := control(t, server, packet.Resume, 0)
+	checkPosition(t, "source resumed", source.next(t, time.Second).offset, paused.Position)
+	checkPosition(t, "resumed", resumed.Position, paused.Position)
+}
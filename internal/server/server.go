@@ -0,0 +1,321 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/netip"
+	"reflect"
+	"sync"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+
+type Server struct {
+    serv service.Service
+    registry *exporter.Registry     //served on the admin API
+    controlPackets *exporter.CounterVec
+    streamsMutex sync.RWMutex
+    streams map[string]*stream      //shared streams, set up before running
+    sessionsMutex sync.Mutex
+    sessions map[string]*stream     //VOD sessions of the streams, by ID
+}
+
+func New() *Server {
+    this := &Server{streams: make(map[string]*stream), sessions: make(map[string]*stream), registry: exporter.NewRegistry()}
+    this.controlPackets = this.registry.Counter("esr_server_control_packets_total", "Control packets received, by type", "type")
+
+    this.registry.GaugeFunc("esr_server_subscribers", "Subscribers of each stream, its VOD sessions included", func(emit func(float64, ...string)) {
+        subscribers := make(map[string]int)
+        for _, s := range this.allStreams() {
+            subscribers[packet.SharedStreamID(s.streamID)] += len(s.getSubscribers())
+        }
+        for streamID, n := range subscribers {
+            emit(float64(n), streamID)
+        }
+    }, "stream")
+    this.registry.GaugeFunc("esr_server_stream_health", "Health of the source of each stream (0 healthy, 1 degraded, 2 failed)", func(emit func(float64, ...string)) {
+        for streamID, s := range this.sharedStreams() {
+            emit(float64(s.health()), streamID)
+        }
+    }, "stream")
+    return this
+}
+
+//Probes the source and hosts it under the given streamID.
+//Must be called before Run
+func (this *Server) AddStream(streamID string, source Source) error {
+    if packet.SharedStreamID(streamID) != streamID {
+        return errors.New("stream IDs can't contain '@', which names VOD sessions")
+    }
+
+    s, err := start(streamID, source, &this.serv, this.registry)
+    if err != nil { return err }
+
+    this.streamsMutex.Lock()
+    this.streams[streamID] = s
+    this.streamsMutex.Unlock()
+    return nil
+}
+
+//Runs the server on the given local address until it is closed.
+//The port is used both for TCP (control messages) and UDP (pings).
+//If the address is invalid, all local addresses are used
+func (this *Server) Run(addr netip.Addr, port uint16) error {
+    return this.RunWith(service.NetTransport{Addr: addr}, port)
+}
+
+//Runs the server through the given transport until it is closed
+func (this *Server) RunWith(transport service.Transport, port uint16) error {
+    this.serv.SetTransport(transport)
+    this.serv.AddHandler(this)
+    return this.serv.Run(&port, &port)
+}
+
+func (this *Server) Close() {
+    this.serv.Close()
+}
+
+
+//Number of subscribers of all streams and sessions
+func (this *Server) load() int {
+    total := 0
+    for _, s := range this.allStreams() {
+        total += len(s.getSubscribers())
+    }
+    return total
+}
+
+//Returns a snapshot of the shared streams, by ID
+func (this *Server) sharedStreams() map[string]*stream {
+    this.streamsMutex.RLock()
+    defer this.streamsMutex.RUnlock()
+
+    ans := make(map[string]*stream, len(this.streams))
+    for streamID, s := range this.streams {
+        ans[streamID] = s
+    }
+    return ans
+}
+
+//Returns the shared stream with the given ID
+func (this *Server) sharedStream(streamID string) (*stream, bool) {
+    this.streamsMutex.RLock()
+    defer this.streamsMutex.RUnlock()
+
+    s, ok := this.streams[streamID]
+    return s, ok
+}
+
+//Returns the shared streams and the VOD sessions
+func (this *Server) allStreams() []*stream {
+    shared := this.sharedStreams()
+
+    this.sessionsMutex.Lock()
+    defer this.sessionsMutex.Unlock()
+
+    ans := make([]*stream, 0, len(shared) + len(this.sessions))
+    for _, s := range shared {
+        ans = append(ans, s)
+    }
+    for _, s := range this.sessions {
+        ans = append(ans, s)
+    }
+    return ans
+}
+
+//Returns the shared stream a stream ID names (itself or one of its VOD sessions).
+//Sessions are only offered for streams with a duration, and must start before their end
+func (this *Server) hosted(id string) (*stream, bool) {
+    vod, isVOD := packet.ParseVODSession(id)
+    if !isVOD {
+        return this.sharedStream(id)
+    }
+
+    s, ok := this.sharedStream(vod.StreamID)
+    if !ok || s.metadata.Duration == 0 || vod.Offset >= s.metadata.Duration {
+        return nil, false
+    }
+    return s, true
+}
+
+//Returns the shared stream or the running VOD session with the given ID
+func (this *Server) stream(id string) (*stream, bool) {
+    if _, isVOD := packet.ParseVODSession(id); !isVOD {
+        return this.sharedStream(id)
+    }
+
+    this.sessionsMutex.Lock()
+    defer this.sessionsMutex.Unlock()
+    s, ok := this.sessions[id]
+    return s, ok
+}
+
+//Adds a subscriber to the shared stream or VOD session with the given ID, creating the session on its first request
+func (this *Server) subscribe(id string, client netip.AddrPort) (sdp.SessionDescription, error) {
+    base, ok := this.hosted(id)
+    if !ok {
+        return sdp.SessionDescription{}, errors.New("stream not hosted by the server")
+    } else if base.streamID == id {
+        return base.addSubscriber(client)
+    }
+
+    //the lock is held while the session starts, so it can't be pruned before having a subscriber
+    this.sessionsMutex.Lock()
+    defer this.sessionsMutex.Unlock()
+
+    s, ok := this.sessions[id]
+    if !ok {
+        vod, _ := packet.ParseVODSession(id)
+        s = base.session(vod)
+        s.onEnd = func() { this.forgetSession(id, s) }
+        this.sessions[id] = s
+    }
+
+    session, err := s.addSubscriber(client)
+    if err != nil && len(s.getSubscribers()) == 0 {
+        delete(this.sessions, id)
+    }
+    return session, err
+}
+
+//Forgets a VOD session that ended, unless it was replaced by a new session with the same ID
+func (this *Server) forgetSession(id string, s *stream) {
+    this.sessionsMutex.Lock()
+    defer this.sessionsMutex.Unlock()
+
+    if this.sessions[id] == s {
+        delete(this.sessions, id)
+    }
+}
+
+//Forgets the VOD sessions left without subscribers (their viewers left or they ended)
+func (this *Server) pruneSessions() {
+    this.sessionsMutex.Lock()
+    defer this.sessionsMutex.Unlock()
+
+    for id, s := range this.sessions {
+        if len(s.getSubscribers()) == 0 {
+            delete(this.sessions, id)
+        }
+    }
+}
+
+
+func (this *Server) Handle(sig service.Signal) bool {
+
+    switch sig.(type) {
+    case service.TCPMessage:
+        msg := sig.(service.TCPMessage)
+        this.controlPackets.With(reflect.TypeOf(msg.Packet()).Name()).Inc()
+
+        switch msg.Packet().(type) {
+        case packet.ProbeRequest:
+            p := msg.Packet().(packet.ProbeRequest)
+
+            if s, ok := this.hosted(p.StreamID); ok {
+                resp := p.RespondExistant(s.metadata)
+                resp.Load = this.load()
+                resp.Health = s.health()
+                utils.Warn(msg.SendResponse(resp))
+            } else {
+                utils.Warn(msg.SendResponse(p.RespondNonExistant()))
+            }
+            return true
+
+        case packet.CatalogRequest:
+            p := msg.Packet().(packet.CatalogRequest)
+
+            shared := this.sharedStreams()
+            entries := make([]packet.CatalogEntry, 0, len(shared))
+            for streamID, s := range shared {
+                entries = append(entries, packet.CatalogEntry{StreamID: streamID, Stream: s.metadata})
+            }
+            utils.Warn(msg.SendResponse(p.Respond(packet.MergeCatalogs(entries)...)))
+            return true
+
+        case packet.TraceRequest:
+            p := msg.Packet().(packet.TraceRequest)
+
+            hop := packet.TraceHop{Server: true}
+            if s, ok := this.stream(p.StreamID); ok {
+                hop.Subscribers = s.getSubscribers()
+                hop.Running = len(hop.Subscribers) != 0
+                if health := s.health(); health != packet.Healthy {
+                    hop.Error = "source " + health.String()
+                }
+            } else {
+                hop.Error = "stream not hosted by the server"
+            }
+            utils.Warn(msg.SendResponse(p.Respond(hop)))
+            return true
+
+        case packet.StreamRequest:
+            p := msg.Packet().(packet.StreamRequest)
+            this.pruneSessions()
+
+            session, err := this.subscribe(p.StreamID, netip.AddrPortFrom(msg.Addr().Addr(), p.Port))
+            if err == nil {
+                utils.Warn(msg.SendResponse(packet.StreamResponse{StreamID: p.StreamID, RequestID: p.RequestID, SDP: session}))
+                return true
+            }
+
+            slog.Error("Error adding subscriber to", "stream", p.StreamID, "err", err)
+            utils.Warn(msg.SendResponse(packet.StreamEnd{StreamID: p.StreamID}))
+            utils.Warn(msg.CloseConn())
+
+            return true
+
+        case packet.PlaybackRequest:
+            p := msg.Packet().(packet.PlaybackRequest)
+            utils.Warn(msg.SendResponse(this.playback(p, msg.Addr().Addr())))
+            return true
+
+        case packet.Nack:
+            p := msg.Packet().(packet.Nack)
+
+            if s, ok := this.stream(p.StreamID); ok {
+                s.retransmit(netip.AddrPortFrom(msg.Addr().Addr(), p.Port), p.Missing)
+            }
+            return true
+
+        case packet.StreamCancel:
+            p := msg.Packet().(packet.StreamCancel)
+
+            s, ok := this.stream(p.StreamID)
+            if !ok {
+                slog.Warn("StreamCancel: inexistent streamID")
+                return true
+            }
+
+            client := netip.AddrPortFrom(msg.Addr().Addr(), p.Port)
+            if !s.removeSubscribers(client) {
+                slog.Warn("Invalid StreamCancel: client not registered with given streamID", "addr", client, "streamID", p.StreamID)
+            }
+            this.pruneSessions()
+            return true
+        }
+
+    case service.TCPDisconnected:
+        disc := sig.(service.TCPDisconnected)
+        for _,s := range this.allStreams() {
+            s.removeSubscribers(netip.AddrPortFrom(disc.Addr().Addr(), 0))
+        }
+        this.pruneSessions()
+        return true
+
+    case service.UDPMessage:
+        msg := sig.(service.UDPMessage)
+        ping, ok := msg.Packet().(packet.Ping)
+        if !ok { return false }
+    
+        utils.Warn(msg.SendResponse(ping))
+        return true
+    }
+
+	return false
+}
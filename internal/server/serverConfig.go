@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/SLP25/ESR/internal/rtp"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+type config map[string] string
+
+func MustReadConfig(filename string) config {
+	bytes, err := os.ReadFile(filename)
+	if err != nil { panic(err.Error()) }
+	
+	conf := make(config)
+	err = json.Unmarshal(bytes, &conf)
+	if err != nil {
+		panic("Error parsing server config: " + err.Error())
+	}
+
+	return conf
+}
+
+//Parses the source of a stream in the config:
+//  path/to/file.mp4 or file:path?loop=false      a media file, looped by default
+//  dump:path/to/capture.pcap?rtpmap=96:video:H264/90000   an RTP session captured by rtpdump or in a pcap, looped by default
+//  testpattern:?width=320&height=240&fps=25      colour bars generated by the server
+//  rtp://:5004?media=video&pt=96&codec=H264/90000&bitrate=2000000   an RTP feed received on a local port
+//  sdp:path/to/feed.sdp?bitrate=2000000          the RTP feeds described by a session description
+//  pipe:?bitrate=2000000 or pipe:path/to/fifo    MPEG-TS from the standard input or a named pipe
+func ParseSource(spec string) (Source, error) {
+	scheme, _, _ := strings.Cut(spec, ":")
+	switch scheme {
+		case "file", "dump", "testpattern", "rtp", "sdp", "pipe":
+		default: //plain path
+			switch strings.ToLower(filepath.Ext(spec)) {
+				case ".rtp", ".rtpdump", ".pcap": return NewDumpSource(spec, true, nil), nil
+				default: return NewFileSource(spec, true), nil
+			}
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil { return nil, fmt.Errorf("invalid source '%s': %w", spec, err) }
+
+	path := u.Opaque
+	if path == "" {
+		path = u.Path
+	}
+	query := u.Query()
+
+	//Parses an optional integer parameter
+	param := func(name string, def int) (int, error) {
+		v := query.Get(name)
+		if v == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s '%s' in source '%s'", name, v, spec)
+		}
+		return n, nil
+	}
+
+	bitrate, err := param("bitrate", 0)
+	if err != nil { return nil, err }
+
+	loop := true
+	if v := query.Get("loop"); v != "" {
+		loop, err = strconv.ParseBool(v)
+		if err != nil { return nil, fmt.Errorf("invalid loop '%s' in source '%s'", v, spec) }
+	}
+
+	switch scheme {
+		case "file":
+			return NewFileSource(path, loop), nil
+
+		case "dump":
+			formats := map[uint8]rtp.PayloadFormat{}
+			for _, v := range query["rtpmap"] {
+				//<payload type>:<media>:<encoding>
+				parts := strings.SplitN(v, ":", 3)
+				pt, err := strconv.ParseUint(parts[0], 10, 7)
+				if err != nil || len(parts) != 3 || (parts[1] != utils.VideoTrack && parts[1] != utils.AudioTrack) {
+					return nil, fmt.Errorf("invalid rtpmap '%s' in source '%s'", v, spec)
+				}
+				formats[uint8(pt)] = rtp.PayloadFormat{Media: parts[1], Encoding: parts[2]}
+			}
+			return NewDumpSource(path, loop, formats), nil
+
+		case "testpattern":
+			width, err := param("width", 320)
+			if err != nil { return nil, err }
+			height, err := param("height", 240)
+			if err != nil { return nil, err }
+			fps, err := param("fps", 25)
+			if err != nil { return nil, err }
+			if width == 0 || height == 0 || fps == 0 {
+				return nil, fmt.Errorf("invalid test pattern '%s'", spec)
+			}
+			return NewTestPatternSource(width, height, fps), nil
+
+		case "rtp":
+			port, err := strconv.ParseUint(u.Port(), 10, 16)
+			if err != nil { return nil, fmt.Errorf("invalid port in source '%s'", spec) }
+
+			media := query.Get("media")
+			if media == "" {
+				media = "video"
+			}
+			pt, err := param("pt", 96)
+			if err != nil || pt > 127 { return nil, fmt.Errorf("invalid payload type in source '%s'", spec) }
+
+			codec := query.Get("codec")
+			if codec == "" {
+				return nil, fmt.Errorf("missing codec in source '%s'", spec)
+			}
+			return NewRTPSource(uint16(port), media, uint8(pt), codec, bitrate), nil
+
+		case "sdp":
+			return ReadSDPSource(path, bitrate)
+
+		default: //pipe
+			return NewPipeSource(path, bitrate), nil
+	}
+}
+
+//func (this *config) getStream(id string) {
+//	file := (*this)[id]
+//	open file?
+//}
\ No newline at end of file
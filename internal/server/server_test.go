@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+var (
+	serverHost = netip.MustParseAddr("10.0.0.1")
+	clientB = netip.MustParseAddrPort("10.0.0.2:5000")
+	clientC = netip.MustParseAddrPort("10.0.0.3:5000")
+)
+
+//A run of a fakeSource, driven by the test
+type fakeRun struct {
+	offset time.Duration
+	out chan<- packet.StreamPacket
+	stop <-chan struct{}
+	done chan error		//ends the run, like a source that stops by itself
+}
+
+//A source whose runs are handed to the test, which produces their packets and ends them
+type fakeSource struct {
+	duration time.Duration
+	runs chan fakeRun
+}
+
+func newFakeSource(duration time.Duration) *fakeSource {
+	return &fakeSource{duration: duration, runs: make(chan fakeRun, 10)}
+}
+
+func (this *fakeSource) Probe() (utils.StreamMetadata, error) {
+	return utils.StreamMetadata{Duration: this.duration}, nil
+}
+
+func (this *fakeSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	run := fakeRun{offset: offset, out: out, stop: stop, done: make(chan error, 1)}
+	this.runs <- run
+	return sdp.SessionDescription{SessionName: "fake"}, run.done, nil
+}
+
+//Returns the next run started, failing the test if none starts in time
+func (this *fakeSource) next(t *testing.T, timeout time.Duration) fakeRun {
+	t.Helper()
+	select {
+		case run := <-this.runs: return run
+		case <-time.After(timeout):
+			t.Fatal("the source wasn't started")
+			return fakeRun{}
+	}
+}
+
+//Fails the test if a run starts within the timeout
+func (this *fakeSource) noRun(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	select {
+		case <-this.runs: t.Fatal("the source was started")
+		case <-time.After(timeout):
+	}
+}
+
+func (this fakeRun) stopped(timeout time.Duration) bool {
+	select {
+		case <-this.stop: return true
+		case <-time.After(timeout): return false
+	}
+}
+
+//Initializes a service through which the streams send
+type initHandler chan struct{}
+
+func (this initHandler) Handle(sig service.Signal) bool {
+	_, ok := sig.(service.Init)
+	if ok { close(this) }
+	return ok
+}
+
+//Runs a service on serverHost of the network, returning once it handles signals
+func runService(t *testing.T, network *service.MemNetwork) *service.Service {
+	var serv service.Service
+	serv.SetTransport(network.Host(serverHost))
+	started := make(initHandler)
+	serv.AddHandler(started)
+
+	done := make(chan error, 1)
+	go func() { done <- serv.Run(nil) }()
+	select {
+		case <-started:
+		case err := <-done: t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		serv.Close()
+		<-done
+	})
+	return &serv
+}
+
+//Opens a UDP socket on the network, where a subscriber receives the stream
+func listenSubscriber(t *testing.T, network *service.MemNetwork, addr netip.AddrPort) net.PacketConn {
+	conn, err := network.Host(addr.Addr()).ListenPacket(addr.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+//Returns the next stream packet the subscriber receives, and false if none arrives in time
+func receive(conn net.PacketConn, timeout time.Duration) (packet.StreamPacket, bool) {
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return packet.StreamPacket{}, false
+	}
+
+	p, err := packet.Deserialize(bytes.NewReader(buf[:n]))
+	sp, ok := p.(packet.StreamPacket)
+	return sp, err == nil && ok
+}
+
+func newTestStream(t *testing.T, serv *service.Service, source Source) *stream {
+	s, err := start("live", source, serv, exporter.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+//Every packet of the source reaches each subscriber once, numbered in sequence
+func TestFanOut(t *testing.T) {
+	network := service.NewMemNetwork()
+	source := newFakeSource(0)
+	s := newTestStream(t, runService(t, network), source)
+	connB := listenSubscriber(t, network, clientB)
+	connC := listenSubscriber(t, network, clientC)
+
+	if session, err := s.addSubscriber(clientB); err != nil || session.SessionName != "fake" {
+		t.Fatalf("subscribing returned %v (%v), want the source's session", session.SessionName, err)
+	}
+	run := source.next(t, time.Second)
+	s.addSubscriber(clientC)
+	s.addSubscriber(clientC)
+	source.noRun(t, 20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		run.out <- packet.StreamPacket{Type: packet.Video, Content: []byte{byte(i)}}
+	}
+	//datagrams may arrive out of order, but each carries the sequence number it was sent with
+	for _, conn := range []net.PacketConn{connB, connC} {
+		seqs := make(map[byte]uint32)
+		for i := 0; i < 3; i++ {
+			p, ok := receive(conn, time.Second)
+			if !ok {
+				t.Fatalf("%s received %d packets, want 3", conn.LocalAddr(), i)
+			} else if _, dup := seqs[p.Content[0]]; dup {
+				t.Fatalf("%s received packet %d twice", conn.LocalAddr(), p.Content[0])
+			}
+			seqs[p.Content[0]] = p.Seq
+		}
+		if seqs[1] != seqs[0] + 1 || seqs[2] != seqs[0] + 2 {
+			t.Errorf("%s received sequence numbers %v, want consecutive ones", conn.LocalAddr(), seqs)
+		}
+		if _, ok := receive(conn, 20 * time.Millisecond); ok {
+			t.Errorf("%s received more packets than sent", conn.LocalAddr())
+		}
+	}
+}
+
+//Removed subscribers stop receiving, and the source is stopped with the last one
+func TestRemoveSubscribers(t *testing.T) {
+	network := service.NewMemNetwork()
+	source := newFakeSource(0)
+	s := newTestStream(t, runService(t, network), source)
+	connB := listenSubscriber(t, network, clientB)
+	connC := listenSubscriber(t, network, clientC)
+
+	s.addSubscriber(clientB)
+	s.addSubscriber(netip.AddrPortFrom(clientB.Addr(), 5001))
+	s.addSubscriber(clientC)
+	run := source.next(t, time.Second)
+
+	if s.removeSubscribers(netip.MustParseAddrPort("10.0.0.4:0")) {
+		t.Error("removed a subscriber that doesn't match")
+	}
+	//port 0 matches every port of the address
+	if !s.removeSubscribers(netip.AddrPortFrom(clientB.Addr(), 0)) {
+		t.Fatal("no subscriber of 10.0.0.2 removed")
+	}
+	if subs := s.getSubscribers(); len(subs) != 1 || subs[0] != clientC {
+		t.Errorf("subscribers %v, want only %s", subs, clientC)
+	}
+
+	run.out <- packet.StreamPacket{Type: packet.Video, Content: []byte{1}}
+	if _, ok := receive(connC, time.Second); !ok {
+		t.Error("the remaining subscriber didn't receive the packet")
+	}
+	if _, ok := receive(connB, 20 * time.Millisecond); ok {
+		t.Error("a removed subscriber received the packet")
+	}
+	if run.stopped(0) {
+		t.Fatal("the source was stopped though it has a subscriber")
+	}
+
+	s.removeSubscribers(clientC)
+	if !run.stopped(time.Second) || s.running() {
+		t.Error("the source wasn't stopped without subscribers")
+	}
+
+	//a new subscriber starts it again
+	s.addSubscriber(clientB)
+	source.next(t, time.Second)
+}
+
+//The server counts the subscribers of every stream, and forgets the ones of a disconnected remote
+func TestServerSubscribers(t *testing.T) {
+	server := New()
+	server.serv.SetTransport(service.NewMemNetwork().Host(serverHost))
+	for _, streamID := range []string{"a", "b"} {
+		if err := server.AddStream(streamID, newFakeSource(0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := server.subscribe("c", clientB); err == nil {
+		t.Error("subscribed to a stream not hosted")
+	}
+	server.subscribe("a", clientB)
+	server.subscribe("b", clientB)
+	server.subscribe("b", clientC)
+	if load := server.load(); load != 3 {
+		t.Errorf("load %d, want 3", load)
+	}
+
+	for _, s := range server.allStreams() {
+		s.removeSubscribers(netip.AddrPortFrom(clientB.Addr(), 0))
+	}
+	if load := server.load(); load != 1 {
+		t.Errorf("load %d once 10.0.0.2 left, want 1", load)
+	}
+	for streamID, s := range server.sharedStreams() {
+		if running := len(s.getSubscribers()) != 0; s.running() != running {
+			t.Errorf("stream %s running %v with %d subscribers", streamID, s.running(), len(s.getSubscribers()))
+		}
+	}
+}
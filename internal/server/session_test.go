@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+//A finite source that produces nothing, and only stops when told to
+type silentSource struct {
+	duration time.Duration
+}
+
+func (this silentSource) Probe() (utils.StreamMetadata, error) {
+	return utils.StreamMetadata{Duration: this.duration}, nil
+}
+
+func (this silentSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	return sdp.SessionDescription{}, nil, nil
+}
+
+//A session that reaches the end of its stream is forgotten, though its subscriber never left
+func TestFinishedSessionForgotten(t *testing.T) {
+	s := New()
+	if err := s.AddStream("movie", silentSource{duration: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := packet.VODSession{StreamID: "movie", Session: 1, Offset: time.Minute - 100 * time.Millisecond}.ID()
+	if _, err := s.subscribe(id, netip.MustParseAddrPort("10.0.0.1:5000")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.stream(id); !ok {
+		t.Fatal("session not created")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := s.stream(id); !ok {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("the session is still kept long after it ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := s.stream("movie"); !ok {
+		t.Error("the shared stream was forgotten along with its session")
+	}
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+	"github.com/vansante/go-ffprobe"
+)
+
+//A producer of stream packets, shared by all the subscribers of a stream
+type Source interface {
+	//Returns the metadata of the stream. Its duration is 0 if the source is unbounded
+	Probe() (utils.StreamMetadata, error)
+
+	//Starts producing packets from the given offset, sending them to out until stop is closed.
+	//Returns the session description of the produced RTP streams, and a channel that receives the
+	//end of a source that stops by itself: nil when it is finite and reached its end, or the error it failed with.
+	//Sources that only stop when told to may return a nil channel
+	Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error)
+}
+
+
+//A video file streamed in real time by an ffmpeg process
+type fileSource struct {
+	filepath string
+	loop bool
+}
+
+func NewFileSource(filepath string, loop bool) Source {
+	return &fileSource{filepath: filepath, loop: loop}
+}
+
+//Parses a frame rate like ffprobe reports it ("30000/1001" or "25"). Returns 0 if unknown
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil { return 0 }
+
+	if !found { return n }
+
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 { return 0 }
+	return math.Round(n / d * 100) / 100
+}
+
+//Fields ffprobe omits are left unknown. The duration falls back to the container's,
+//and the bitrate to the container's when the tracks don't report theirs
+func (this *fileSource) Probe() (utils.StreamMetadata, error) {
+	var metadata utils.StreamMetadata
+
+	data, err := ffprobe.GetProbeData(this.filepath, 5 * time.Second)
+	if err != nil { return metadata, err }
+
+	complete := true
+	for _, s := range data.Streams {
+		t := utils.TrackMetadata{Kind: s.CodecType, Codec: s.CodecName}
+
+		if d, err := strconv.ParseFloat(s.Duration, 64); err == nil {
+			metadata.Duration = max(metadata.Duration, time.Duration(d * float64(time.Second)))
+		}
+
+		if b, err := strconv.Atoi(s.BitRate); err == nil {
+			t.Bitrate = b
+		} else {
+			complete = false
+		}
+
+		switch s.CodecType {
+		case utils.VideoTrack:
+			t.Width, t.Height = s.Width, s.Height
+			t.FrameRate = parseFrameRate(s.AvgFrameRate)
+			if t.FrameRate == 0 {
+				t.FrameRate = parseFrameRate(s.RFrameRate)
+			}
+		case utils.AudioTrack:
+			t.SampleRate, _ = strconv.Atoi(s.SampleRate)
+			t.Channels = s.Channels
+		}
+
+		metadata.Bitrate += t.Bitrate
+		metadata.Tracks = append(metadata.Tracks, t)
+	}
+
+	if data.Format != nil {
+		if metadata.Duration == 0 {
+			metadata.Duration = time.Duration(data.Format.DurationSeconds * float64(time.Second))
+		}
+
+		if b, err := strconv.Atoi(data.Format.BitRate); err == nil && (!complete || metadata.Bitrate == 0) {
+			metadata.Bitrate = max(metadata.Bitrate, b)
+		}
+	}
+
+	if metadata.Bitrate == 0 {
+		slog.Warn("ffprobe didn't report the bitrate of the file. Links won't be checked for it", "file", this.filepath)
+	}
+	return metadata, nil
+}
+
+func formatDuration(d time.Duration) string {
+	hours := d.Truncate(time.Hour) / time.Hour
+	minutes := (d.Truncate(time.Minute) - d.Truncate(time.Hour)) / time.Minute
+	seconds := (d.Truncate(time.Second) - d.Truncate(time.Minute)) / time.Second
+	milliseconds := (d.Truncate(time.Millisecond) - d.Truncate(time.Second)) / time.Millisecond
+
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, seconds, milliseconds)
+}
+
+func readSDP(r io.Reader) (sdp.SessionDescription, error) {
+	scanner := bufio.NewScanner(r)
+
+	//ignore first line
+	if !scanner.Scan() { 
+		err := scanner.Err()
+		if err == nil { err = io.EOF }
+		return sdp.SessionDescription{}, io.EOF
+	}
+
+	txt := ""
+
+	for {
+		if !scanner.Scan() {
+			err := scanner.Err()
+			if err == nil { err = io.EOF }
+			return sdp.SessionDescription{}, io.EOF
+		}
+		
+		line := scanner.Text()
+		if line == "" { break } //break on empty line
+		txt += line + "\n"
+	}
+
+	session := sdp.SessionDescription{}
+	err := session.Unmarshal([]byte(txt))
+	return session, err
+}
+
+func (this *fileSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	args := []string{"-re", "-ss", formatDuration(offset)}
+
+	if this.loop {
+		args = append(args, []string{"-stream_loop", "-1"}...)
+	}
+
+	args = append(args, "-i", this.filepath)
+	return startFFmpeg(args, nil, !this.loop, out, stop)
+}
+
+//Keeps the end of what ffmpeg writes to stderr, to explain why it failed
+type stderrTail struct {
+	mutex sync.Mutex
+	buf []byte
+}
+
+const stderrTailSize = 1024
+
+func (this *stderrTail) Write(b []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.buf = append(this.buf, b...)
+	if len(this.buf) > stderrTailSize {
+		this.buf = this.buf[len(this.buf) - stderrTailSize:]
+	}
+	return len(b), nil
+}
+
+func (this *stderrTail) String() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return strings.TrimSpace(string(this.buf))
+}
+
+//Time the packets ffmpeg sent before exiting are waited for
+const ffmpegDrainTimeout = 200 * time.Millisecond
+
+//Runs ffmpeg with the given input options, packetizing its first video and audio streams into RTP.
+//If stdin isn't nil, ffmpeg reads it (for inputs like pipe:0).
+//If the input is finite, ffmpeg exiting cleanly is the end of the source; otherwise it's a failure
+func startFFmpeg(input []string, stdin io.Reader, finite bool, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	var vidPort, audPort uint16
+	var vidServer, audServer, vidCtrlServer, audCtrlServer service.UDPServer
+
+	closeServers := func() {
+		vidServer.Close()
+		audServer.Close()
+		vidCtrlServer.Close()
+		audCtrlServer.Close()
+	}
+
+	err := vidServer.Open(&vidPort)
+	if err != nil { return sdp.SessionDescription{}, nil, err }
+
+	err = audServer.Open(&audPort)
+	if err != nil { vidServer.Close(); return sdp.SessionDescription{}, nil, err }
+
+	vidCtrlPort := vidPort + 1
+	err = vidCtrlServer.Open(&vidCtrlPort)
+	if err != nil { vidServer.Close(); audServer.Close(); return sdp.SessionDescription{}, nil, err }
+
+	audCtrlPort := audPort + 1
+	err = audCtrlServer.Open(&audCtrlPort)
+	if err != nil { vidServer.Close(); audServer.Close(); vidCtrlServer.Close(); return sdp.SessionDescription{}, nil, err }
+
+
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, input...)
+	args = append(args, "-vcodec", "copy", "-an", "-f", "rtp", "rtp://127.0.0.1:" + strconv.FormatUint(uint64(vidPort), 10))
+	args = append(args, "-acodec", "copy", "-vn", "-f", "rtp", "rtp://127.0.0.1:" + strconv.FormatUint(uint64(audPort), 10))
+
+	stderr := &stderrTail{}
+	ffmpeg := exec.Command("ffmpeg", args...)
+	ffmpeg.Stdin = stdin
+	ffmpeg.Stderr = stderr
+	stdout, _ := ffmpeg.StdoutPipe()
+	err = ffmpeg.Start()
+	if err != nil { closeServers(); return sdp.SessionDescription{}, nil, err }
+
+	exited := make(chan error, 1)
+	kill := func() {
+		ffmpeg.Process.Kill()
+		closeServers()
+	}
+
+	//read sdp from stdout
+	session, err := readSDP(stdout)
+	if err != nil {
+		kill()
+		ffmpeg.Wait()
+		if msg := stderr.String(); msg != "" {
+			err = fmt.Errorf("ffmpeg: %s", msg)
+		}
+		return sdp.SessionDescription{}, nil, err
+	}
+
+	go func() {
+		exited <- ffmpeg.Wait()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		defer kill()
+
+		var drain <-chan time.Time //set once ffmpeg exits
+		var exitErr error
+
+		for {
+			var p packet.StreamPacket
+			select {
+				case msg := <- vidServer.Output():
+					p = packet.StreamPacket{Type: packet.Video, Content: msg.Data}
+				case msg := <- audServer.Output():
+					p = packet.StreamPacket{Type: packet.Audio, Content: msg.Data}
+				case msg := <- vidCtrlServer.Output():
+					p = packet.StreamPacket{Type: packet.VideoControl, Content: msg.Data}
+				case msg := <- audCtrlServer.Output():
+					p = packet.StreamPacket{Type: packet.AudioControl, Content: msg.Data}
+				case exitErr = <-exited:
+					exited = nil
+					drain = time.After(ffmpegDrainTimeout)
+					continue
+				case <-drain:
+					if exitErr == nil && !finite {
+						exitErr = errors.New("ffmpeg exited")
+					}
+					if exitErr != nil {
+						if msg := stderr.String(); msg != "" {
+							exitErr = fmt.Errorf("%w: %s", exitErr, msg)
+						}
+					}
+					done <- exitErr
+					return
+				case <-stop:
+					return
+			}
+
+			select {
+				case out <- p:
+				case <-stop:
+					return
+			}
+		}
+	}()
+
+	return session, done, nil
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/SLP25/ESR/internal/exporter"
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/recovery"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+//Represents a continuous stream of video, started at a specific time, that loops forever.
+//Clients can subscribe to receive stream packets. A single source (e.g. an ffmpeg process) feeds all subscribers.
+//If no clients are subscribed, the source is stopped to save resources.
+type stream struct {
+	streamID string
+	source Source
+	serv *service.Service
+
+	startTime time.Time
+	metadata utils.StreamMetadata
+	paused bool					//the source is stopped at pausedAt, though it has subscribers
+	pausedAt time.Duration
+	vod bool					//an on-demand session, which ends with the source instead of looping
+	onEnd func()				//called once the session ended and its subscribers were told, if not nil
+
+	mutex sync.Mutex			//serializes starting and stopping the source
+	subscribers utils.Set[netip.AddrPort]
+	subsMutex sync.RWMutex
+	description sdp.SessionDescription	//valid while the source is running
+
+	cancelChan chan struct{} 	//if null, the source is down
+	canceledChan chan struct{}
+
+	nextSeq uint32				//of the next packet sent, only used by the goroutine of the source
+	sent *recovery.Buffer		//for the retransmission of the packets the subscribers lost
+
+	healthMutex sync.Mutex
+	consecutiveFailures int
+	lastStart time.Time
+	lastFailure time.Time
+	lastError string
+
+	sentPackets *exporter.Counter
+	sentBytes *exporter.Counter
+	starts *exporter.Counter
+	failures *exporter.Counter
+	restarts *exporter.Counter
+	retransmitted *exporter.Counter
+}
+
+const (
+	maxFailures = 3							//consecutive failures of the source before the stream is failed
+	healthyAfter = 10 * time.Second			//time the source must run for its failures to be forgotten
+	retryFailedAfter = time.Minute			//time after its last failure a failed stream is tried again
+	maxRestartBackoff = 30 * time.Second
+)
+
+//Returns the moment in the video file the stream is currently transmitting. Must be called with the mutex locked
+func (this *stream) currentTime() time.Duration {
+	if this.paused {
+		return this.pausedAt
+	}
+	return this.playingTime(this.startTime)
+}
+
+//Returns the moment in the video file a stream started at startTime, and not paused since, is transmitting
+func (this *stream) playingTime(startTime time.Time) time.Duration {
+	if this.vod {
+		return min(time.Now().Sub(startTime), this.metadata.Duration)
+	}
+	if this.metadata.Duration == 0 { //unbounded source
+		return time.Now().Sub(startTime)
+	}
+	return time.Now().Sub(startTime) % this.metadata.Duration
+}
+
+func start(streamID string, source Source, serv *service.Service, r *exporter.Registry) (*stream, error) {
+	stream := &stream{
+		streamID: streamID,
+		source: source,
+		serv: serv,
+		startTime: time.Now(),
+		subscribers: utils.EmptySet[netip.AddrPort](),
+		nextSeq: utils.RandID(),
+		sent: recovery.NewBuffer(),
+	}
+
+	stream.sentPackets = r.Counter("esr_server_sent_packets_total", "Stream packets sent to the subscribers", "stream").With(streamID)
+	stream.sentBytes = r.Counter("esr_server_sent_bytes_total", "Bytes of stream content sent to the subscribers", "stream").With(streamID)
+	stream.starts = r.Counter("esr_server_source_starts_total", "Times the source (an ffmpeg process for files) was started", "stream").With(streamID)
+	stream.failures = r.Counter("esr_server_source_failures_total", "Times the source failed to start or stopped with an error", "stream").With(streamID)
+	stream.restarts = r.Counter("esr_server_source_restarts_total", "Times the source was restarted after failing", "stream").With(streamID)
+	stream.retransmitted = r.Counter("esr_server_retransmitted_packets_total", "Stream packets sent again to the subscribers that lost them", "stream").With(streamID)
+
+	var err error
+	stream.metadata, err = source.Probe()
+	if err != nil { return nil, err }
+
+	return stream, nil
+}
+
+//Creates an on-demand session of the stream, which starts at the session's offset.
+//It shares the source and counters of the stream, but not its subscribers
+func (this *stream) session(vod packet.VODSession) *stream {
+	return &stream{
+		streamID: vod.ID(),
+		source: this.source,
+		serv: this.serv,
+		startTime: time.Now().Add(-vod.Offset),
+		metadata: this.metadata,
+		vod: true,
+		subscribers: utils.EmptySet[netip.AddrPort](),
+		nextSeq: utils.RandID(),
+		sent: recovery.NewBuffer(),
+		sentPackets: this.sentPackets,
+		sentBytes: this.sentBytes,
+		starts: this.starts,
+		failures: this.failures,
+		restarts: this.restarts,
+		retransmitted: this.retransmitted,
+	}
+}
+
+func (this *stream) running() bool {
+	return this.cancelChan != nil
+}
+
+//Adds a subscriber to the stream, starting the source if it isn't running yet.
+//Returns the session description of the running stream
+func (this *stream) addSubscriber(client netip.AddrPort) (sdp.SessionDescription, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.paused { //a shared stream can't stay paused
+		this.startTime = time.Now().Add(-this.pausedAt)
+		this.paused = false
+	}
+
+	if !this.running() {
+		var err error
+		this.description, err = this.startBackground()
+		if err != nil { return sdp.SessionDescription{}, err }
+	}
+
+	this.subsMutex.Lock()
+	this.subscribers.Add(client)
+	this.subsMutex.Unlock()
+
+	return this.description, nil
+}
+
+//Removes the subscribers that match the given address (a port of 0 matches any port).
+//The source is stopped if no subscribers remain.
+//Returns false if no subscriber matched
+func (this *stream) removeSubscribers(client netip.AddrPort) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	removed := false
+	this.subsMutex.Lock()
+	for s := range this.subscribers {
+		if utils.Matches(client, s) {
+			this.subscribers.Remove(s)
+			removed = true
+		}
+	}
+	empty := this.subscribers.Length() == 0
+	this.subsMutex.Unlock()
+
+	if empty {
+		this.terminate()
+		if this.paused {
+			this.startTime = time.Now().Add(-this.pausedAt)
+			this.paused = false
+		}
+	}
+	return removed
+}
+
+//Sends again the packets a subscriber lost, among the ones still kept
+func (this *stream) retransmit(client netip.AddrPort, missing []uint32) {
+	this.subsMutex.RLock()
+	subscribed := this.subscribers.Contains(client)
+	this.subsMutex.RUnlock()
+	if !subscribed {
+		return
+	}
+
+	for _, seq := range missing {
+		if p, ok := this.sent.Get(seq); ok {
+			utils.Warn(this.serv.SendUDP(p, client))
+			this.retransmitted.Inc()
+		}
+	}
+}
+
+func (this *stream) getSubscribers() []netip.AddrPort {
+	this.subsMutex.RLock()
+	defer this.subsMutex.RUnlock()
+	return this.subscribers.ToSlice()
+}
+
+func (this *stream) moveCurrentTime(current time.Duration) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.paused {
+		this.pausedAt = current
+		return nil
+	} else if this.running() {
+		this.terminate()
+		this.startTime = time.Now().Add(-current)
+		_, err := this.startBackground() //ignore sdp since it isn't changed
+		return err
+	} else {
+		this.startTime = time.Now().Add(-current)
+		return nil
+	}
+}
+
+//Stops the source at its current position, keeping the subscribers
+func (this *stream) pause() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.paused {
+		this.pausedAt = this.currentTime()
+		this.paused = true
+		this.terminate()
+	}
+}
+
+//Restarts the source from the position it was paused at
+func (this *stream) resume() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.paused {
+		return nil
+	}
+	this.startTime = time.Now().Add(-this.pausedAt)
+	this.paused = false
+
+	if len(this.getSubscribers()) == 0 {
+		return nil
+	}
+	_, err := this.startBackground() //ignore sdp since it isn't changed
+	return err
+}
+
+//stops the background source
+func (this *stream) terminate() {
+	if this.running() {
+		this.cancelChan <- struct{}{}
+		<- this.canceledChan
+		this.cancelChan = nil
+		this.canceledChan = nil
+	}
+}
+
+//Starts the source at the given moment of the video file, recording the start
+func (this *stream) startSource(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	session, done, err := this.source.Start(offset, out, stop)
+	if err != nil { return sdp.SessionDescription{}, nil, err }
+
+	this.starts.Inc()
+	this.healthMutex.Lock()
+	this.lastStart = time.Now()
+	this.healthMutex.Unlock()
+	return session, done, nil
+}
+
+//Starts the source and the goroutine that sends its packets. Must be called with the mutex locked
+func (this *stream) startBackground() (sdp.SessionDescription, error) {
+	if this.health() == packet.Failed {
+		this.healthMutex.Lock()
+		defer this.healthMutex.Unlock()
+		return sdp.SessionDescription{}, fmt.Errorf("source failed %d times in a row, last with: %s", this.consecutiveFailures, this.lastError)
+	}
+
+	out := make(chan packet.StreamPacket, 20)
+	stop := make(chan struct{})
+
+	//the goroutine doesn't take the mutex, so it positions restarts by the start time, which doesn't change while it runs
+	startTime := this.startTime
+	offset := this.currentTime()
+	session, done, err := this.startSource(offset, out, stop)
+	if err != nil {
+		this.recordFailure(err)
+		return sdp.SessionDescription{}, err
+	}
+
+	cancel := make(chan struct{}, 1)
+	canceled := make(chan struct{}, 1)
+	this.cancelChan = cancel
+	this.canceledChan = canceled
+
+	//end of a VOD session. Sessions are only created for streams with a duration (see Server.hosted),
+	//so the timer can't fire right away
+	var finish <-chan time.Time
+	if this.vod {
+		finish = time.After(this.metadata.Duration - offset)
+	}
+
+	go func() {
+		for {
+			var p packet.StreamPacket
+			select {
+				case p = <-out:
+				case <-finish:
+					close(stop)
+					canceled <- struct{}{}
+					this.end(cancel)
+					return
+				case err := <-done:
+					close(stop)
+					stop, done = this.restart(err, startTime, out, cancel)
+					if stop == nil {
+						canceled <- struct{}{}
+						this.end(cancel)
+						return
+					}
+					continue
+				case <-cancel:
+					close(stop)
+					canceled <- struct{}{}
+					return
+			}
+
+			p.Seq = this.nextSeq
+			this.nextSeq++
+			this.sent.Add(p)
+			for _, client := range this.getSubscribers() {
+				utils.Warn(this.serv.SendUDP(p, client))
+				this.sentPackets.Inc()
+				this.sentBytes.Add(float64(len(p.Content)))
+			}
+		}
+	}()
+
+	return session, nil
+}
+
+//Handles the end of the source: a failure is retried with exponential backoff until the stream is failed.
+//Returns the stop and end channels of the restarted source, or a nil stop if the stream ended (or was canceled meanwhile).
+//The subscribers keep the session description of the first source, and the restarted one resumes where the stream
+//started at startTime is by then
+func (this *stream) restart(err error, startTime time.Time, out chan<- packet.StreamPacket, cancel <-chan struct{}) (chan struct{}, <-chan error) {
+	if err == nil {
+		slog.Info("Source reached its end", "stream", this.streamID)
+		return nil, nil
+	}
+
+	for {
+		failures := this.recordFailure(err)
+		if this.health() == packet.Failed {
+			slog.Error("Source failed repeatedly, ending the stream", "stream", this.streamID, "failures", failures, "err", err)
+			return nil, nil
+		}
+
+		backoff := min(time.Second << min(failures - 1, 5), maxRestartBackoff)
+		slog.Warn("Source failed, restarting it", "stream", this.streamID, "in", backoff, "err", err)
+		select {
+			case <-time.After(backoff):
+			case <-cancel:
+				return nil, nil
+		}
+
+		stop := make(chan struct{})
+		var done <-chan error
+		_, done, err = this.startSource(this.playingTime(startTime), out, stop)
+		if err == nil {
+			this.restarts.Inc()
+			return stop, done
+		}
+		close(stop)
+	}
+}
+
+//Ends the stream for all its subscribers, unless it was restarted or terminated meanwhile
+func (this *stream) end(cancel chan struct{}) {
+	this.mutex.Lock()
+	if this.cancelChan != cancel {
+		this.mutex.Unlock()
+		return
+	}
+	this.cancelChan = nil
+	this.canceledChan = nil
+
+	this.subsMutex.Lock()
+	subscribers := this.subscribers
+	this.subscribers = utils.EmptySet[netip.AddrPort]()
+	this.subsMutex.Unlock()
+	this.mutex.Unlock()
+
+	addrs := utils.EmptySet[netip.Addr]()
+	for s := range subscribers {
+		addrs.Add(s.Addr())
+	}
+	for addr := range addrs {
+		utils.Warn(this.serv.TCPServer().Send(packet.StreamEnd{StreamID: this.streamID}, addr))
+	}
+
+	if this.onEnd != nil {
+		this.onEnd()
+	}
+}
+
+//Records a failure of the source, returning the number of consecutive failures
+func (this *stream) recordFailure(err error) int {
+	this.failures.Inc()
+
+	this.healthMutex.Lock()
+	defer this.healthMutex.Unlock()
+
+	this.resetFailures()
+	this.consecutiveFailures++
+	this.lastFailure = time.Now()
+	this.lastError = err.Error()
+	return this.consecutiveFailures
+}
+
+//Forgets the failures once the source has been running for a while since the last one. Must hold healthMutex
+func (this *stream) resetFailures() {
+	if this.lastStart.After(this.lastFailure) && time.Since(this.lastStart) >= healthyAfter {
+		this.consecutiveFailures = 0
+	}
+}
+
+//Returns the health of the source. A failed stream is tried again some time after its last failure
+func (this *stream) health() packet.StreamHealth {
+	this.healthMutex.Lock()
+	defer this.healthMutex.Unlock()
+
+	this.resetFailures()
+	switch {
+		case this.consecutiveFailures == 0: return packet.Healthy
+		case this.consecutiveFailures < maxFailures: return packet.Degraded
+		case time.Since(this.lastFailure) >= retryFailedAfter: return packet.Degraded
+		default: return packet.Failed
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+)
+
+//A failing source is restarted after 1s, then 2s, and the stream ends once it failed maxFailures times in a row
+func TestRestartBackoff(t *testing.T) {
+	network := service.NewMemNetwork()
+	source := newFakeSource(time.Minute)
+	s := newTestStream(t, runService(t, network), source)
+
+	if _, err := s.addSubscriber(clientB); err != nil {
+		t.Fatal(err)
+	}
+	run := source.next(t, time.Second)
+	if h := s.health(); h != packet.Healthy {
+		t.Fatalf("health %s before failing, want healthy", h)
+	}
+
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		failed := time.Now()
+		run.done <- errors.New("crashed")
+		source.noRun(t, backoff - 200 * time.Millisecond)
+		if h := s.health(); h != packet.Degraded {
+			t.Errorf("health %s after %d failures, want degraded", h, i + 1)
+		}
+
+		run = source.next(t, time.Second)
+		if elapsed := time.Since(failed); elapsed < backoff {
+			t.Errorf("restarted %v after failure %d, want %v", elapsed, i + 1, backoff)
+		}
+		if run.offset < 0 || run.offset >= time.Minute {
+			t.Errorf("restarted at %v, beyond the stream", run.offset)
+		}
+	}
+
+	run.done <- errors.New("crashed")
+	for start := time.Now(); len(s.getSubscribers()) != 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("the stream didn't end once the source failed 3 times")
+		}
+	}
+	source.noRun(t, 2200 * time.Millisecond)
+	if h := s.health(); h != packet.Failed {
+		t.Errorf("health %s after %d failures, want failed", h, maxFailures)
+	}
+
+	//a failed stream refuses subscribers until retryFailedAfter
+	if _, err := s.addSubscriber(clientB); err == nil {
+		t.Error("a failed stream accepted a subscriber")
+	}
+	source.noRun(t, 20 * time.Millisecond)
+}
+
+//A source that reaches its end isn't restarted
+func TestSourceEnd(t *testing.T) {
+	network := service.NewMemNetwork()
+	source := newFakeSource(time.Minute)
+	s := newTestStream(t, runService(t, network), source)
+
+	s.addSubscriber(clientB)
+	source.next(t, time.Second).done <- nil
+	source.noRun(t, 1200 * time.Millisecond)
+	if h := s.health(); h != packet.Healthy {
+		t.Errorf("health %s after the source ended, want healthy", h)
+	}
+	if subs := s.getSubscribers(); len(subs) != 0 {
+		t.Errorf("subscribers %v after the stream ended", subs)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		failures int
+		lastStart time.Time
+		lastFailure time.Time
+		want packet.StreamHealth
+	}{
+		{"no failures", 0, now, time.Time{}, packet.Healthy},
+		{"one failure", 1, now.Add(-time.Second), now, packet.Degraded},
+		{"restarted after failing", maxFailures - 1, now, now.Add(-time.Second), packet.Degraded},
+		{"running for long since failing", maxFailures - 1, now.Add(-healthyAfter), now.Add(-healthyAfter - time.Second), packet.Healthy},
+		{"too many failures", maxFailures, now.Add(-time.Second), now, packet.Failed},
+		{"failed long ago", maxFailures, now.Add(-retryFailedAfter - time.Second), now.Add(-retryFailedAfter), packet.Degraded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stream{consecutiveFailures: test.failures, lastStart: test.lastStart, lastFailure: test.lastFailure}
+			if h := s.health(); h != test.want {
+				t.Errorf("health %s, want %s", h, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strconv"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/rtp"
+	"github.com/SLP25/ESR/internal/utils"
+	"github.com/pion/sdp/v2"
+)
+
+//Colour bars in YCbCr (white, yellow, cyan, green, magenta, red, blue, black)
+var testPatternBars = [][3]uint8{
+	{235, 128, 128}, {210, 16, 146}, {170, 166, 16}, {145, 54, 34},
+	{106, 202, 222}, {81, 90, 240}, {41, 240, 110}, {16, 128, 128},
+}
+
+//Colour bars with a square moving across them, generated in Go and sent as RTP/JPEG,
+//so streams can be tested end to end (ffplay included) without media files or ffmpeg
+type testPatternSource struct {
+	width int
+	height int
+	fps int
+}
+
+//Width and height are rounded down to multiples of 16 (at least 16, at most 2032)
+func NewTestPatternSource(width int, height int, fps int) Source {
+	clamp := func(v int) int { return min(2032, max(16, v - v % 16)) }
+	return &testPatternSource{width: clamp(width), height: clamp(height), fps: max(1, fps)}
+}
+
+func (this *testPatternSource) frame(n int) []byte {
+	img := image.NewYCbCr(image.Rect(0, 0, this.width, this.height), image.YCbCrSubsampleRatio420)
+
+	//the square fits in the frame whatever its shape, and moves over the width it leaves free
+	side := min(this.height / 4, this.width / 2)
+	x0 := (n * 4) % max(1, this.width - side)
+	y0 := (this.height - side) / 2
+	for y := 0; y < this.height; y++ {
+		for x := 0; x < this.width; x++ {
+			c := testPatternBars[x * len(testPatternBars) / this.width]
+			if x >= x0 && x < x0 + side && y >= y0 && y < y0 + side {
+				c = [3]uint8{128, 128, 128}
+			}
+
+			img.Y[img.YOffset(x, y)] = c[0]
+			img.Cb[img.COffset(x, y)] = c[1]
+			img.Cr[img.COffset(x, y)] = c[2]
+		}
+	}
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75})
+	return buf.Bytes()
+}
+
+//The bitrate is estimated from the size of a frame
+func (this *testPatternSource) Probe() (utils.StreamMetadata, error) {
+	bitrate := len(this.frame(0)) * 8 * this.fps
+	return utils.StreamMetadata{
+		Bitrate: bitrate,
+		Tracks: []utils.TrackMetadata{{
+			Kind: utils.VideoTrack,
+			Codec: "mjpeg",
+			Bitrate: bitrate,
+			Width: this.width,
+			Height: this.height,
+			FrameRate: float64(this.fps),
+		}},
+	}, nil
+}
+
+func (this *testPatternSource) Start(offset time.Duration, out chan<- packet.StreamPacket, stop <-chan struct{}) (sdp.SessionDescription, <-chan error, error) {
+	session := sdp.SessionDescription{
+		Origin: sdp.Origin{Username: "-", NetworkType: "IN", AddressType: "IP4", UnicastAddress: "127.0.0.1"},
+		SessionName: "testpattern",
+		ConnectionInformation: &sdp.ConnectionInformation{NetworkType: "IN", AddressType: "IP4", Address: &sdp.Address{Address: "127.0.0.1"}},
+		TimeDescriptions: []sdp.TimeDescription{{}},
+	}
+	session.WithMedia(&sdp.MediaDescription{
+		MediaName: sdp.MediaName{Media: "video", Port: sdp.RangedPort{Value: 0}, Protos: []string{"RTP", "AVP"}, Formats: []string{strconv.Itoa(rtp.JPEGPayloadType)}},
+		Attributes: []sdp.Attribute{{Key: "rtpmap", Value: fmt.Sprintf("%d JPEG/%d", rtp.JPEGPayloadType, rtp.VideoClockRate)}},
+	})
+
+	go func() {
+		interval := time.Second / time.Duration(this.fps)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		packetizer := rtp.JPEGPacketizer{SSRC: utils.RandID()}
+		n := int(offset / interval)
+		for {
+			select {
+				case <-ticker.C:
+				case <-stop: return
+			}
+
+			packets, err := packetizer.Packetize(this.frame(n), uint32(n * rtp.VideoClockRate / this.fps))
+			utils.Warn(err)
+			for _, p := range packets {
+				select {
+					case out <- packet.StreamPacket{Type: packet.Video, Content: p}:
+					case <-stop: return
+				}
+			}
+			n++
+		}
+	}()
+
+	return session, nil, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/SLP25/ESR/internal/rtp"
+)
+
+//Frames of every shape are packetized into RTP/JPEG packets that parse back
+func TestTestPatternPackets(t *testing.T) {
+	sizes := [][2]int{{320, 240}, {16, 16}, {16, 64}, {16, 2032}, {2032, 16}}
+
+	for _, size := range sizes {
+		t.Run(fmt.Sprintf("%dx%d", size[0], size[1]), func(t *testing.T) {
+			source := NewTestPatternSource(size[0], size[1], 25).(*testPatternSource)
+			packetizer := rtp.JPEGPacketizer{SSRC: 7, Sequence: 65534, MaxPayload: 200}
+
+			for _, n := range []int{0, 1, 100} {
+				timestamp := uint32(n * rtp.VideoClockRate / source.fps)
+				packets, err := packetizer.Packetize(source.frame(n), timestamp)
+				if err != nil {
+					t.Fatalf("frame %d: %v", n, err)
+				} else if len(packets) == 0 {
+					t.Fatalf("frame %d: no packets", n)
+				}
+
+				offset := 0
+				for i, p := range packets {
+					h, payload, err := rtp.Parse(p)
+					if err != nil {
+						t.Fatalf("frame %d, packet %d: %v", n, i, err)
+					}
+					if h.PayloadType != rtp.JPEGPayloadType || h.SSRC != 7 || h.Timestamp != timestamp {
+						t.Errorf("frame %d, packet %d: header %+v", n, i, h)
+					}
+					if h.Marker != (i == len(packets) - 1) {
+						t.Errorf("frame %d, packet %d of %d: marker %v", n, i, len(packets), h.Marker)
+					}
+
+					//the JPEG header: fragment offset, then the size in blocks of 8 pixels
+					if got := int(payload[1]) << 16 | int(payload[2]) << 8 | int(payload[3]); got != offset {
+						t.Errorf("frame %d, packet %d: fragment offset %d, want %d", n, i, got, offset)
+					}
+					if int(payload[6]) * 8 != source.width || int(payload[7]) * 8 != source.height {
+						t.Errorf("frame %d, packet %d: %dx%d blocks for a %dx%d frame", n, i, payload[6], payload[7], source.width, source.height)
+					}
+
+					header := 8
+					if i == 0 {
+						header += 4 + int(binary.BigEndian.Uint16(payload[10:]))
+					}
+					offset += len(payload) - header
+				}
+			}
+		})
+	}
+}
+
+//Consecutive packets have consecutive sequence numbers, across frames and the wraparound
+func TestTestPatternSequence(t *testing.T) {
+	source := NewTestPatternSource(64, 64, 25).(*testPatternSource)
+	packetizer := rtp.JPEGPacketizer{SSRC: 7, Sequence: 65530, MaxPayload: 200}
+
+	var last uint16
+	first := true
+	for n := 0; n < 3; n++ {
+		packets, err := packetizer.Packetize(source.frame(n), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packets {
+			h, _, err := rtp.Parse(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !first && h.Sequence != last + 1 {
+				t.Errorf("sequence %d after %d", h.Sequence, last)
+			}
+			last, first = h.Sequence, false
+		}
+	}
+}
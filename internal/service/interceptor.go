@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"log/slog"
 	"net/netip"
+	"sync"
 
 	"github.com/SLP25/ESR/internal/packet"
 	"github.com/SLP25/ESR/internal/utils"
@@ -15,43 +17,86 @@ type interceptor struct {
 	accept checker
 	n int
 	ans chan Signal
+	mutex sync.Mutex
+	done chan struct{} //closed when the interceptor stops intercepting
 }
 
 func (this *interceptor) Handle(sig Signal) bool {
-	if this.accept(sig) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-		this.n -= 1
-		if this.n == 0 {
-			this.service.RemoveHandler(this)
-		}
+	if this.stopped() || !this.accept(sig) {
+		return false
+	}
 
-		select {
-			case this.ans <- sig:
-				if this.n == 0 {
-					close(this.ans)
-				}
-				return true
-			default:
-				slog.Warn("Interceptor hit its maximum buffer size. Signal dropped")
-				return true
-		}
+	this.n -= 1
+
+	select {
+		case this.ans <- sig:
+		default:
+			slog.Warn("Interceptor hit its maximum buffer size. Signal dropped")
+			this.service.dropped.Add(1)
+	}
+
+	if this.n == 0 {
+		this.stop()
 	}
+	return true
+}
+
+func (this *interceptor) stopped() bool {
+	select {
+		case <-this.done: return true
+		default: return false
+	}
+}
 
-	return false
+//Must be called with the mutex locked
+func (this *interceptor) stop() {
+	close(this.done)
+	this.service.RemoveHandler(this)
+	close(this.ans)
 }
 
 // Intercepts the first n signals that satisfy the condition
 // If n is non-positive, all signals that satisfy the condition are intercepted
 func Intercept(service *Service, accept checker, n int) <-chan Signal {
-	i := &interceptor{service: service, ans: make(chan Signal, min(n, 20)), accept: accept, n: n}
+	return InterceptContext(context.Background(), service, accept, n)
+}
+
+// Intercepts the first n signals that satisfy the condition, until the context is done.
+// If n is non-positive, all signals that satisfy the condition are intercepted.
+// The returned channel is closed once n signals are intercepted or the context is done
+func InterceptContext(ctx context.Context, service *Service, accept checker, n int) <-chan Signal {
+	bufSize := 20
+	if n > 0 { bufSize = min(n, bufSize) }
+
+	i := &interceptor{service: service, ans: make(chan Signal, bufSize), accept: accept, n: n, done: make(chan struct{})}
 	service.AddHandler(i)
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+				case <-i.done:
+				case <-ctx.Done():
+					i.mutex.Lock()
+					if !i.stopped() { i.stop() }
+					i.mutex.Unlock()
+			}
+		}()
+	}
+
 	return i.ans
 }
 
 // Intercepts the first n signals of the specified type
 // If n is non-positive, all signals of the specified type are intercepted
 func InterceptSignal[T Signal](service *Service, n int) <-chan T {
-	return utils.CastChan[Signal, T](Intercept(service, func(sig Signal) bool {
+	return InterceptSignalContext[T](context.Background(), service, n)
+}
+
+func InterceptSignalContext[T Signal](ctx context.Context, service *Service, n int) <-chan T {
+	return utils.CastChan[Signal, T](InterceptContext(ctx, service, func(sig Signal) bool {
 		_, ok := sig.(T)
 		return ok
 	}, n))
@@ -60,7 +105,11 @@ func InterceptSignal[T Signal](service *Service, n int) <-chan T {
 // Intercepts the first n messages from the specified remote address
 // If n is non-positive, all messages from the remote address are intercepted
 func InterceptMessages(service *Service, addr netip.AddrPort, n int) <-chan Message {
-	return utils.CastChan[Signal, Message](Intercept(service, func(sig Signal) bool {
+	return InterceptMessagesContext(context.Background(), service, addr, n)
+}
+
+func InterceptMessagesContext(ctx context.Context, service *Service, addr netip.AddrPort, n int) <-chan Message {
+	return utils.CastChan[Signal, Message](InterceptContext(ctx, service, func(sig Signal) bool {
 		msg, ok := sig.(Message)
 		return ok && utils.Matches(addr, msg.Addr())
 	}, n))
@@ -69,7 +118,11 @@ func InterceptMessages(service *Service, addr netip.AddrPort, n int) <-chan Mess
 // Intercepts the first n messages from the specified remote address
 // If n is non-positive, all messages from the remote address are intercepted
 func InterceptTCPMessages(service *Service, addr netip.AddrPort, n int) <-chan TCPMessage {
-	return utils.CastChan[Signal, TCPMessage](Intercept(service, func(sig Signal) bool {
+	return InterceptTCPMessagesContext(context.Background(), service, addr, n)
+}
+
+func InterceptTCPMessagesContext(ctx context.Context, service *Service, addr netip.AddrPort, n int) <-chan TCPMessage {
+	return utils.CastChan[Signal, TCPMessage](InterceptContext(ctx, service, func(sig Signal) bool {
 		msg, ok := sig.(TCPMessage)
 		return ok && utils.Matches(addr, msg.Addr())
 	}, n))
@@ -78,7 +131,11 @@ func InterceptTCPMessages(service *Service, addr netip.AddrPort, n int) <-chan T
 // Intercepts the first n messages from the specified remote address
 // If n is non-positive, all messages from the remote address are intercepted
 func InterceptUDPMessages(service *Service, localPort uint16, addr netip.AddrPort, n int) <-chan UDPMessage {
-	return utils.CastChan[Signal, UDPMessage](Intercept(service, func(sig Signal) bool {
+	return InterceptUDPMessagesContext(context.Background(), service, localPort, addr, n)
+}
+
+func InterceptUDPMessagesContext(ctx context.Context, service *Service, localPort uint16, addr netip.AddrPort, n int) <-chan UDPMessage {
+	return utils.CastChan[Signal, UDPMessage](InterceptContext(ctx, service, func(sig Signal) bool {
 		msg, ok := sig.(UDPMessage)
 		return ok && utils.MatchesPort(localPort, msg.localPort) && utils.Matches(addr, msg.Addr())
 	}, n))
@@ -87,7 +144,11 @@ func InterceptUDPMessages(service *Service, localPort uint16, addr netip.AddrPor
 // Intercepts the first n messages from the specified remote address
 // If n is non-positive, all messages from the remote address are intercepted
 func InterceptPackets[T packet.Packet](service *Service, addr netip.AddrPort, n int) <-chan T {
-	return utils.MapChan[Signal, T](Intercept(service, func(sig Signal) bool {
+	return InterceptPacketsContext[T](context.Background(), service, addr, n)
+}
+
+func InterceptPacketsContext[T packet.Packet](ctx context.Context, service *Service, addr netip.AddrPort, n int) <-chan T {
+	return utils.MapChan[Signal, T](InterceptContext(ctx, service, func(sig Signal) bool {
 		msg, ok := sig.(Message)
 		if !ok { return false }
 
@@ -101,7 +162,11 @@ func InterceptPackets[T packet.Packet](service *Service, addr netip.AddrPort, n
 // Intercepts the first n messages from the specified remote address
 // If n is non-positive, all messages from the remote address are intercepted
 func InterceptTCPPackets[T packet.Packet](service *Service, addr netip.AddrPort, n int) <-chan T {
-	return utils.MapChan[Signal, T](Intercept(service, func(sig Signal) bool {
+	return InterceptTCPPacketsContext[T](context.Background(), service, addr, n)
+}
+
+func InterceptTCPPacketsContext[T packet.Packet](ctx context.Context, service *Service, addr netip.AddrPort, n int) <-chan T {
+	return utils.MapChan[Signal, T](InterceptContext(ctx, service, func(sig Signal) bool {
 		msg, ok := sig.(TCPMessage)
 		if !ok { return false }
 
@@ -115,7 +180,11 @@ func InterceptTCPPackets[T packet.Packet](service *Service, addr netip.AddrPort,
 // Intercepts the first n messages from the specified remote address
 // If n is non-positive, all messages from the remote address are intercepted
 func InterceptUDPPackets[T packet.Packet](service *Service, localPort uint16, addr netip.AddrPort, n int) <-chan T {
-	return utils.MapChan[Signal, T](Intercept(service, func(sig Signal) bool {
+	return InterceptUDPPacketsContext[T](context.Background(), service, localPort, addr, n)
+}
+
+func InterceptUDPPacketsContext[T packet.Packet](ctx context.Context, service *Service, localPort uint16, addr netip.AddrPort, n int) <-chan T {
+	return utils.MapChan[Signal, T](InterceptContext(ctx, service, func(sig Signal) bool {
 		msg, ok := sig.(UDPMessage)
 		if !ok { return false }
 
@@ -126,40 +195,68 @@ func InterceptUDPPackets[T packet.Packet](service *Service, localPort uint16, ad
 	})
 }
 
+//Waits for the first value of a channel returned by an Intercept*Context function.
+//If the channel is closed before any value arrives, the context's error is returned
+func awaitResponse[T any](ctx context.Context, aux <-chan T) (T, error) {
+	ans, ok := <-aux
+	if !ok {
+		err := ctx.Err()
+		if err == nil { err = context.Canceled }
+		return ans, err
+	}
+	return ans, nil
+}
 
 
 func InterceptTCPResponse[T packet.Packet](service *Service, request packet.Packet, addr netip.AddrPort) (T, error) {
+	return InterceptTCPResponseContext[T](context.Background(), service, request, addr)
+}
+
+// Sends the request to the remote address and waits for a response of type T.
+// If the context is done before a response arrives, its error is returned (context.DeadlineExceeded on timeout)
+func InterceptTCPResponseContext[T packet.Packet](ctx context.Context, service *Service, request packet.Packet, addr netip.AddrPort) (T, error) {
 	var aux <-chan T
 	var err error
 	
 	service.PauseHandleWhile(func() {
 		err = service.TCPServer().SendConnect(request, addr) //TODO: allow other sends?
-		aux = InterceptTCPPackets[T](service, addr, 1)
+		if err == nil {
+			aux = InterceptTCPPacketsContext[T](ctx, service, addr, 1)
+		}
 	})
 
 	if err != nil {
 		return *new(T), err
 	} else {
-		return <-aux, nil
+		return awaitResponse(ctx, aux)
 	}
 }
 
 func InterceptUDPResponse[T packet.Packet](serv *Service, request packet.Packet, port uint16, addr netip.AddrPort) (T, error) {
+	return InterceptUDPResponseContext[T](context.Background(), serv, request, port, addr)
+}
+
+// Sends the request to the remote address from the given local port and waits for a response of type T.
+// If the context is done before a response arrives, its error is returned (context.DeadlineExceeded on timeout)
+func InterceptUDPResponseContext[T packet.Packet](ctx context.Context, serv *Service, request packet.Packet, port uint16, addr netip.AddrPort) (T, error) {
 	var aux <-chan T
 	var err error
 	
 	serv.PauseHandleWhile(func() {
-		err = SendUDP(request, addr)
-		if err != nil {
-			aux = InterceptUDPPackets[T](serv, port, addr, 1)
+		if server := serv.UDPServer(port); server != nil {
+			err = server.Send(request, addr)
+		} else {
+			err = serv.SendUDP(request, addr)
+		}
+
+		if err == nil {
+			aux = InterceptUDPPacketsContext[T](ctx, serv, port, addr, 1)
 		}
 	})
 
 	if err != nil {
 		return *new(T), err
 	} else {
-		return <-aux, nil
+		return awaitResponse(ctx, aux)
 	}
 }
-
-//TODO: timeout
\ No newline at end of file
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+)
+
+//Signals the service's Init
+type initHandler chan struct{}
+
+func (this initHandler) Handle(sig Signal) bool {
+	_, ok := sig.(Init)
+	if ok { close(this) }
+	return ok
+}
+
+//Runs a service on host A of an in-memory network, returning once it handles signals
+func runService(t *testing.T) *Service {
+	var service Service
+	service.SetTransport(NewMemNetwork().Host(hostA))
+	started := make(initHandler)
+	service.AddHandler(started)
+
+	done := make(chan error, 1)
+	go func() { done <- service.Run(nil) }()
+	select {
+		case <-started:
+		case err := <-done: t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		service.Close()
+		<-done
+	})
+	return &service
+}
+
+func intercepting(service *Service) bool {
+	return slices.Contains(service.HandlerNames(), "*service.interceptor")
+}
+
+//Waits for the interceptor's cleanup to remove it from the handler stack
+func waitRemoved(t *testing.T, service *Service) {
+	t.Helper()
+	for start := time.Now(); intercepting(service); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("the interceptor is still registered: %v", service.HandlerNames())
+		}
+	}
+}
+
+func TestInterceptCancel(t *testing.T) {
+	var service Service
+	ctx, cancel := context.WithCancel(context.Background())
+	ans := InterceptContext(ctx, &service, func(Signal) bool { return true }, 0)
+	if !intercepting(&service) {
+		t.Fatal("the interceptor wasn't registered")
+	}
+
+	cancel()
+	select {
+		case _, ok := <-ans:
+			if ok { t.Error("received a signal nobody sent") }
+		case <-time.After(time.Second):
+			t.Fatal("the channel wasn't closed once the context was cancelled")
+	}
+	waitRemoved(t, &service)
+}
+
+func TestInterceptResponseTimeout(t *testing.T) {
+	service := runService(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := InterceptUDPResponseContext[packet.Ping](ctx, service, packet.NewPing(), 0, netip.AddrPortFrom(hostB, 5000))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, want about the 50ms timeout", elapsed)
+	}
+	waitRemoved(t, service)
+}
+
+func TestInterceptResponseCancel(t *testing.T) {
+	service := runService(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := InterceptUDPResponseContext[packet.Ping](ctx, service, packet.NewPing(), 0, netip.AddrPortFrom(hostB, 5000))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("returned %v, want context.Canceled", err)
+	}
+	waitRemoved(t, service)
+}
+
+//A response arriving before the deadline is returned, and the interceptor removed
+func TestInterceptResponse(t *testing.T) {
+	service := runService(t)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		service.Enqueue(UDPMessage{packet: packet.NewPing(), addr: netip.AddrPortFrom(hostB, 5000)})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := InterceptUDPResponseContext[packet.Ping](ctx, service, packet.NewPing(), 0, netip.AddrPortFrom(hostB, 5000)); err != nil {
+		t.Errorf("returned %v, want the response", err)
+	}
+	waitRemoved(t, service)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"math/rand"
+	"net/netip"
+	"time"
+)
+
+// How long a packet may wait for the bandwidth of a link. Beyond that, UDP datagrams are dropped (tail drop)
+const memMaxQueueDelay = 200 * time.Millisecond
+
+// Default bucket size of a link with a bandwidth cap: one MTU, so packets are paced like on a physical link
+const memDefaultBurst = 1500
+
+// The conditions of the link between two hosts of a MemNetwork, in one direction
+type LinkConditions struct {
+	Latency time.Duration
+	Jitter time.Duration	//each packet is delayed by Latency plus a uniform value in [-Jitter, Jitter]
+	Loss float64			//fraction of UDP datagrams dropped, from 0 to 1. TCP is reliable
+	BurstLength float64		//mean length of a loss burst (Gilbert-Elliott model). Up to 1, losses are independent
+	Bandwidth int			//in bits per second, 0 for no cap
+	Burst int				//size of the token bucket in bytes, 0 for one MTU
+}
+
+// The state of a link in one direction
+type memLink struct {
+	LinkConditions
+	bad bool		//in a loss burst
+	tokens float64	//available bytes, negative while packets are queued
+	last time.Time	//last time the tokens were updated
+}
+
+func (this *memLink) burst() float64 {
+	if this.Burst > 0 {
+		return float64(this.Burst)
+	}
+	return memDefaultBurst
+}
+
+// Decides whether a UDP datagram is lost
+func (this *memLink) lose(random *rand.Rand) bool {
+	if this.Loss <= 0 {
+		return false
+	} else if this.BurstLength <= 1 || this.Loss >= 1 {
+		return random.Float64() < this.Loss
+	}
+
+	//Gilbert-Elliott: every packet of the bad state is lost, none of the good state.
+	//Leaving the bad state with probability r gives bursts of mean length 1/r,
+	//and entering it with probability p gives a loss rate of p/(p+r)
+	r := 1 / this.BurstLength
+	p := this.Loss * r / (1 - this.Loss)
+	if this.bad {
+		this.bad = random.Float64() >= r
+	} else {
+		this.bad = random.Float64() < p
+	}
+	return this.bad
+}
+
+// Takes the tokens for a packet, returning how long it waits for them.
+// If the wait would exceed the maximum queue delay and the packet can be dropped, no tokens are taken
+func (this *memLink) shape(size int, droppable bool) (bool, time.Duration) {
+	if this.Bandwidth <= 0 {
+		return false, 0
+	}
+
+	rate := float64(this.Bandwidth) / 8 //bytes per second
+	now := time.Now()
+	if !this.last.IsZero() {
+		this.tokens = min(this.burst(), this.tokens + now.Sub(this.last).Seconds() * rate)
+	} else {
+		this.tokens = this.burst()
+	}
+	this.last = now
+
+	var wait time.Duration
+	if missing := float64(size) - this.tokens; missing > 0 {
+		wait = time.Duration(missing / rate * float64(time.Second))
+	}
+	if droppable && wait > memMaxQueueDelay {
+		return true, 0
+	}
+
+	this.tokens -= float64(size)
+	return false, wait
+}
+
+func (this *memLink) jitter(random *rand.Rand) time.Duration {
+	if this.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(random.Int63n(int64(2 * this.Jitter) + 1)) - this.Jitter
+}
+
+type memLinkKey struct {
+	from netip.Addr
+	to netip.Addr
+}
+
+// Sets the conditions of the link between the two hosts, in both directions
+func (this *MemNetwork) SetLink(a netip.Addr, b netip.Addr, conditions LinkConditions) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.links[memLinkKey{from: a, to: b}] = &memLink{LinkConditions: conditions}
+	this.links[memLinkKey{from: b, to: a}] = &memLink{LinkConditions: conditions}
+}
+
+// Returns the conditions of the link from one host to the other
+func (this *MemNetwork) Link(from netip.Addr, to netip.Addr) LinkConditions {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if link, ok := this.links[memLinkKey{from: from, to: to}]; ok {
+		return link.LinkConditions
+	}
+	return this.Default
+}
+
+// Returns whether a packet of the given size should be dropped and how long it takes to arrive.
+// Only UDP datagrams are dropped
+func (this *MemNetwork) conditions(from netip.AddrPort, to netip.AddrPort, size int, udp bool) (bool, time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	key := memLinkKey{from: from.Addr(), to: to.Addr()}
+	link, ok := this.links[key]
+	if !ok {
+		link = &memLink{LinkConditions: this.Default}
+		this.links[key] = link
+	}
+
+	if udp && link.lose(this.random) {
+		return true, 0
+	}
+
+	drop, wait := link.shape(size, udp)
+	if drop {
+		return true, 0
+	}
+
+	return false, max(0, wait + link.Latency + link.jitter(this.random))
+}
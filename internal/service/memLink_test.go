@@ -0,0 +1,136 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//Returns the loss rate and the mean length of the loss bursts over many datagrams
+func lossStats(link *memLink, n int) (float64, float64) {
+	random := rand.New(rand.NewSource(1))
+	lost, bursts := 0, 0
+	inBurst := false
+	for i := 0; i < n; i++ {
+		if link.lose(random) {
+			lost++
+			if !inBurst { bursts++ }
+			inBurst = true
+		} else {
+			inBurst = false
+		}
+	}
+	return float64(lost) / float64(n), float64(lost) / float64(bursts)
+}
+
+func TestLinkLoss(t *testing.T) {
+	tests := []struct {
+		conditions LinkConditions
+		burst float64	//expected mean burst length
+	}{
+		{LinkConditions{Loss: 0.1}, 1 / 0.9},	//independent losses: bursts are geometric
+		{LinkConditions{Loss: 0.1, BurstLength: 1}, 1 / 0.9},
+		{LinkConditions{Loss: 0.1, BurstLength: 4}, 4},
+		{LinkConditions{Loss: 0.3, BurstLength: 10}, 10},
+	}
+
+	for _, test := range tests {
+		rate, burst := lossStats(&memLink{LinkConditions: test.conditions}, 500000)
+		if math.Abs(rate - test.conditions.Loss) > 0.01 {
+			t.Errorf("%+v: loss rate %.3f", test.conditions, rate)
+		}
+		if math.Abs(burst - test.burst) > test.burst * 0.05 {
+			t.Errorf("%+v: mean burst length %.2f, want %.2f", test.conditions, burst, test.burst)
+		}
+	}
+
+	if rate, _ := lossStats(&memLink{}, 1000); rate != 0 {
+		t.Errorf("lost %.3f of the datagrams of a lossless link", rate)
+	}
+	if rate, _ := lossStats(&memLink{LinkConditions: LinkConditions{Loss: 1, BurstLength: 5}}, 1000); rate != 1 {
+		t.Errorf("delivered %.3f of the datagrams of a link that loses them all", 1 - rate)
+	}
+}
+
+//Packets wait for the tokens of the bucket, and UDP datagrams that would wait too long are dropped
+func TestLinkShape(t *testing.T) {
+	//10000 bytes per second: a 1000 byte packet takes 100ms
+	link := &memLink{LinkConditions: LinkConditions{Bandwidth: 80000}}
+	near := func(got time.Duration, want time.Duration) bool {
+		return got >= want - 5 * time.Millisecond && got <= want
+	}
+
+	//the bucket starts full, with one MTU (1500 bytes), and then packets are paced
+	for i, want := range []time.Duration{0, 50 * time.Millisecond, 150 * time.Millisecond} {
+		if drop, wait := link.shape(1000, true); drop || !near(wait, want) {
+			t.Errorf("packet %d: dropped %v, waits %v, want %v", i, drop, wait, want)
+		}
+	}
+
+	//250ms exceed the maximum queue delay: datagrams are dropped without taking tokens, TCP segments wait
+	if drop, _ := link.shape(1000, true); !drop {
+		t.Error("a datagram queued beyond 200ms wasn't dropped")
+	}
+	if drop, wait := link.shape(1000, false); drop || !near(wait, 250 * time.Millisecond) {
+		t.Errorf("TCP segment: dropped %v, waits %v, want 250ms", drop, wait)
+	}
+
+	//the tokens refill at the link's rate
+	link = &memLink{LinkConditions: LinkConditions{Bandwidth: 80000, Burst: 1000}}
+	link.shape(1000, true)
+	time.Sleep(50 * time.Millisecond)
+	if _, wait := link.shape(1000, true); wait > 50 * time.Millisecond || wait < 40 * time.Millisecond {
+		t.Errorf("waits %v for the tokens after half of them refilled, want about 50ms", wait)
+	}
+
+	if drop, wait := (&memLink{}).shape(100000, true); drop || wait != 0 {
+		t.Errorf("a link without a cap dropped %v, waits %v", drop, wait)
+	}
+}
+
+//Datagrams through a capped link arrive at its rate, and the excess is dropped
+func TestLinkBandwidth(t *testing.T) {
+	network := NewMemNetwork()
+	network.SetLink(hostA, hostB, LinkConditions{Bandwidth: 80000})
+	a, _ := network.Host(hostA).ListenPacket(5000)
+	b, _ := network.Host(hostB).ListenPacket(5000)
+
+	//10 packets would take 1s, but only 200ms may be queued
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		a.WriteTo(make([]byte, 1000), b.LocalAddr())
+	}
+
+	received := 0
+	var last time.Duration
+	for {
+		b.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+		if _, _, err := b.ReadFrom(make([]byte, 1000)); err != nil {
+			break
+		}
+		received++
+		last = time.Since(start)
+	}
+
+	//1500 bytes of the bucket and the 2000 queued for 200ms
+	if received != 3 {
+		t.Errorf("received %d datagrams, want 3", received)
+	}
+	if last < 140 * time.Millisecond || last > 300 * time.Millisecond {
+		t.Errorf("the last datagram arrived after %v, want about 150ms", last)
+	}
+}
+
+func TestLinkConditions(t *testing.T) {
+	network := NewMemNetwork()
+	network.Default = LinkConditions{Latency: time.Millisecond}
+	network.SetLink(hostA, hostB, LinkConditions{Bandwidth: 1000})
+
+	if got := network.Link(hostB, hostA); got.Bandwidth != 1000 {
+		t.Errorf("conditions %+v from B to A, want the ones set from A to B", got)
+	}
+	if got := network.Link(hostA, hostC); got != network.Default {
+		t.Errorf("conditions %+v of a link never set, want the default %+v", got, network.Default)
+	}
+}
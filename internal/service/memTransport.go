@@ -0,0 +1,444 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+var errConnRefused = errors.New("connection refused")
+var errPortInUse = errors.New("address already in use")
+
+const firstEphemeralPort = 49152
+
+// An in-memory network connecting any number of hosts, for deterministic tests without sockets.
+// Every TCP segment and UDP datagram goes through the conditions of the link between the two hosts (see SetLink)
+type MemNetwork struct {
+	Default LinkConditions	//of the links without SetLink. Must be set before any traffic
+
+	mutex sync.Mutex
+	listeners map[netip.AddrPort]*memListener
+	packetConns map[netip.AddrPort]*memPacketConn
+	nextPort map[netip.Addr]uint16
+	links map[memLinkKey]*memLink
+	random *rand.Rand
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		listeners: make(map[netip.AddrPort]*memListener),
+		packetConns: make(map[netip.AddrPort]*memPacketConn),
+		nextPort: make(map[netip.Addr]uint16),
+		links: make(map[memLinkKey]*memLink),
+		random: rand.New(rand.NewSource(1)),
+	}
+}
+
+// Returns the transport of the host with the given address
+func (this *MemNetwork) Host(addr netip.Addr) Transport {
+	return memTransport{network: this, addr: addr}
+}
+
+// Seeds the random source used for loss and jitter, so runs can be reproduced
+func (this *MemNetwork) Seed(seed int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.random = rand.New(rand.NewSource(seed))
+}
+
+// TCP and UDP ports are independent, like in a real network stack. Must be called with the mutex locked
+func (this *MemNetwork) inUse(addr netip.AddrPort, udp bool) bool {
+	if udp {
+		_, ok := this.packetConns[addr]
+		return ok
+	}
+	_, ok := this.listeners[addr]
+	return ok
+}
+
+// Resolves port 0 to a free ephemeral port. Must be called with the mutex locked
+func (this *MemNetwork) allocate(addr netip.Addr, port uint16, udp bool) (netip.AddrPort, error) {
+	if port != 0 {
+		ans := netip.AddrPortFrom(addr, port)
+		if this.inUse(ans, udp) { return ans, errPortInUse }
+		return ans, nil
+	}
+
+	for i := 0; i < 65536 - firstEphemeralPort; i++ {
+		next := this.nextPort[addr]
+		if next < firstEphemeralPort { next = firstEphemeralPort }
+		this.nextPort[addr] = next + 1
+
+		ans := netip.AddrPortFrom(addr, next)
+		if !this.inUse(ans, udp) { return ans, nil }
+	}
+	return netip.AddrPort{}, errPortInUse
+}
+
+func (this *MemNetwork) deliver(from netip.AddrPort, to netip.AddrPort, data []byte) {
+	drop, delay := this.conditions(from, to, len(data), true)
+	if drop { return }
+
+	aux := make([]byte, len(data))
+	copy(aux, data)
+
+	time.AfterFunc(delay, func() {
+		this.mutex.Lock()
+		conn, ok := this.packetConns[to]
+		this.mutex.Unlock()
+
+		if ok { conn.push(memDatagram{from: from, data: aux}) }
+	})
+}
+
+
+type memTransport struct {
+	network *MemNetwork
+	addr netip.Addr
+}
+
+func (this memTransport) LocalAddr() netip.Addr {
+	return this.addr
+}
+
+func (this memTransport) Listen(port uint16) (net.Listener, error) {
+	this.network.mutex.Lock()
+	defer this.network.mutex.Unlock()
+
+	addr, err := this.network.allocate(this.addr, port, false)
+	if err != nil { return nil, err }
+
+	l := &memListener{network: this.network, addr: addr, conns: make(chan net.Conn, 16), done: make(chan struct{})}
+	this.network.listeners[addr] = l
+	return l, nil
+}
+
+func (this memTransport) ListenPacket(port uint16) (net.PacketConn, error) {
+	this.network.mutex.Lock()
+	defer this.network.mutex.Unlock()
+
+	addr, err := this.network.allocate(this.addr, port, true)
+	if err != nil { return nil, err }
+
+	c := &memPacketConn{network: this.network, addr: addr}
+	c.cond = sync.NewCond(&c.mutex)
+	this.network.packetConns[addr] = c
+	return c, nil
+}
+
+func (this memTransport) Dial(remote netip.AddrPort) (net.Conn, error) {
+	this.network.mutex.Lock()
+	l, ok := this.network.listeners[remote]
+	var local netip.AddrPort
+	var err error
+	if ok {
+		//outgoing connections don't reserve their ephemeral port, it only has to differ from the listeners'
+		local, err = this.network.allocate(this.addr, 0, false)
+	}
+	this.network.mutex.Unlock()
+
+	if !ok { return nil, errConnRefused }
+	if err != nil { return nil, err }
+
+	toRemote, toLocal := newMemPipe(), newMemPipe()
+	client := &memConn{network: this.network, local: local, remote: remote, in: toLocal, out: toRemote}
+	server := &memConn{network: this.network, local: remote, remote: local, in: toRemote, out: toLocal}
+
+	select {
+		case l.conns <- server:
+			return client, nil
+		case <-l.done:
+			return nil, errConnRefused
+	}
+}
+
+
+type memListener struct {
+	network *MemNetwork
+	addr netip.AddrPort
+	conns chan net.Conn
+	done chan struct{}
+	closing sync.Once
+}
+
+func (this *memListener) Accept() (net.Conn, error) {
+	select {
+		case c := <-this.conns: return c, nil
+		case <-this.done: return nil, net.ErrClosed
+	}
+}
+
+func (this *memListener) Close() error {
+	this.closing.Do(func() {
+		this.network.mutex.Lock()
+		delete(this.network.listeners, this.addr)
+		this.network.mutex.Unlock()
+		close(this.done)
+	})
+	return nil
+}
+
+func (this *memListener) Addr() net.Addr {
+	return net.TCPAddrFromAddrPort(this.addr)
+}
+
+
+type memChunk struct {
+	data []byte
+	at time.Time	//when the data becomes readable
+}
+
+// One direction of an in-memory TCP connection
+type memPipe struct {
+	mutex sync.Mutex
+	cond *sync.Cond
+	chunks []memChunk
+	last time.Time		//arrival of the last chunk, so data is never reordered
+	writerClosed bool	//the remote closed: reads return EOF once drained
+	readerClosed bool	//the local end closed
+	deadline time.Time
+}
+
+func newMemPipe() *memPipe {
+	p := &memPipe{}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (this *memPipe) write(b []byte, delay time.Duration) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.writerClosed || this.readerClosed {
+		return 0, io.ErrClosedPipe
+	}
+
+	at := time.Now().Add(delay)
+	if at.Before(this.last) { at = this.last }
+	this.last = at
+
+	data := make([]byte, len(b))
+	copy(data, b)
+	this.chunks = append(this.chunks, memChunk{data: data, at: at})
+
+	if delay > 0 {
+		this.wakeAfter(time.Until(at))
+	}
+	this.cond.Broadcast()
+	return len(b), nil
+}
+
+// Wakes the readers once the delay passes. The broadcast holds the mutex,
+// so a reader can't miss it between checking the chunks and waiting
+func (this *memPipe) wakeAfter(delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		this.mutex.Lock()
+		this.cond.Broadcast()
+		this.mutex.Unlock()
+	})
+}
+
+func (this *memPipe) read(b []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for {
+		now := time.Now()
+
+		if this.readerClosed {
+			return 0, net.ErrClosed
+		} else if len(this.chunks) != 0 && !now.Before(this.chunks[0].at) {
+			n := copy(b, this.chunks[0].data)
+			if n == len(this.chunks[0].data) {
+				this.chunks = this.chunks[1:]
+			} else {
+				this.chunks[0].data = this.chunks[0].data[n:]
+			}
+			return n, nil
+		} else if len(this.chunks) == 0 && this.writerClosed {
+			return 0, io.EOF
+		} else if !this.deadline.IsZero() && !now.Before(this.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		this.cond.Wait()
+	}
+}
+
+func (this *memPipe) setDeadline(t time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.deadline = t
+	if !t.IsZero() {
+		this.wakeAfter(time.Until(t))
+	}
+	this.cond.Broadcast()
+}
+
+func (this *memPipe) close(reader bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if reader {
+		this.readerClosed = true
+	} else {
+		this.writerClosed = true
+	}
+	this.cond.Broadcast()
+}
+
+
+type memConn struct {
+	network *MemNetwork
+	local netip.AddrPort
+	remote netip.AddrPort
+	in *memPipe
+	out *memPipe
+}
+
+func (this *memConn) Read(b []byte) (int, error) {
+	return this.in.read(b)
+}
+
+func (this *memConn) Write(b []byte) (int, error) {
+	_, delay := this.network.conditions(this.local, this.remote, len(b), false)
+	return this.out.write(b, delay)
+}
+
+func (this *memConn) Close() error {
+	this.in.close(true)
+	this.out.close(false)
+	return nil
+}
+
+func (this *memConn) LocalAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(this.local)
+}
+
+func (this *memConn) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(this.remote)
+}
+
+func (this *memConn) SetDeadline(t time.Time) error {
+	this.in.setDeadline(t)
+	return nil
+}
+
+func (this *memConn) SetReadDeadline(t time.Time) error {
+	this.in.setDeadline(t)
+	return nil
+}
+
+// Writes never block, so write deadlines have no effect
+func (this *memConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+
+type memDatagram struct {
+	from netip.AddrPort
+	data []byte
+}
+
+const memPacketQueueSize = 1024
+
+type memPacketConn struct {
+	network *MemNetwork
+	addr netip.AddrPort
+
+	mutex sync.Mutex
+	cond *sync.Cond
+	queue []memDatagram
+	closed bool
+	deadline time.Time
+}
+
+// Queues a received datagram. Like a socket buffer, datagrams are dropped when the queue is full
+func (this *memPacketConn) push(d memDatagram) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.closed && len(this.queue) < memPacketQueueSize {
+		this.queue = append(this.queue, d)
+		this.cond.Signal()
+	}
+}
+
+func (this *memPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for {
+		if this.closed {
+			return 0, nil, net.ErrClosed
+		} else if len(this.queue) != 0 {
+			d := this.queue[0]
+			this.queue = this.queue[1:]
+			return copy(b, d.data), net.UDPAddrFromAddrPort(d.from), nil
+		} else if !this.deadline.IsZero() && !time.Now().Before(this.deadline) {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+
+		this.cond.Wait()
+	}
+}
+
+func (this *memPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	to, err := netip.ParseAddrPort(addr.String())
+	if err != nil { return 0, err }
+
+	this.mutex.Lock()
+	closed := this.closed
+	this.mutex.Unlock()
+	if closed { return 0, net.ErrClosed }
+
+	this.network.deliver(this.addr, to, b)
+	return len(b), nil
+}
+
+func (this *memPacketConn) Close() error {
+	this.network.mutex.Lock()
+	delete(this.network.packetConns, this.addr)
+	this.network.mutex.Unlock()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.closed = true
+	this.cond.Broadcast()
+	return nil
+}
+
+func (this *memPacketConn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(this.addr)
+}
+
+func (this *memPacketConn) SetDeadline(t time.Time) error {
+	return this.SetReadDeadline(t)
+}
+
+func (this *memPacketConn) SetReadDeadline(t time.Time) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.deadline = t
+	if !t.IsZero() {
+		time.AfterFunc(time.Until(t), func() {
+			this.mutex.Lock()
+			this.cond.Broadcast()
+			this.mutex.Unlock()
+		})
+	}
+	this.cond.Broadcast()
+	return nil
+}
+
+// Writes never block, so write deadlines have no effect
+func (this *memPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	hostA = netip.MustParseAddr("10.0.0.1")
+	hostB = netip.MustParseAddr("10.0.0.2")
+)
+
+//Connects a client on host A to a listener on host B
+func dialMem(t *testing.T, network *MemNetwork) (client net.Conn, server net.Conn) {
+	l, err := network.Host(hostB).Listen(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	client, err = network.Host(hostA).Dial(netip.AddrPortFrom(hostB, 6000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func readAll(t *testing.T, conn net.Conn, n int) []byte {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestMemDial(t *testing.T) {
+	network := NewMemNetwork()
+	client, server := dialMem(t, network)
+
+	if got := server.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Errorf("server sees the client at %s, but it's at %s", got, client.LocalAddr())
+	}
+	if got := client.RemoteAddr().String(); got != "10.0.0.2:6000" {
+		t.Errorf("client connected to %s, want 10.0.0.2:6000", got)
+	}
+
+	client.Write([]byte("hello "))
+	client.Write([]byte("world"))
+	if got := string(readAll(t, server, 11)); got != "hello world" {
+		t.Errorf("server read %q", got)
+	}
+
+	server.Write([]byte("back"))
+	if got := string(readAll(t, client, 4)); got != "back" {
+		t.Errorf("client read %q", got)
+	}
+}
+
+func TestMemDialRefused(t *testing.T) {
+	network := NewMemNetwork()
+	if _, err := network.Host(hostA).Dial(netip.AddrPortFrom(hostB, 6000)); err == nil {
+		t.Error("dialed a port nobody listens on")
+	}
+
+	l, err := network.Host(hostB).Listen(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err := network.Host(hostA).Dial(netip.AddrPortFrom(hostB, 6000)); err == nil {
+		t.Error("dialed a closed listener")
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept on a closed listener returned %v, want net.ErrClosed", err)
+	}
+}
+
+func TestMemPorts(t *testing.T) {
+	network := NewMemNetwork()
+	host := network.Host(hostA)
+
+	a, err := host.ListenPacket(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := host.ListenPacket(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portA := a.LocalAddr().(*net.UDPAddr).Port
+	portB := b.LocalAddr().(*net.UDPAddr).Port
+	if portA < firstEphemeralPort || portB < firstEphemeralPort || portA == portB {
+		t.Errorf("ephemeral ports %d and %d, want distinct ports from %d", portA, portB, firstEphemeralPort)
+	}
+
+	if _, err := host.ListenPacket(uint16(portA)); err == nil {
+		t.Errorf("UDP port %d taken twice", portA)
+	}
+	if _, err := network.Host(hostB).ListenPacket(uint16(portA)); err != nil {
+		t.Errorf("the same port on another host: %v", err)
+	}
+
+	//TCP and UDP ports are independent
+	l, err := host.Listen(uint16(portA))
+	if err != nil {
+		t.Fatalf("TCP port %d of a UDP socket: %v", portA, err)
+	}
+	if _, err := host.Listen(uint16(portA)); err == nil {
+		t.Errorf("TCP port %d taken twice", portA)
+	}
+
+	a.Close()
+	l.Close()
+	if c, err := host.ListenPacket(uint16(portA)); err != nil {
+		t.Errorf("UDP port %d not released on close: %v", portA, err)
+	} else {
+		c.Close()
+	}
+	if l, err := host.Listen(uint16(portA)); err != nil {
+		t.Errorf("TCP port %d not released on close: %v", portA, err)
+	} else {
+		l.Close()
+	}
+}
+
+func TestMemDatagrams(t *testing.T) {
+	network := NewMemNetwork()
+	a, _ := network.Host(hostA).ListenPacket(5000)
+	b, _ := network.Host(hostB).ListenPacket(5000)
+
+	if _, err := a.WriteTo([]byte("ping"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 16)
+	b.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(buf[:n]) != "ping" || from.String() != "10.0.0.1:5000" {
+		t.Errorf("read %q from %s, want \"ping\" from 10.0.0.1:5000", buf[:n], from)
+	}
+
+	b.Close()
+	if _, _, err := b.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadFrom on a closed socket returned %v, want net.ErrClosed", err)
+	}
+	if _, err := b.WriteTo([]byte("x"), a.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("WriteTo on a closed socket returned %v, want net.ErrClosed", err)
+	}
+	if _, err := a.WriteTo([]byte("lost"), b.LocalAddr()); err != nil {
+		t.Errorf("datagrams to a closed socket are silently dropped, got %v", err)
+	}
+}
+
+func TestMemDeadlines(t *testing.T) {
+	network := NewMemNetwork()
+	client, _ := dialMem(t, network)
+	udp, _ := network.Host(hostA).ListenPacket(0)
+
+	start := time.Now()
+	client.SetReadDeadline(start.Add(50 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("TCP read returned %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	udp.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := udp.ReadFrom(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("UDP read returned %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100 * time.Millisecond || elapsed > time.Second {
+		t.Errorf("both deadlines expired after %v, want about 100ms", elapsed)
+	}
+
+	//a deadline in the past fails right away, and clearing it lets reads block again
+	client.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("TCP read with a past deadline returned %v", err)
+	}
+	client.SetReadDeadline(time.Time{})
+}
+
+func TestMemCloseAndEOF(t *testing.T) {
+	network := NewMemNetwork()
+	client, server := dialMem(t, network)
+
+	client.Write([]byte("bye"))
+	client.Close()
+
+	//the data written before closing is still delivered, then the reader gets EOF
+	if got := string(readAll(t, server, 3)); got != "bye" {
+		t.Errorf("server read %q", got)
+	}
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after the remote closed returned %v, want io.EOF", err)
+	}
+
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("read on a closed connection returned %v, want net.ErrClosed", err)
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("write on a closed connection succeeded")
+	}
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Error("write to a closed remote succeeded")
+	}
+}
+
+//A blocked read wakes up when delayed data becomes readable, without any other write
+func TestMemDelayedRead(t *testing.T) {
+	network := NewMemNetwork()
+	network.SetLink(hostA, hostB, LinkConditions{Latency: 30 * time.Millisecond})
+	client, server := dialMem(t, network)
+
+	for i := 0; i < 20; i++ {
+		start := time.Now()
+		client.Write([]byte{byte(i)})
+
+		server.SetReadDeadline(start.Add(time.Second))
+		b := readAll(t, server, 1)
+		if elapsed := time.Since(start); b[0] != byte(i) || elapsed < 30 * time.Millisecond {
+			t.Fatalf("read %d after %v, want %d after the 30ms latency", b[0], elapsed, i)
+		}
+	}
+}
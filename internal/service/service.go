@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/netip"
 	"reflect"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/SLP25/ESR/internal/packet"
 	"github.com/SLP25/ESR/internal/utils"
@@ -24,16 +27,46 @@ type handlerNode struct {
 }
 
 type Service struct {
+	transport Transport
+	sendConn net.PacketConn	//used to send UDP packets from an arbitrary port
 	handlers []*handlerNode
 	pauseMutex sync.Mutex
 	pauseCond *sync.Cond
 	paused int
 	handlersMutex sync.Mutex
 	udpServers map[uint16]*UDPServer
+	udpMutex sync.RWMutex
 	tcpServer TCPServer
 	sigQueue chan Signal
 	closed bool
 	closing sync.Mutex
+	dropped atomic.Uint64	//signals dropped by interceptors
+}
+
+// Sets the transport the service communicates through. By default, the real network is used.
+// Must be called before Run
+func (this *Service) SetTransport(transport Transport) {
+	this.transport = transport
+}
+
+func (this *Service) Transport() Transport {
+	if this.transport == nil {
+		return NetTransport{}
+	}
+	return this.transport
+}
+
+func (this *Service) LocalAddr() netip.Addr {
+	return this.Transport().LocalAddr()
+}
+
+// Sends a packet from an arbitrary port of the service's transport.
+// The same socket is reused for every packet
+func (this *Service) SendUDP(p packet.Packet, address netip.AddrPort) error {
+	if this.sendConn == nil {
+		return errors.New("Service.SendUDP(): service not running")
+	}
+	return sendTo(this.sendConn, p, address)
 }
 
 func (this *Service) TCPServer() *TCPServer {
@@ -41,23 +74,59 @@ func (this *Service) TCPServer() *TCPServer {
 }
 
 func (this *Service) UDPServer(port uint16) *UDPServer {
+	this.udpMutex.RLock()
+	defer this.udpMutex.RUnlock()
 	return this.udpServers[port]
 }
 
+// Returns the ports of the open UDP servers, sorted
+func (this *Service) UDPPorts() []uint16 {
+	this.udpMutex.RLock()
+	defer this.udpMutex.RUnlock()
+
+	ports := make([]uint16, 0, len(this.udpServers))
+	for port := range this.udpServers {
+		ports = append(ports, port)
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+// Returns the type names of the registered handlers, from the bottom of the stack to the top
+func (this *Service) HandlerNames() []string {
+	this.handlersMutex.Lock()
+	defer this.handlersMutex.Unlock()
+
+	names := make([]string, 0, len(this.handlers))
+	for _, h := range this.handlers {
+		names = append(names, reflect.TypeOf(h.Handler).String())
+	}
+	return names
+}
+
+// Returns the number of signals dropped because an interceptor's buffer was full
+func (this *Service) DroppedSignals() uint64 {
+	return this.dropped.Load()
+}
+
 func (this *Service) AddUDPServer(port *uint16) error {
+	this.udpMutex.Lock()
+	defer this.udpMutex.Unlock()
+
 	if port == nil {
 		return errors.New("Service.AddUDPServer(): Nil UDP port")
+	} else if this.udpServers == nil {
+		return errors.New("Service.AddUDPServer(): service not running")
 	} else if _, ok := this.udpServers[*port]; ok {
 		return errors.New(fmt.Sprint("Service.AddUDPServer(): Duplicated UDP ports:", *port))
 	}
 
 	var server UDPServer
-	err := server.Open(port)
+	err := server.OpenWith(this.Transport(), port)
 	if err != nil { return err }
 	go func() {
+		//the output is drained until the server closes, though it is dropped once the service is closed
 		for msg := range server.Output() {
-			if this.closed { return }
-			
 			packet, err := packet.Deserialize(bytes.NewReader(msg.Data))
 			if err != nil {
 				slog.Error("Error receiving UDP message from", "addr", msg.Source, "err", err)
@@ -66,7 +135,7 @@ func (this *Service) AddUDPServer(port *uint16) error {
 
 			localPort := netip.MustParseAddrPort(msg.Conn.LocalAddr().String()).Port()
 			//slog.Debug("Received UDP message", "addr", msg.Source, "packet", reflect.TypeOf(packet).Name(), "content", utils.Ellipsis(packet, 50))
-			this.sigQueue <- UDPMessage{packet: packet, localPort: localPort, addr: msg.Source, conn: msg.Conn}
+			this.Enqueue(UDPMessage{packet: packet, localPort: localPort, addr: msg.Source, conn: msg.Conn})
 		}
 	}()
 	this.udpServers[*port] = &server
@@ -74,7 +143,11 @@ func (this *Service) AddUDPServer(port *uint16) error {
 }
 
 func (this *Service) RemoveUDPServer(port uint16) error {
+	this.udpMutex.Lock()
+	defer this.udpMutex.Unlock()
+
 	if server, ok := this.udpServers[port]; ok {
+		delete(this.udpServers, port)
 		return server.Close()
 	} else {
 		return errors.New(fmt.Sprint("service.RemoveUDPServer(): called on closed port", port))
@@ -85,23 +158,41 @@ func (this *Service) RemoveUDPServer(port uint16) error {
 func (this *Service) Run(tcpPort *uint16, udpPorts... *uint16) error {
 	var err error
 
+	this.closing.Lock()
+	if this.closed {
+		this.closing.Unlock()
+		return nil
+	}
 	this.sigQueue = make(chan Signal, 20)
+	this.closing.Unlock()
+
+	this.udpMutex.Lock()
 	this.udpServers = make(map[uint16]*UDPServer)
-	err = this.tcpServer.Open(tcpPort)
+	this.udpMutex.Unlock()
+	err = this.tcpServer.OpenWith(this.Transport(), tcpPort)
 	if err != nil { return err }
+
+	this.sendConn, err = this.Transport().ListenPacket(0)
+	if err != nil {
+		utils.Warn(this.tcpServer.Close())
+		return err
+	}
 	go func() {
 		for msg := range this.tcpServer.Output() {
-			if this.closed { return }
-			this.sigQueue <- msg
+			this.Enqueue(msg)
 		}
 	}()
 
 	defer func() {
 		utils.Warn(this.tcpServer.Close())
+		utils.Warn(this.sendConn.Close())
 
+		this.udpMutex.Lock()
 		for _, server := range this.udpServers {
 			utils.Warn(server.Close())
 		}
+		this.udpServers = nil
+		this.udpMutex.Unlock()
 	}()
 
 	for _, port := range udpPorts {
@@ -132,7 +223,7 @@ func (this *Service) Close() {
 
 	if !this.closed {
 		this.closed = true
-		close(this.sigQueue)
+		if this.sigQueue != nil { close(this.sigQueue) }
 	}
 }
 
@@ -148,15 +239,21 @@ func (this *Service) Enqueue(s Signal) bool {
 }
 
 func (this *Service) AddHandler(h Handler) {
+	this.handlersMutex.Lock()
+	defer this.handlersMutex.Unlock()
+
 	this.handlers = append(this.handlers, &handlerNode{h, false})
 }
 
 // Removes the topmost instance of the specified handler from the handler stack
 func (this *Service) RemoveHandler(h Handler) bool {
+	this.handlersMutex.Lock()
+	defer this.handlersMutex.Unlock()
 
 	for i := len(this.handlers)-1; i >= 0; i-- {
 		if this.handlers[i].Handler == h {
-			this.handlers = append(this.handlers[:i], this.handlers[i+1:]...)
+			this.handlers[i].removed = true
+			this.handlers = append(this.handlers[:i:i], this.handlers[i+1:]...)
 			return true
 		}
 	}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"bytes"
 	"log/slog"
 	"net"
 	"net/netip"
@@ -101,15 +100,7 @@ func (this UDPMessage) Addr() netip.AddrPort {
 }
 
 func (this UDPMessage) SendResponse(p packet.Packet) error {
-	var buf bytes.Buffer
-	_, err := packet.Serialize(p, &buf)
-
-	addr, err := net.ResolveUDPAddr("udp", this.addr.String())
-	if err != nil { return err }
-
-	_, err = this.conn.WriteTo(buf.Bytes(), addr)
-	//slog.Debug("Sending UDP message", "packet", reflect.TypeOf(p).Name(), "content", utils.Ellipsis(p, 50), "addr", this.addr)
-	return err
+	return sendTo(this.conn, p, this.addr)
 }
 
 func (this UDPMessage) CloseConn() error {
@@ -2,12 +2,12 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/netip"
 	"reflect"
-	"strconv"
 	"sync"
 	"time"
 
@@ -18,33 +18,134 @@ import (
 type connection struct {
 	net.Conn
 	closed bool
+	capabilities packet.Capability	//capabilities advertised by the remote
+	outgoing bool	//established by this end
+	replaced bool	//closed in favour of another connection to the same remote. Guarded by TCPServer.connsMutex
+}
+
+const handshakeTimeout = 5 * time.Second
+
+//Exchanges Hello packets with the remote.
+//If the remote is incompatible (or doesn't answer in time), an error is returned
+func handshake(conn net.Conn) (packet.Capability, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	_, err := packet.Serialize(packet.NewHello(), conn)
+	if err != nil { return 0, err }
+
+	p, err := packet.Deserialize(conn)
+	if err != nil { return 0, err }
+
+	hello, ok := p.(packet.Hello)
+	if !ok {
+		return 0, errors.New("handshake: expected Hello, received " + reflect.TypeOf(p).Name())
+	}
+
+	return hello.Capabilities, hello.Check()
 }
 
 type TCPServer struct {
+	transport Transport
 	output chan Signal
 	listener net.Listener
 	conns map[netip.Addr]*connection
+	dialing map[netip.Addr]chan struct{}	//closed once the connection being established to the remote is registered or fails
 	connsMutex sync.RWMutex
+	outputMutex sync.RWMutex	//held to send to output, so it isn't closed meanwhile
+	done chan struct{}	//closed on Close, releasing the senders blocked on output
+	closeOnce sync.Once
 	closed bool
 }
 
 // Establishes a TCP connection to the specified remote address.
-// If such connection is already established, nothing happens (this method is idempotent)
+// If such connection is already established, nothing happens (this method is idempotent).
+// If it is being established by another call, waits for it
 func (this *TCPServer) Connect(addr netip.AddrPort) error {
 	this.connsMutex.Lock()
-	defer this.connsMutex.Unlock()
+	if _, ok := this.conns[addr.Addr()]; ok {
+		this.connsMutex.Unlock()
+		return nil
+	}
+	if dialing, ok := this.dialing[addr.Addr()]; ok {
+		this.connsMutex.Unlock()
+		<-dialing
+		if _, ok := this.Capabilities(addr.Addr()); !ok {
+			return errors.New("Error connecting to " + addr.String() + ": concurrent connection attempt failed")
+		}
+		return nil
+	}
+	dialing := make(chan struct{})
+	this.dialing[addr.Addr()] = dialing
+	this.connsMutex.Unlock()
 
-	_, ok := this.conns[addr.Addr()]
-	if ok { return nil }
+	//the lock isn't held while dialing and exchanging Hellos, which can take up to handshakeTimeout
+	c, err := this.dial(addr)
 
+	this.connsMutex.Lock()
+	delete(this.dialing, addr.Addr())
+	close(dialing)
+	if err == nil && this.register(addr.Addr(), c) {
+		go this.handleConnection(c)
+	}
+	this.connsMutex.Unlock()
+	return err
+}
+
+func (this *TCPServer) dial(addr netip.AddrPort) (*connection, error) {
 	slog.Info("Connecting to remote", "addr", addr)
-	conn, err := net.Dial("tcp", addr.String())
-	if err != nil { return err }
+	conn, err := this.transport.Dial(addr)
+	if err != nil { return nil, err }
 
-	c := &connection{conn, false}
-	this.conns[addr.Addr()] = c
-	go this.handleConnection(c)
-	return nil
+	caps, err := handshake(conn)
+	if err != nil {
+		utils.Warn(conn.Close())
+		return nil, fmt.Errorf("Handshake with %s failed: %w", addr, err)
+	}
+
+	return &connection{Conn: conn, capabilities: caps, outgoing: true}, nil
+}
+
+//Stores the connection as the one to the remote. If there is one already, only one of them is kept, and the other is closed:
+//a connection accepted from the remote replaces an older one accepted from it (the remote reconnected), and
+//if both ends connected to each other at the same time, the one established by the lower address is kept, so both ends agree.
+//Returns false if the given connection was the one closed.
+//Must be called with connsMutex locked
+func (this *TCPServer) register(remote netip.Addr, c *connection) bool {
+	old, ok := this.conns[remote]
+	if ok {
+		keepNew := !c.outgoing
+		if c.outgoing != old.outgoing {
+			local := netip.MustParseAddrPort(c.LocalAddr().String()).Addr()
+			keepNew = c.outgoing == local.Less(remote)
+		}
+
+		if !keepNew {
+			slog.Info("Closing duplicate TCP connection", "addr", c.RemoteAddr())
+			c.closed = true
+			utils.Warn(c.Close())
+			return false
+		}
+
+		slog.Info("Replacing TCP connection", "addr", old.RemoteAddr())
+		old.replaced = true
+		old.closed = true
+		utils.Warn(old.Close())
+	}
+
+	this.conns[remote] = c
+	return true
+}
+
+// Returns the capabilities advertised by the remote in the handshake.
+// The second return value is false if no connection to the remote is established
+func (this *TCPServer) Capabilities(addr netip.Addr) (packet.Capability, bool) {
+	this.connsMutex.RLock()
+	defer this.connsMutex.RUnlock()
+
+	c, ok := this.conns[addr]
+	if !ok { return 0, false }
+	return c.capabilities, true
 }
 
 // Sends a packet to the specified remote address.
@@ -114,12 +215,35 @@ func (this *TCPServer) SendSingle(p packet.Packet, addr netip.AddrPort) error {
 }
 
 
+// Sends a single packet to the remote address through a new connection and returns the first packet answered,
+// for tools that talk to a daemon without running a service. The connection is closed afterwards
+func Exchange(transport Transport, p packet.Packet, addr netip.AddrPort, timeout time.Duration) (packet.Packet, error) {
+	conn, err := transport.Dial(addr)
+	if err != nil { return nil, err }
+	defer conn.Close()
+
+	_, err = handshake(conn)
+	if err != nil { return nil, fmt.Errorf("Handshake with %s failed: %w", addr, err) }
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = packet.Serialize(p, conn)
+	if err != nil { return nil, err }
+
+	return packet.Deserialize(conn)
+}
+
 func (this *TCPServer) Open(port *uint16) error {
+	return this.OpenWith(NetTransport{}, port)
+}
+
+// Listens and establishes connections through the given transport.
+// If the port is nil, no connections are accepted
+func (this *TCPServer) OpenWith(transport Transport, port *uint16) error {
 	var err error
-	*this = TCPServer{output: make(chan Signal), conns: make(map[netip.Addr]*connection)}
+	*this = TCPServer{transport: transport, output: make(chan Signal), conns: make(map[netip.Addr]*connection), dialing: make(map[netip.Addr]chan struct{}), done: make(chan struct{})}
 
 	if port != nil {
-		this.listener, err = net.Listen("tcp", ":" + strconv.FormatUint(uint64(*port), 10))
+		this.listener, err = transport.Listen(*port)
 		if err != nil {
 			return err
 		}
@@ -133,10 +257,13 @@ func (this *TCPServer) Open(port *uint16) error {
 }
 
 func (this *TCPServer) Close() error {
-	if !this.closed {
+	this.closeOnce.Do(func() {
+		close(this.done)
+		this.outputMutex.Lock()
 		this.closed = true
 		close(this.output)
-	}
+		this.outputMutex.Unlock()
+	})
 	
 	if this.listener != nil {
 		return this.listener.Close()
@@ -150,8 +277,14 @@ func (this *TCPServer) Output() chan Signal {
 }
 
 func (this *TCPServer) sendOutput(msg Signal) {
+	this.outputMutex.RLock()
+	defer this.outputMutex.RUnlock()
+
 	if !this.closed {
-		this.output <- msg
+		select {
+			case this.output <- msg:
+			case <-this.done:
+		}
 	}
 }
 
@@ -160,9 +293,11 @@ func (this *TCPServer) handle() {
 		conn, err := this.listener.Accept()
 		if err != nil {
 
-			if this.closed {
-				slog.Info("Closed TCP listener")
-				return
+			select {
+				case <-this.done:
+					slog.Info("Closed TCP listener")
+					return
+				default:
 			}
 
 			slog.Error("Error accepting connection", "err", err)
@@ -175,12 +310,23 @@ func (this *TCPServer) handle() {
 			continue
 		}
 
-		this.connsMutex.Lock()
-		c := &connection{conn, false}
-		this.conns[addr.Addr()] = c
-		this.connsMutex.Unlock()
+		go func() {
+			caps, err := handshake(conn)
+			if err != nil {
+				slog.Error("Rejected TCP connection", "addr", addr, "err", err)
+				utils.Warn(conn.Close())
+				return
+			}
 
-		go this.handleConnection(c)
+			c := &connection{Conn: conn, capabilities: caps}
+			this.connsMutex.Lock()
+			registered := this.register(addr.Addr(), c)
+			this.connsMutex.Unlock()
+
+			if registered {
+				this.handleConnection(c)
+			}
+		}()
 	}
 }
 
@@ -188,18 +334,25 @@ func (this *TCPServer) handleConnection(c *connection) {
 	remote := netip.MustParseAddrPort(c.RemoteAddr().String())
 	slog.Info("Listening for TCP messages from", "addr", c.RemoteAddr())
 
-	this.output <- TCPConnected{c}
+	this.sendOutput(TCPConnected{c})
 	defer func() {
 		slog.Info("Stopped listening for TCP messages from", "addr", c.RemoteAddr())
 		c.closed = true
 		this.connsMutex.Lock()
-		delete(this.conns, remote.Addr())
+		replaced := c.replaced
+		if this.conns[remote.Addr()] == c {
+			delete(this.conns, remote.Addr())
+		}
 		this.connsMutex.Unlock()
-		this.sendOutput(TCPDisconnected{remote})
+
+		//the remote is still connected through the connection that replaced this one
+		if !replaced {
+			this.sendOutput(TCPDisconnected{remote})
+		}
 	}()
 
 	for {
-		packet, err := packet.Deserialize(c)
+		p, err := packet.Deserialize(c)
 		time.Sleep(time.Millisecond * 10)
 
 		if errors.Is(err, io.EOF) { //closed by remote
@@ -207,13 +360,16 @@ func (this *TCPServer) handleConnection(c *connection) {
 			return
 		} else if c.closed { //closed by local
 			return
+		} else if errors.As(err, new(packet.UnknownTypeError)) { //the body was skipped, so the stream is still aligned
+			slog.Warn("Ignoring TCP message of unknown type", "addr", c.RemoteAddr(), "err", err)
+			continue
 		} else if err != nil {
 			slog.Error("Error receiving TCP message from", "addr", c.RemoteAddr(), "err", err)
 			utils.Warn(c.Close())
 			return
 		}
 
-		slog.Debug("Received TCP message", "addr", c.RemoteAddr(), "packet", reflect.TypeOf(packet).Name(), "content", utils.Ellipsis(packet, 50))
-		this.sendOutput(TCPMessage{packet: packet, conn: c})
+		slog.Debug("Received TCP message", "addr", c.RemoteAddr(), "packet", reflect.TypeOf(p).Name(), "content", utils.Ellipsis(p, 50))
+		this.sendOutput(TCPMessage{packet: p, conn: c})
 	}
 }
\ No newline at end of file
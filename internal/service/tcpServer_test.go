@@ -0,0 +1,246 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+)
+
+var hostC = netip.MustParseAddr("10.0.0.3")
+
+//Opens a TCP server on the host, collecting what it outputs
+func openTCP(t *testing.T, network *MemNetwork, addr netip.Addr) (*TCPServer, <-chan Signal) {
+	var server TCPServer
+	port := uint16(6000)
+	if err := server.OpenWith(network.Host(addr), &port); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	signals := make(chan Signal, 100)
+	go func() {
+		for sig := range server.Output() {
+			signals <- sig
+		}
+	}()
+	return &server, signals
+}
+
+//Returns the next signal output, or nil if none arrives in time
+func nextSignal(signals <-chan Signal, timeout time.Duration) Signal {
+	select {
+		case sig := <-signals: return sig
+		case <-time.After(timeout): return nil
+	}
+}
+
+var incompatibleHellos = []struct {
+	name string
+	hello packet.Hello
+}{
+	{"version mismatch", packet.Hello{Version: packet.ProtocolVersion + 1, Capabilities: packet.LocalCapabilities}},
+	{"missing capability", packet.Hello{Version: packet.ProtocolVersion, Capabilities: packet.CapJSONEncoding}},
+}
+
+//A remote that sends an incompatible Hello is disconnected before anything is output
+func TestHandshakeRejectsAccepted(t *testing.T) {
+	for _, test := range incompatibleHellos {
+		t.Run(test.name, func(t *testing.T) {
+			network := NewMemNetwork()
+			_, signals := openTCP(t, network, hostB)
+
+			conn, err := network.Host(hostA).Dial(netip.AddrPortFrom(hostB, 6000))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			packet.Serialize(test.hello, conn)
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if p, err := packet.Deserialize(conn); err != nil || p != packet.NewHello() {
+				t.Fatalf("server answered %v (%v), want its Hello", p, err)
+			}
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("read after the handshake returned %v, want io.EOF", err)
+			}
+			if sig := nextSignal(signals, 50 * time.Millisecond); sig != nil {
+				t.Errorf("output %T for a rejected connection", sig)
+			}
+		})
+	}
+}
+
+//Connecting to a remote that sends an incompatible Hello fails, and no connection is kept
+func TestHandshakeRejectsConnected(t *testing.T) {
+	for _, test := range incompatibleHellos {
+		t.Run(test.name, func(t *testing.T) {
+			network := NewMemNetwork()
+			server, _ := openTCP(t, network, hostA)
+
+			l, err := network.Host(hostB).Listen(6000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil { return }
+				packet.Serialize(test.hello, conn)
+			}()
+
+			err = server.Connect(netip.AddrPortFrom(hostB, 6000))
+			var handshakeErr packet.HandshakeError
+			if !errors.As(err, &handshakeErr) || handshakeErr.Remote != test.hello {
+				t.Errorf("Connect returned %v, want a HandshakeError with the remote's Hello", err)
+			}
+			if _, ok := server.Capabilities(hostB); ok {
+				t.Error("kept the connection to an incompatible remote")
+			}
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	network := NewMemNetwork()
+	a, _ := openTCP(t, network, hostA)
+	_, signals := openTCP(t, network, hostB)
+
+	if err := a.Connect(netip.AddrPortFrom(hostB, 6000)); err != nil {
+		t.Fatal(err)
+	}
+	if caps, ok := a.Capabilities(hostB); !ok || caps != packet.LocalCapabilities {
+		t.Errorf("capabilities %s (%v), want %s", caps, ok, packet.LocalCapabilities)
+	}
+	if _, ok := nextSignal(signals, time.Second).(TCPConnected); !ok {
+		t.Error("the remote didn't output TCPConnected")
+	}
+}
+
+//A slow handshake doesn't hold up the other connections
+func TestConnectWithoutLock(t *testing.T) {
+	network := NewMemNetwork()
+	server, _ := openTCP(t, network, hostA)
+
+	//hostB accepts, but never answers the Hello
+	l, err := network.Host(hostB).Listen(6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil { accepted <- conn }
+	}()
+
+	connected := make(chan error, 1)
+	go func() { connected <- server.Connect(netip.AddrPortFrom(hostB, 6000)) }()
+	conn := <-accepted
+
+	done := make(chan struct{})
+	go func() {
+		server.Send(packet.NewPing(), hostC)
+		server.CloseConn(hostC)
+		server.Capabilities(hostC)
+		close(done)
+	}()
+	select {
+		case <-done:
+		case <-time.After(time.Second): t.Error("sending to another remote waited for the handshake")
+	}
+
+	conn.Close()
+	if err := <-connected; err == nil {
+		t.Error("connected to a remote that closed during the handshake")
+	}
+}
+
+//Both ends connecting to each other at the same time keep the same connection
+func TestSimultaneousConnect(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		network := NewMemNetwork()
+		a, signalsA := openTCP(t, network, hostA)
+		b, signalsB := openTCP(t, network, hostB)
+
+		errs := make(chan error, 2)
+		go func() { errs <- a.Connect(netip.AddrPortFrom(hostB, 6000)) }()
+		go func() { errs <- b.Connect(netip.AddrPortFrom(hostA, 6000)) }()
+		if err := errors.Join(<-errs, <-errs); err != nil {
+			t.Fatal(err)
+		}
+
+		//wait for the duplicate to be closed on both ends
+		time.Sleep(20 * time.Millisecond)
+		a.connsMutex.RLock()
+		b.connsMutex.RLock()
+		connA, connB := a.conns[hostB], b.conns[hostA]
+		b.connsMutex.RUnlock()
+		a.connsMutex.RUnlock()
+		if connA == nil || connB == nil || connA.LocalAddr().String() != connB.RemoteAddr().String() {
+			t.Fatalf("the ends kept different connections: %v and %v", connA, connB)
+		}
+
+		if err := a.Send(packet.NewPing(), hostB); err != nil {
+			t.Fatal(err)
+		}
+		received := false
+		for !received {
+			switch nextSignal(signalsB, time.Second).(type) {
+				case TCPMessage: received = true
+				case TCPDisconnected: t.Fatal("the duplicate connection was output as a disconnection")
+				case nil: t.Fatal("the remote didn't receive the packet")
+			}
+		}
+		for len(signalsA) != 0 {
+			if _, ok := (<-signalsA).(TCPDisconnected); ok {
+				t.Fatal("the duplicate connection was output as a disconnection")
+			}
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+//A remote that connects again replaces its old connection, which is closed
+func TestReconnectReplaces(t *testing.T) {
+	network := NewMemNetwork()
+	server, signals := openTCP(t, network, hostB)
+
+	dial := func() net.Conn {
+		conn, err := network.Host(hostA).Dial(netip.AddrPortFrom(hostB, 6000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet.Serialize(packet.NewHello(), conn)
+		packet.Deserialize(conn)
+		if _, ok := nextSignal(signals, time.Second).(TCPConnected); !ok {
+			t.Fatal("the connection wasn't output")
+		}
+		return conn
+	}
+
+	old := dial()
+	defer old.Close()
+	conn := dial()
+	defer conn.Close()
+
+	old.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := old.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read on the replaced connection returned %v, want io.EOF", err)
+	}
+	if sig := nextSignal(signals, 50 * time.Millisecond); sig != nil {
+		t.Errorf("output %T when replacing the connection", sig)
+	}
+
+	server.connsMutex.RLock()
+	current := server.conns[hostA]
+	server.connsMutex.RUnlock()
+	if current == nil || current.RemoteAddr().String() != conn.LocalAddr().String() {
+		t.Errorf("kept %v, want the new connection from %s", current, conn.LocalAddr())
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Provides the sockets a service communicates through.
+// A transport represents a single host: everything it opens shares the same local address
+type Transport interface {
+	// The address of the host. May be invalid, meaning all local addresses
+	LocalAddr() netip.Addr
+
+	// Listens for TCP connections on the given port (0 picks a free port)
+	Listen(port uint16) (net.Listener, error)
+
+	// Opens a UDP socket on the given port (0 picks a free port)
+	ListenPacket(port uint16) (net.PacketConn, error)
+
+	// Establishes a TCP connection to the remote address
+	Dial(addr netip.AddrPort) (net.Conn, error)
+}
+
+// The operating system's network stack.
+// If Addr is valid, sockets are bound to it, otherwise to all local addresses
+type NetTransport struct {
+	Addr netip.Addr
+}
+
+func (this NetTransport) LocalAddr() netip.Addr {
+	return this.Addr
+}
+
+func (this NetTransport) address(port uint16) string {
+	if this.Addr.IsValid() {
+		return netip.AddrPortFrom(this.Addr, port).String()
+	}
+	return ":" + strconv.FormatUint(uint64(port), 10)
+}
+
+func (this NetTransport) Listen(port uint16) (net.Listener, error) {
+	return net.Listen("tcp", this.address(port))
+}
+
+func (this NetTransport) ListenPacket(port uint16) (net.PacketConn, error) {
+	return net.ListenPacket("udp", this.address(port))
+}
+
+func (this NetTransport) Dial(addr netip.AddrPort) (net.Conn, error) {
+	dialer := net.Dialer{}
+	if this.Addr.IsValid() {
+		dialer.LocalAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(this.Addr, 0))
+	}
+	return dialer.Dial("tcp", addr.String())
+}
@@ -6,7 +6,7 @@ import (
 	"log/slog"
 	"net"
 	"net/netip"
-	"strconv"
+	"sync/atomic"
 
 	"github.com/SLP25/ESR/internal/packet"
 )
@@ -20,33 +20,35 @@ type UDPPacket struct {
 type UDPServer struct {
 	output chan UDPPacket
 	conn net.PacketConn
-	closed bool
+	closed atomic.Bool
 }
 
-// Sends a packet from an arbitrary port to specified remote address
-func SendUDP(p packet.Packet, address netip.AddrPort) error {
-	//slog.Debug("Sending UDP message", "packet", reflect.TypeOf(p).Name(), "content", utils.Ellipsis(p, 50), "addr", address)
-	
-	conn, err := net.Dial("udp", address.String())
+// Sends a packet through the socket to the specified remote address
+func sendTo(conn net.PacketConn, p packet.Packet, address netip.AddrPort) error {
+	var buf bytes.Buffer
+	_, err := packet.Serialize(p, &buf)
 	if err != nil { return err }
 
-	_, err2 := packet.Serialize(p, conn)
-	err3 := conn.Close()
-
-	if err2 != nil { return err2 }
-	return err3
+	_, err = conn.WriteTo(buf.Bytes(), net.UDPAddrFromAddrPort(address))
+	//slog.Debug("Sending UDP message", "packet", reflect.TypeOf(p).Name(), "content", utils.Ellipsis(p, 50), "addr", address)
+	return err
 }
 
 
 func (this *UDPServer) Open(port *uint16) error {
+	return this.OpenWith(NetTransport{}, port)
+}
+
+// Opens the UDP socket through the given transport
+func (this *UDPServer) OpenWith(transport Transport, port *uint16) error {
 	if port == nil {
 		return errors.New("UDPServer.open(): Nil port")
 	}
 
 	var err error
-	*this = UDPServer{output: make(chan UDPPacket), closed: false}
+	*this = UDPServer{output: make(chan UDPPacket)}
 
-	this.conn, err = net.ListenPacket("udp", ":" + strconv.FormatUint(uint64(*port), 10))
+	this.conn, err = transport.ListenPacket(*port)
 	if err != nil {
 		return err
 	}
@@ -59,8 +61,7 @@ func (this *UDPServer) Open(port *uint16) error {
 }
 
 func (this *UDPServer) Close() error {
-	if !this.closed {
-		this.closed = true
+	if this.closed.CompareAndSwap(false, true) {
 		return this.conn.Close()
 	} else {
 		return nil
@@ -68,15 +69,7 @@ func (this *UDPServer) Close() error {
 }
 
 func (this *UDPServer) Send(p packet.Packet, address netip.AddrPort) error {
-	var buf bytes.Buffer
-	_, err := packet.Serialize(p, &buf)
-
-	addr, err := net.ResolveUDPAddr("udp", address.String())
-	if err != nil { return err }
-
-	_, err = this.conn.WriteTo(buf.Bytes(), addr)
-	//slog.Debug("Sending UDP message", "packet", reflect.TypeOf(p).Name(), "content", utils.Ellipsis(p, 50), "addr", address)
-	return err
+	return sendTo(this.conn, p, address)
 }
 
 func (this *UDPServer) Output() chan UDPPacket {
@@ -97,7 +90,7 @@ func (this *UDPServer) handle() {
 			this.output <- UDPPacket{Source: addrport, Conn: this.conn, Data: ans}
 		}
 
-		if this.closed {
+		if this.closed.Load() {
 			slog.Info("Closed UDP listener")
 			close(this.output)
 			return
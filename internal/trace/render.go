@@ -0,0 +1,197 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//Returns the name of the machine, followed by its address if it has a name
+func (this *Tree) label(addr netip.Addr) string {
+	if name, ok := this.Names[addr]; ok {
+		return name + " (" + addr.String() + ")"
+	}
+	return addr.String()
+}
+
+//Returns the subscribers of the hop, one per machine, sorted
+func (this *Tree) children(addr netip.Addr) []netip.Addr {
+	ans := []netip.Addr{}
+	for _, s := range this.Hops[addr].Subscribers {
+		if !slices.Contains(ans, s.Addr()) {
+			ans = append(ans, s.Addr())
+		}
+	}
+	slices.SortFunc(ans, func(a netip.Addr, b netip.Addr) int { return a.Compare(b) })
+	return ans
+}
+
+//Returns the hops that aren't fed by another traced hop: the servers, and the nodes whose chain broke
+func (this *Tree) roots() []netip.Addr {
+	fed := make(map[netip.Addr]bool)
+	for addr := range this.Hops {
+		for _, c := range this.children(addr) {
+			fed[c] = true
+		}
+	}
+
+	ans := []netip.Addr{}
+	for addr, h := range this.Hops {
+		if h.Running && !fed[addr] {
+			ans = append(ans, addr)
+		}
+	}
+	slices.SortFunc(ans, func(a netip.Addr, b netip.Addr) int { return a.Compare(b) })
+	return ans
+}
+
+func formatMetrics(m utils.Metrics) string {
+	ans := fmt.Sprintf("%v, %.1f%% loss", m.Latency.Round(10 * time.Microsecond), m.PacketLoss * 100)
+	if m.Bandwidth != 0 {
+		ans += fmt.Sprintf(", %.1fMbps", float64(m.Bandwidth) / 1e6)
+	}
+	return ans
+}
+
+//Describes the state of a hop, for both renderings
+func (this *Tree) describe(addr netip.Addr) string {
+	h, ok := this.Hops[addr]
+	if !ok {
+		return "" //a subscriber that wasn't traced, like a client
+	}
+
+	parts := []string{}
+	if h.Server {
+		parts = append(parts, "server")
+	} else {
+		parts = append(parts, fmt.Sprintf("node %08x", h.ID))
+	}
+	parts = append(parts, fmt.Sprintf("subscribers: %d", len(h.Subscribers)))
+	if h.UpstreamRTT != 0 {
+		parts = append(parts, "rtt to upstream " + h.UpstreamRTT.Round(10 * time.Microsecond).String())
+	}
+	if h.Error != "" {
+		parts = append(parts, h.Error)
+	}
+	return strings.Join(parts, ", ")
+}
+
+//Writes the tree as indented text, from the servers down to the clients.
+//Each hop shows the metrics of the link from its upstream
+func (this *Tree) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "stream %s\n", this.StreamID)
+
+	visited := make(map[netip.Addr]bool)
+	var walk func(addr netip.Addr, indent string, last bool, root bool)
+	walk = func(addr netip.Addr, indent string, last bool, root bool) {
+		prefix, next := "", indent
+		if !root {
+			prefix, next = indent + "├─ ", indent + "│  "
+			if last {
+				prefix, next = indent + "└─ ", indent + "   "
+			}
+		}
+
+		line := prefix + this.label(addr)
+		if h, ok := this.Hops[addr]; ok && !root {
+			line += " [" + formatMetrics(h.Link) + "]"
+		}
+		if d := this.describe(addr); d != "" {
+			line += ": " + d
+		}
+
+		if visited[addr] {
+			fmt.Fprintln(w, line + " (loop)")
+			return
+		}
+		visited[addr] = true
+		fmt.Fprintln(w, line)
+
+		children := this.children(addr)
+		for i, c := range children {
+			walk(c, next, i == len(children) - 1, false)
+		}
+	}
+
+	for _, r := range this.roots() {
+		walk(r, "", true, true)
+	}
+
+	idle := []string{}
+	for addr, h := range this.Hops {
+		if !h.Running {
+			idle = append(idle, this.label(addr) + ": " + h.Error)
+		}
+	}
+	slices.Sort(idle)
+	for _, s := range idle {
+		fmt.Fprintln(w, "idle " + s)
+	}
+
+	unreachable := []string{}
+	for addr, err := range this.Errors {
+		unreachable = append(unreachable, addr.String() + ": " + err.Error())
+	}
+	slices.Sort(unreachable)
+	for _, s := range unreachable {
+		fmt.Fprintln(w, "unreachable " + s)
+	}
+}
+
+//Writes the tree as a Graphviz digraph, with an edge from every upstream to its subscribers
+func (this *Tree) WriteDOT(w io.Writer) {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+	}
+
+	fmt.Fprintf(w, "digraph %s {\n", quote(this.StreamID))
+	fmt.Fprintln(w, "    rankdir=TB;")
+
+	nodes := make(map[netip.Addr]bool)
+	for addr, h := range this.Hops {
+		if !h.Running { continue }
+		nodes[addr] = true
+		for _, c := range this.children(addr) {
+			nodes[c] = true
+		}
+	}
+
+	sorted := make([]netip.Addr, 0, len(nodes))
+	for addr := range nodes {
+		sorted = append(sorted, addr)
+	}
+	slices.SortFunc(sorted, func(a netip.Addr, b netip.Addr) int { return a.Compare(b) })
+
+	for _, addr := range sorted {
+		label := this.label(addr)
+		if d := this.describe(addr); d != "" {
+			label += "\n" + d
+		}
+
+		shape := "ellipse"
+		if h, ok := this.Hops[addr]; !ok {
+			shape = "plaintext"
+		} else if h.Server {
+			shape = "box"
+		} else if h.Error != "" {
+			shape = "octagon"
+		}
+		fmt.Fprintf(w, "    %s [shape=%s, label=%s];\n", quote(addr.String()), shape, strings.ReplaceAll(quote(label), "\n", `\n`))
+	}
+
+	for _, addr := range sorted {
+		for _, c := range this.children(addr) {
+			attrs := ""
+			if h, ok := this.Hops[c]; ok {
+				attrs = " [label=" + quote(formatMetrics(h.Link)) + "]"
+			}
+			fmt.Fprintf(w, "    %s -> %s%s;\n", quote(addr.String()), quote(c.String()), attrs)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
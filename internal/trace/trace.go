@@ -0,0 +1,96 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"time"
+
+	"github.com/SLP25/ESR/internal/packet"
+	"github.com/SLP25/ESR/internal/service"
+	"github.com/SLP25/ESR/internal/utils"
+)
+
+//A hop of a traced chain, with the address it was reached at
+type Hop struct {
+	Addr netip.AddrPort
+	packet.TraceHop
+}
+
+//Sends a trace to the node (or server) and returns the chain from it up to the server
+func Trace(transport service.Transport, addr netip.AddrPort, streamID string, timeout time.Duration) ([]Hop, error) {
+	req := packet.TraceRequest{StreamID: streamID, RequestID: utils.RandID(), Timeout: timeout}
+	p, err := service.Exchange(transport, req, addr, timeout)
+	if err != nil { return nil, err }
+
+	resp, ok := p.(packet.TraceResponse)
+	if !ok {
+		return nil, errors.New("trace: expected TraceResponse, received " + reflect.TypeOf(p).Name())
+	} else if resp.RequestID != req.RequestID {
+		return nil, fmt.Errorf("trace: response to request %d instead of %d", resp.RequestID, req.RequestID)
+	}
+
+	hops := make([]Hop, 0, len(resp.Hops))
+	for i, h := range resp.Hops {
+		if i != 0 {
+			addr = resp.Hops[i - 1].Upstream
+		}
+		hops = append(hops, Hop{Addr: addr, TraceHop: h})
+	}
+	return hops, nil
+}
+
+//The distribution tree of a stream, merged from the chains traced from several nodes
+type Tree struct {
+	StreamID string
+	Hops map[netip.Addr]Hop
+	Names map[netip.Addr]string	//optional names of the machines, used when rendering
+	Errors map[netip.AddrPort]error	//nodes that couldn't be traced
+}
+
+func NewTree(streamID string) *Tree {
+	return &Tree{
+		StreamID: streamID,
+		Hops: make(map[netip.Addr]Hop),
+		Names: make(map[netip.Addr]string),
+		Errors: make(map[netip.AddrPort]error),
+	}
+}
+
+//Merges a traced chain into the tree.
+//A hop present in several chains keeps the state of the last one added
+func (this *Tree) Add(hops []Hop) {
+	for _, h := range hops {
+		this.Hops[h.Addr.Addr()] = h
+	}
+}
+
+//Traces the stream from every given address and merges the chains into a tree.
+//Tracing from every node of the overlay gives the whole tree, clients included as leaves
+func Collect(transport service.Transport, streamID string, addrs []netip.AddrPort, timeout time.Duration) *Tree {
+	tree := NewTree(streamID)
+
+	type result struct {
+		addr netip.AddrPort
+		hops []Hop
+		err error
+	}
+	results := make(chan result, len(addrs))
+	for _, addr := range addrs {
+		go func(addr netip.AddrPort) {
+			hops, err := Trace(transport, addr, streamID, timeout)
+			results <- result{addr: addr, hops: hops, err: err}
+		}(addr)
+	}
+
+	for range addrs {
+		r := <-results
+		if r.err != nil {
+			tree.Errors[r.addr] = r.err
+		} else {
+			tree.Add(r.hops)
+		}
+	}
+	return tree
+}
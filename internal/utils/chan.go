@@ -7,6 +7,7 @@ func CastChan[T any, U any](from <-chan T) chan U {
 		for val = range from {
 			to <- val.(U)
 		}
+		close(to)
 	}()
 	return to
 }
@@ -17,6 +18,7 @@ func MapChan[T any, U any](from <-chan T, f func(T) U) chan U {
 		for val := range from {
 			to <- f(val)
 		}
+		close(to)
 	}()
 	return to
 }
\ No newline at end of file
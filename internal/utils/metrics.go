@@ -1,25 +1,140 @@
 package utils
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Metrics struct {
 	Latency time.Duration	//in ms
 	PacketLoss float64 //from 0 to 1
-	Bandwidth int
+	Bandwidth int	//in bits per second, 0 if unknown
 }
 
+//Metrics of a path made of this path followed by m.
+//The bandwidth is the one of the bottleneck, ignoring unknown bandwidths
 func (this Metrics) Compose(m Metrics) Metrics {
 	return Metrics{
 		Latency: this.Latency + m.Latency,
 		PacketLoss: 1 - (1 - this.PacketLoss) * (1 - m.PacketLoss),
-		/*, Bandwith: min(this.Bandwith, m.Bandwith)*/}
+		Bandwidth: minBandwidth(this.Bandwidth, m.Bandwidth),
+	}
+}
+
+func minBandwidth(a int, b int) int {
+	if a == 0 {
+		return b
+	} else if b == 0 {
+		return a
+	}
+	return min(a, b)
 }
 
 func (this Metrics) BetterThan(m Metrics) bool {
-	return float64(this.Latency.Milliseconds()) + this.PacketLoss * 5000 <= float64(m.Latency.Milliseconds()) + m.PacketLoss * 5000
+	return cost(this) <= cost(m)
+}
+
+//Cost of the metrics with the current cost function: the lower, the better
+func (this Metrics) Cost() float64 {
+	return cost(this)
+}
+
+
+//Cost of a path used to compare metrics: the lower, the better
+type CostFunction func(Metrics) float64
+
+//Latency in ms, plus 50ms per percent of packet loss
+func DefaultCost(m Metrics) float64 {
+	return float64(m.Latency.Milliseconds()) + m.PacketLoss * 5000
+}
+
+func LatencyCost(m Metrics) float64 {
+	return float64(m.Latency) / float64(time.Millisecond)
+}
+
+func LossCost(m Metrics) float64 {
+	return m.PacketLoss
+}
+
+//The wider the bottleneck, the better. An unknown bandwidth is the worst
+func BandwidthCost(m Metrics) float64 {
+	return -float64(m.Bandwidth)
+}
+
+var costFunctions = map[string]CostFunction{
+	"default": DefaultCost,
+	"latency": LatencyCost,
+	"loss": LossCost,
+	"bandwidth": BandwidthCost,
+}
+
+var cost CostFunction = DefaultCost
+
+//Changes the cost function used by BetterThan. Must be called before any comparison
+func SetCostFunction(f CostFunction) {
+	cost = f
+}
+
+//Returns the cost function with the given name (default, latency, loss or bandwidth)
+func CostFunctionByName(name string) (CostFunction, bool) {
+	f, ok := costFunctions[name]
+	return f, ok
 }
 
 
 type StreamMetadata struct {
-	Bitrate int
+	Bitrate int	//of all tracks, in bits per second. Used for admission on the links
+	Duration time.Duration	//0 if unknown or the stream is unbounded (a live source)
+	Tracks []TrackMetadata
+}
+
+//Kinds of tracks, as named by ffprobe
+const (
+	VideoTrack = "video"
+	AudioTrack = "audio"
+)
+
+//A track of a stream. Fields ffprobe didn't report are left at 0 (or "")
+type TrackMetadata struct {
+	Kind string	//video, audio, subtitle, data, ...
+	Codec string
+	Bitrate int	//in bits per second
+	Width int	//video only
+	Height int
+	FrameRate float64
+	SampleRate int	//audio only, in Hz
+	Channels int
+}
+
+//Returns the first track of the given kind
+func (this StreamMetadata) Track(kind string) (TrackMetadata, bool) {
+	for _, t := range this.Tracks {
+		if t.Kind == kind {
+			return t, true
+		}
+	}
+	return TrackMetadata{}, false
+}
+
+//Describes the track in a few words, e.g. "h264 1280x720 30fps" or "aac 48000Hz 2ch"
+func (this TrackMetadata) String() string {
+	parts := []string{this.Kind}
+	if this.Codec != "" {
+		parts[0] = this.Codec
+	}
+	if this.Width != 0 && this.Height != 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", this.Width, this.Height))
+	}
+	if this.FrameRate != 0 {
+		parts = append(parts, strconv.FormatFloat(this.FrameRate, 'f', -1, 64) + "fps")
+	}
+	if this.SampleRate != 0 {
+		parts = append(parts, strconv.Itoa(this.SampleRate) + "Hz")
+	}
+	if this.Channels != 0 {
+		parts = append(parts, strconv.Itoa(this.Channels) + "ch")
+	}
+	return strings.Join(parts, " ")
 }
\ No newline at end of file
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCostFunctions(t *testing.T) {
+	m := Metrics{Latency: 20 * time.Millisecond, PacketLoss: 0.01, Bandwidth: 1000000}
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"default", 20 + 50},	//50ms per percent of loss
+		{"latency", 20},
+		{"loss", 0.01},
+		{"bandwidth", -1000000},
+	}
+
+	for _, test := range tests {
+		f, ok := CostFunctionByName(test.name)
+		if !ok {
+			t.Errorf("no %s cost function", test.name)
+		} else if got := f(m); got != test.want {
+			t.Errorf("%s cost %v, want %v", test.name, got, test.want)
+		}
+	}
+	if _, ok := CostFunctionByName("cheapest"); ok {
+		t.Error("found a cost function that doesn't exist")
+	}
+}
+
+func TestBetterThan(t *testing.T) {
+	t.Cleanup(func() { SetCostFunction(DefaultCost) })
+
+	fast := Metrics{Latency: 10 * time.Millisecond, PacketLoss: 0.02, Bandwidth: 1000000}
+	reliable := Metrics{Latency: 50 * time.Millisecond, PacketLoss: 0, Bandwidth: 5000000}
+	tests := []struct {
+		cost CostFunction
+		better Metrics
+		worse Metrics
+	}{
+		{DefaultCost, reliable, fast},	//2% of loss cost as much as 100ms
+		{LatencyCost, fast, reliable},
+		{LossCost, reliable, fast},
+		{BandwidthCost, reliable, fast},
+		{BandwidthCost, fast, Metrics{}},	//an unknown bandwidth is the worst
+	}
+
+	for i, test := range tests {
+		SetCostFunction(test.cost)
+		if !test.better.BetterThan(test.worse) || test.worse.BetterThan(test.better) {
+			t.Errorf("%d: %+v isn't better than %+v", i, test.better, test.worse)
+		}
+	}
+
+	//equal metrics are as good as each other
+	if !fast.BetterThan(fast) {
+		t.Error("metrics aren't as good as themselves")
+	}
+}
+
+func TestCompose(t *testing.T) {
+	a := Metrics{Latency: 10 * time.Millisecond, PacketLoss: 0.5, Bandwidth: 1000000}
+	b := Metrics{Latency: 30 * time.Millisecond, PacketLoss: 0.5, Bandwidth: 200000}
+
+	if got := a.Compose(b); got != (Metrics{Latency: 40 * time.Millisecond, PacketLoss: 0.75, Bandwidth: 200000}) {
+		t.Errorf("composed %+v", got)
+	}
+	if got := a.Compose(Metrics{}); got.Bandwidth != a.Bandwidth {
+		t.Errorf("bandwidth %d composed with an unknown one, want %d", got.Bandwidth, a.Bandwidth)
+	}
+}
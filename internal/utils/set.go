@@ -1,5 +1,7 @@
 package utils
 
+import "time"
+
 type set[V comparable] map[V]bool
 type Set[V comparable] set[V]
 	
@@ -42,4 +44,51 @@ func (this Set[V]) ToSlice() []V {
 		i++
 	}
 	return ans
-}
\ No newline at end of file
+}
+
+//A set that forgets its elements a while after they were added, for IDs that only need to be told apart for a while.
+//Like Set, it isn't safe for concurrent use
+type ExpiringSet[V comparable] struct {
+	ttl time.Duration
+	added map[V]time.Time
+	lastSweep time.Time
+}
+
+func NewExpiringSet[V comparable](ttl time.Duration) *ExpiringSet[V] {
+	return &ExpiringSet[V]{ttl: ttl, added: make(map[V]time.Time), lastSweep: time.Now()}
+}
+
+//Adds the element, or renews it if present
+func (this *ExpiringSet[V]) Add(val V) {
+	now := time.Now()
+	this.sweep(now)
+	this.added[val] = now
+}
+
+func (this *ExpiringSet[V]) Contains(val V) bool {
+	t, ok := this.added[val]
+	return ok && time.Since(t) < this.ttl
+}
+
+//Number of elements that haven't expired yet
+func (this *ExpiringSet[V]) Length() int {
+	this.sweep(time.Now())
+	ans := 0
+	for v := range this.added {
+		if this.Contains(v) { ans++ }
+	}
+	return ans
+}
+
+//Forgets the expired elements. Runs at most once per ttl, so adding stays cheap
+//and the set never holds more than the elements added in the last two ttls
+func (this *ExpiringSet[V]) sweep(now time.Time) {
+	if now.Sub(this.lastSweep) < this.ttl { return }
+	this.lastSweep = now
+
+	for v, t := range this.added {
+		if now.Sub(t) >= this.ttl {
+			delete(this.added, v)
+		}
+	}
+}
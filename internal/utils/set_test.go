@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiringSet(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	set := NewExpiringSet[uint32](ttl)
+
+	set.Add(1)
+	if !set.Contains(1) || set.Contains(2) {
+		t.Fatal("the set doesn't hold exactly what was added")
+	}
+
+	time.Sleep(ttl)
+	set.Add(2)
+	if set.Contains(1) {
+		t.Error("an element outlived its ttl")
+	}
+	if !set.Contains(2) || set.Length() != 1 {
+		t.Errorf("%d elements after one expired, want 1", set.Length())
+	}
+
+	//adding again renews the element
+	time.Sleep(ttl / 2)
+	set.Add(2)
+	time.Sleep(ttl / 2)
+	if !set.Contains(2) {
+		t.Error("a renewed element expired")
+	}
+
+	//the expired elements are dropped, not only hidden
+	time.Sleep(ttl)
+	set.Add(3)
+	if len(set.added) != 1 {
+		t.Errorf("%d elements stored, want only the one added last", len(set.added))
+	}
+}
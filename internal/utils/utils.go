@@ -9,7 +9,6 @@ import (
 	"net/netip"
 	"os"
 	"strconv"
-	"time"
 )
 
 type ServiceType byte
@@ -46,8 +45,9 @@ func Contains[T comparable](list []T, item T) bool {
 	return false
 }
 
+//Uses the global source, so concurrent callers (e.g. several services in one process) get distinct IDs
 func RandID() uint32 {
-	return rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+	return rand.Uint32()
 }
 
 func Ellipsis(val any, maxLen int) string {